@@ -1,6 +1,11 @@
 package api
 
-import "fmt"
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
 
 const (
 	// Version is API version
@@ -15,6 +20,23 @@ const (
 	// - GET method lists all users
 	UsersCall = "users"
 
+	// UserCall is admin API call on single user:
+	// - DELETE method deletes user, his personal room and memberships
+	// - PATCH method updates user with UserUpdate
+	UserCall = "users/{name}"
+
+	// UserSuspendCall [POST] suspends user, suspended user can't use chat
+	UserSuspendCall = "users/{name}/suspend"
+
+	// UserResumeCall [POST] resumes suspended user
+	UserResumeCall = "users/{name}/resume"
+
+	// LoginCall [POST] exchanges user AuthID and token for session token
+	LoginCall = "login"
+
+	// LogoutCall [POST] revokes session token used for request
+	LogoutCall = "logout"
+
 	// PingCall [GET] is for checking if API is online
 	PingCall = "ping"
 
@@ -33,16 +55,85 @@ const (
 	// RoomsExitCall [POST] is for exiting from room
 	RoomsExitCall = "rooms/exit"
 
+	// RoomsMessagesCall [GET] returns page of room message history
+	// query parameters: before, after - message IDs, limit - page size
+	RoomsMessagesCall = "rooms/{name}/messages"
+
+	// RoomsKickCall [POST] removes user from room
+	RoomsKickCall = "rooms/{name}/kick"
+
+	// RoomsBanCall [POST] removes user from room and prevents him from joining
+	RoomsBanCall = "rooms/{name}/ban"
+
+	// RoomsUnbanCall [POST] lets banned user join room again
+	RoomsUnbanCall = "rooms/{name}/unban"
+
+	// RoomsMuteCall [POST] prevents user from posting for duration,
+	// zero duration unmutes
+	RoomsMuteCall = "rooms/{name}/mute"
+
+	// RoomsPromoteCall [POST] makes room member moderator
+	RoomsPromoteCall = "rooms/{name}/promote"
+
+	// RoomsDemoteCall [POST] makes room moderator regular member
+	RoomsDemoteCall = "rooms/{name}/demote"
+
+	// RoomsTransferCall [POST] makes room member owner of room
+	RoomsTransferCall = "rooms/{name}/transfer"
+
+	// RoomsInviteCall [POST] lets user join invite-only or password protected room
+	RoomsInviteCall = "rooms/{name}/invite"
+
+	// RoomsAcceptCall [POST] joins room user was invited to
+	RoomsAcceptCall = "rooms/{name}/accept"
+
+	// InvitesCall [GET] lists rooms user was invited to
+	InvitesCall = "invites"
+
+	// DirectCall [GET] returns number of unread direct messages by sender
+	DirectCall = "direct"
+
+	// DirectUserCall [POST] sends direct message to user
+	DirectUserCall = "direct/{name}"
+
+	// DirectMessagesCall [GET] returns page of direct messages exchanged
+	// with user and marks them read, query parameters as in RoomsMessagesCall
+	DirectMessagesCall = "direct/{name}/messages"
+
 	// MessageCall [POST] posts single message [GET] retrieves all messages
 	MessageCall = "message"
 
 	// WsPath is websocket path
 	WsPath = "ws"
 
+	// EventsCall [GET] streams events of user as Server-Sent Events,
+	// stream resumes after Last-Event-ID header, or starts with reset
+	// event if the ID was given by other node or before restart
+	EventsCall = "events"
+
 	// StatsCall returns JSON statistics
 	StatsCall = "stats"
+
+	// ClusterEventsCall [POST] applies changes made on other cluster node
+	ClusterEventsCall = "cluster/events"
+
+	// ClusterPostCall [POST] posts message forwarded by other cluster node
+	ClusterPostCall = "cluster/post"
+
+	// ClusterRoomCall [POST] changes room on request of other cluster node
+	ClusterRoomCall = "cluster/room"
+
+	// ClusterUserCall [POST] changes user on request of other cluster node
+	ClusterUserCall = "cluster/user"
+
+	// ClusterStateCall [GET] returns state to node joining cluster
+	ClusterStateCall = "cluster/state"
 )
 
+// MetricsPath [GET] returns metrics in Prometheus text format,
+// it is outside of API root where Prometheus looks by default
+const MetricsPath = "/metrics"
+
 // User is structure for manipulating user related calls
 type User struct {
 	Name   string `json:"name"`
@@ -51,23 +142,76 @@ type User struct {
 	Token  string `json:"token"`
 	// Rooms represents rooms user is currently joined in
 	Rooms []string `json:"rooms"`
+	// DisplayName is name shown to other users
+	DisplayName string `json:"display_name,omitempty"`
+	// Suspended user can't use chat
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+// Session is token authorizing requests as bearer instead of user
+// AuthID and token, it is valid until Expires or logout
+type Session struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// UserUpdate describes changes of user, empty fields are left unchanged
+type UserUpdate struct {
+	Role        string `json:"role,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Token       string `json:"token,omitempty"`
+	// RotateToken makes server generate new token,
+	// it is returned in response
+	RotateToken bool `json:"rotate_token,omitempty"`
 }
 
 // Room is structure for manipulating room related calls
 type Room struct {
-	Name    string   `json:"name"`
-	Creator string   `json:"creator"`
-	Users   []string `json:"users"`
+	Name string `json:"name"`
+	// Creator is room owner
+	Creator    string   `json:"creator"`
+	Users      []string `json:"users"`
+	Moderators []string `json:"moderators,omitempty"`
+	// Access is one of public, invite or password, personal rooms
+	// are created with users and only listed to their owners
+	Access string `json:"access,omitempty"`
+	// Password is set when creating or joining protected room,
+	// it is never listed
+	Password string `json:"password,omitempty"`
+}
+
+// RoomAction is moderation of user in room
+type RoomAction struct {
+	User string `json:"user"`
+	// Duration of mute in seconds
+	Duration uint `json:"duration,omitempty"`
 }
 
 // Message represents message exchanged by users
 type Message struct {
-	User    string `json:"user"`
-	Room    string `json:"room"`
+	// ID is assigned by server, IDs grow in order of posting
+	ID uint64 `json:"id,omitempty"`
+	// Seq is assigned by server, it grows by one with each message in room,
+	// direct messages are numbered separately in each direction
+	Seq uint64 `json:"seq,omitempty"`
+	// ClientID is optional ID set by sender, message with ClientID
+	// already posted by the same user is not delivered again
+	ClientID string `json:"client_id,omitempty"`
+	User     string `json:"user"`
+	Room     string `json:"room"`
+	// To is recipient of direct message, Room is empty then
+	To      string `json:"to,omitempty"`
 	Content string `json:"content"`
+	// Time is set by server when message is received
+	Time time.Time `json:"time"`
 }
 
 // GetPath returns path to API call
 func GetPath(path string) string {
 	return fmt.Sprintf("/%s/%s/%s/", RootPath, Version, path)
 }
+
+// RoomCall returns API call on room or user with name
+func RoomCall(call, name string) string {
+	return strings.Replace(call, "{name}", url.PathEscape(name), 1)
+}
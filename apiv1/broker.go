@@ -0,0 +1,333 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transports delivering messages to users
+const (
+	PollTransport      = "poll"
+	WebsocketTransport = "websocket"
+)
+
+// Overflow policies applied when subscriber queue is full
+const (
+	// DropOldest drops the oldest queued message to make room for new one
+	DropOldest = "drop-oldest"
+	// DropNewest drops new message
+	DropNewest = "drop-newest"
+	// Disconnect closes subscription of slow consumer
+	Disconnect = "disconnect"
+)
+
+const (
+	// defaultQueueSize is number of messages queued for single subscriber
+	defaultQueueSize = 256
+	// defaultMaxPollWait caps poll wait unless SetMaxPollWait is called
+	defaultMaxPollWait = 5 * time.Second
+)
+
+// QueueConfig is size and overflow policy of subscriber queues,
+// zero values select defaults
+type QueueConfig struct {
+	Size     uint
+	Overflow string
+}
+
+// withDefaults returns config with empty fields set to defaults
+func (q QueueConfig) withDefaults() QueueConfig {
+	if q.Size == 0 {
+		q.Size = defaultQueueSize
+	}
+	if q.Overflow == "" {
+		q.Overflow = DropNewest
+	}
+	return q
+}
+
+func validOverflow(policy string) bool {
+	switch policy {
+	case "", DropOldest, DropNewest, Disconnect:
+		return true
+	default:
+		return false
+	}
+}
+
+// Broker delivers posted messages to subscribers regardless of their
+// transport, user has at most one subscription on every transport
+type Broker interface {
+	// SetQueue configures queues of subscriptions opened on transport
+	SetQueue(transport string, config QueueConfig) error
+	// Subscribe opens subscription of user with name on transport,
+	// previous subscription of user on transport is closed
+	Subscribe(name, transport string) *Subscription
+	// Unsubscribe closes subscription and forgets it
+	// if it is still the current one of its user
+	Unsubscribe(sub *Subscription)
+	// UnsubscribeUser closes all subscriptions of user with name
+	UnsubscribeUser(name string)
+	// Subscription returns current subscription of user on transport
+	Subscription(name, transport string) (*Subscription, bool)
+	// Subscriptions returns current subscriptions on transport
+	Subscriptions(transport string) []*Subscription
+	// Publish delivers message to subscriptions of users with names
+	Publish(msg *Message, names []string)
+}
+
+// Subscription is queue of messages for user on single transport
+type Subscription struct {
+	Name      string
+	Transport string
+
+	config     QueueConfig
+	overflow   func(sub *Subscription, policy string)
+	pushLock   sync.Mutex
+	queue      chan *Message
+	closed     chan struct{}
+	closeOnce  sync.Once
+	overflowed bool
+}
+
+// NewSubscription returns open subscription, overflow is called
+// every time policy is applied to full queue
+func NewSubscription(name, transport string, config QueueConfig, overflow func(sub *Subscription, policy string)) *Subscription {
+	config = config.withDefaults()
+	return &Subscription{
+		Name:      name,
+		Transport: transport,
+		config:    config,
+		overflow:  overflow,
+		queue:     make(chan *Message, config.Size),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Messages returns queue of subscription, it is never closed
+func (s *Subscription) Messages() <-chan *Message {
+	return s.queue
+}
+
+// Closed returns channel closed when subscription ends
+func (s *Subscription) Closed() <-chan struct{} {
+	return s.closed
+}
+
+// Close ends subscription, queued messages can still be drained
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// Overflowed tells if subscription was closed because consumer was slow
+func (s *Subscription) Overflowed() bool {
+	s.pushLock.Lock()
+	defer s.pushLock.Unlock()
+	return s.overflowed
+}
+
+// Len returns number of queued messages
+func (s *Subscription) Len() int {
+	return len(s.queue)
+}
+
+// Drain returns queued messages without waiting for more
+func (s *Subscription) Drain() []Message {
+	return s.DrainMax(0)
+}
+
+// DrainMax returns up to max queued messages without waiting for more,
+// zero max returns all of them
+func (s *Subscription) DrainMax(max int) (msgs []Message) {
+	for max == 0 || len(msgs) < max {
+		select {
+		case msg := <-s.queue:
+			msgs = append(msgs, *msg)
+		default:
+			return msgs
+		}
+	}
+	return msgs
+}
+
+// Push queues message, full queue is handled by overflow policy,
+// messages pushed to closed subscription are ignored
+func (s *Subscription) Push(msg *Message) {
+	s.pushLock.Lock()
+	defer s.pushLock.Unlock()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		default:
+		}
+
+		select {
+		case s.queue <- msg:
+			return
+		default:
+		}
+
+		if s.overflow != nil {
+			s.overflow(s, s.config.Overflow)
+		}
+		switch s.config.Overflow {
+		case DropOldest:
+			select {
+			case <-s.queue:
+			default:
+			}
+		case Disconnect:
+			s.overflowed = true
+			s.Close()
+			return
+		default:
+			return
+		}
+	}
+}
+
+// MemoryBroker is broker delivering messages within single server
+type MemoryBroker struct {
+	lock          sync.RWMutex
+	queues        map[string]QueueConfig
+	subscriptions map[string]map[string]*Subscription
+	overflow      func(sub *Subscription, policy string)
+}
+
+// NewMemoryBroker returns broker with default queues,
+// overflow is called every time policy is applied to full queue
+func NewMemoryBroker(overflow func(sub *Subscription, policy string)) *MemoryBroker {
+	return &MemoryBroker{
+		queues:        make(map[string]QueueConfig),
+		subscriptions: make(map[string]map[string]*Subscription),
+		overflow:      overflow,
+	}
+}
+
+// SetQueue configures queues of subscriptions opened on transport
+func (b *MemoryBroker) SetQueue(transport string, config QueueConfig) error {
+	if !validOverflow(config.Overflow) {
+		return fmt.Errorf("unknown overflow policy %q", config.Overflow)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.queues[transport] = config
+	return nil
+}
+
+// Subscribe opens subscription of user with name on transport,
+// previous subscription of user on transport is closed
+func (b *MemoryBroker) Subscribe(name, transport string) *Subscription {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sub := NewSubscription(name, transport, b.queues[transport], b.overflow)
+	subs, ok := b.subscriptions[name]
+	if !ok {
+		subs = make(map[string]*Subscription)
+		b.subscriptions[name] = subs
+	}
+	if previous, ok := subs[transport]; ok {
+		previous.Close()
+	}
+	subs[transport] = sub
+	return sub
+}
+
+// Unsubscribe closes subscription and forgets it
+// if it is still the current one of its user
+func (b *MemoryBroker) Unsubscribe(sub *Subscription) {
+	sub.Close()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if current, ok := b.subscriptions[sub.Name][sub.Transport]; ok && current == sub {
+		delete(b.subscriptions[sub.Name], sub.Transport)
+	}
+	if len(b.subscriptions[sub.Name]) == 0 {
+		delete(b.subscriptions, sub.Name)
+	}
+}
+
+// UnsubscribeUser closes all subscriptions of user with name
+func (b *MemoryBroker) UnsubscribeUser(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, sub := range b.subscriptions[name] {
+		sub.Close()
+	}
+	delete(b.subscriptions, name)
+}
+
+// Subscription returns current subscription of user on transport
+func (b *MemoryBroker) Subscription(name, transport string) (*Subscription, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	sub, ok := b.subscriptions[name][transport]
+	return sub, ok
+}
+
+// Subscriptions returns current subscriptions on transport
+func (b *MemoryBroker) Subscriptions(transport string) (subs []*Subscription) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, userSubs := range b.subscriptions {
+		if sub, ok := userSubs[transport]; ok {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Publish delivers message to subscriptions of users with names,
+// subscriptions closed by overflow are forgotten
+func (b *MemoryBroker) Publish(msg *Message, names []string) {
+	var subs []*Subscription
+	b.lock.RLock()
+	for _, name := range names {
+		for _, sub := range b.subscriptions[name] {
+			subs = append(subs, sub)
+		}
+	}
+	b.lock.RUnlock()
+
+	for _, sub := range subs {
+		sub.Push(msg)
+		if sub.Overflowed() {
+			b.Unsubscribe(sub)
+		}
+	}
+}
+
+// SetBroker replaces broker delivering messages, it should be set
+// before serving starts
+func (a *API) SetBroker(broker Broker) {
+	a.broker = broker
+}
+
+// SetQueue configures subscriber queues of transport, current
+// subscriptions are opened again and lose queued messages,
+// so it should be set before serving starts
+func (a *API) SetQueue(transport string, config QueueConfig) error {
+	if err := a.broker.SetQueue(transport, config); err != nil {
+		return err
+	}
+	for _, sub := range a.broker.Subscriptions(transport) {
+		a.broker.Subscribe(sub.Name, transport)
+	}
+	return nil
+}
+
+// overflowed counts messages dropped and consumers disconnected
+// because their queues were full
+func (a *API) overflowed(sub *Subscription, policy string) {
+	if policy == Disconnect {
+		atomic.AddUint64(&a.stats.SlowDisconnects, 1)
+	} else {
+		atomic.AddUint64(&a.stats.MessagesDropped, 1)
+	}
+	a.metrics.queueOverflowed(sub.Transport, policy, 1)
+}
@@ -2,11 +2,16 @@ package api
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/phob0s-pl/perfchat/chat"
 )
@@ -16,21 +21,42 @@ type Client struct {
 	user       *chat.User
 	httpClient *http.Client
 	serverAddr string
+	// tlsConfig makes client use https and wss when set
+	tlsConfig *tls.Config
+
+	// session is token from Login, requests use basic auth without it
+	session     string
+	sessionLock sync.RWMutex
 }
 
 // NewClient returns API client
 // user represents user performing operations
 // addr is server address
 func NewClient(user *chat.User, addr string) *Client {
+	return NewClientWithTLS(user, addr, nil)
+}
+
+// NewClientWithTLS returns API client connecting with TLS config,
+// nil config gives plain http client
+func NewClientWithTLS(user *chat.User, addr string, config *tls.Config) *Client {
+	httpClient := &http.Client{}
+	if config != nil {
+		httpClient.Transport = &http.Transport{TLSClientConfig: config}
+	}
 	return &Client{
-		httpClient: &http.Client{},
+		httpClient: httpClient,
 		user:       user,
 		serverAddr: addr,
+		tlsConfig:  config,
 	}
 }
 
 func (c *Client) requestPath(apicall string) string {
-	return fmt.Sprintf("http://%s%s", c.serverAddr, GetPath(apicall))
+	scheme := "http"
+	if c.tlsConfig != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.serverAddr, GetPath(apicall))
 }
 
 // newRequest creates new requests and sets auth info
@@ -40,10 +66,65 @@ func (c *Client) newAPIRequest(method, apiCall string, body io.Reader) (*http.Re
 	if err != nil {
 		return nil, err
 	}
-	request.SetBasicAuth(c.user.AuthID, c.user.Token)
+	c.sessionLock.RLock()
+	session := c.session
+	c.sessionLock.RUnlock()
+	if session != "" {
+		request.Header.Set("Authorization", "Bearer "+session)
+	} else {
+		request.SetBasicAuth(c.user.AuthID, c.user.Token)
+	}
 	return request, nil
 }
 
+// Login exchanges user credentials for session token,
+// further requests are authorized with it
+func (c *Client) Login() error {
+	payload, err := json.Marshal(&User{
+		AuthID: c.user.AuthID,
+		Token:  c.user.Token,
+	})
+	if err != nil {
+		return fmt.Errorf("Login: %w", err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, c.requestPath(LoginCall), bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("Login: %w", err)
+	}
+
+	body, err := c.do(request)
+	if err != nil {
+		return fmt.Errorf("Login: %w", err)
+	}
+	session := &Session{}
+	if err := json.Unmarshal(body, session); err != nil {
+		return fmt.Errorf("Login: %w", err)
+	}
+
+	c.sessionLock.Lock()
+	c.session = session.Token
+	c.sessionLock.Unlock()
+	return nil
+}
+
+// Logout revokes session token, further requests use basic auth
+func (c *Client) Logout() error {
+	request, err := c.newAPIRequest(http.MethodPost, LogoutCall, nil)
+	if err != nil {
+		return fmt.Errorf("Logout: %w", err)
+	}
+
+	if _, err := c.do(request); err != nil {
+		return fmt.Errorf("Logout: %w", err)
+	}
+
+	c.sessionLock.Lock()
+	c.session = ""
+	c.sessionLock.Unlock()
+	return nil
+}
+
 // simpleDo makes http request and checks response code and returns body
 func (c *Client) do(request *http.Request) ([]byte, error) {
 	resp, err := c.httpClient.Do(request)
@@ -51,11 +132,11 @@ func (c *Client) do(request *http.Request) ([]byte, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%s", http.StatusText(resp.StatusCode))
+		return nil, readError(resp.StatusCode, body)
 	}
-
-	return ioutil.ReadAll(resp.Body)
+	return body, err
 }
 
 // AddUser adds new chat user
@@ -69,16 +150,84 @@ func (c *Client) AddUser(user *chat.User) error {
 	})
 
 	if err != nil {
-		return fmt.Errorf("AddUser: %s", err)
+		return fmt.Errorf("AddUser: %w", err)
 	}
 
 	request, err := c.newAPIRequest(http.MethodPost, UsersCall, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("AddUser: %s", err)
+		return fmt.Errorf("AddUser: %w", err)
 	}
 
 	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("AddUser: %s", err)
+		return fmt.Errorf("AddUser: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser deletes user with his personal room and memberships
+// Note: need to have admin priviliges
+func (c *Client) DeleteUser(name string) error {
+	request, err := c.newAPIRequest(http.MethodDelete, RoomCall(UserCall, name), nil)
+	if err != nil {
+		return fmt.Errorf("DeleteUser: %w", err)
+	}
+
+	if _, err := c.do(request); err != nil {
+		return fmt.Errorf("DeleteUser: %w", err)
+	}
+	return nil
+}
+
+// UpdateUser changes user and returns him as stored by server,
+// returned user carries token generated when update.RotateToken is set
+// Note: need to have admin priviliges
+func (c *Client) UpdateUser(name string, update *UserUpdate) (*User, error) {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("UpdateUser: %w", err)
+	}
+
+	request, err := c.newAPIRequest(http.MethodPatch, RoomCall(UserCall, name), bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("UpdateUser: %w", err)
+	}
+
+	body, err := c.do(request)
+	if err != nil {
+		return nil, fmt.Errorf("UpdateUser: %w", err)
+	}
+
+	user := &User{}
+	if err := json.Unmarshal(body, user); err != nil {
+		return nil, fmt.Errorf("UpdateUser: %w", err)
+	}
+	return user, nil
+}
+
+// SuspendUser suspends user, suspended user can't use chat
+// Note: need to have admin priviliges
+func (c *Client) SuspendUser(name string) error {
+	request, err := c.newAPIRequest(http.MethodPost, RoomCall(UserSuspendCall, name), nil)
+	if err != nil {
+		return fmt.Errorf("SuspendUser: %w", err)
+	}
+
+	if _, err := c.do(request); err != nil {
+		return fmt.Errorf("SuspendUser: %w", err)
+	}
+	return nil
+}
+
+// ResumeUser resumes suspended user
+// Note: need to have admin priviliges
+func (c *Client) ResumeUser(name string) error {
+	request, err := c.newAPIRequest(http.MethodPost, RoomCall(UserResumeCall, name), nil)
+	if err != nil {
+		return fmt.Errorf("ResumeUser: %w", err)
+	}
+
+	if _, err := c.do(request); err != nil {
+		return fmt.Errorf("ResumeUser: %w", err)
 	}
 	return nil
 }
@@ -87,11 +236,11 @@ func (c *Client) AddUser(user *chat.User) error {
 func (c *Client) Ping() error {
 	request, err := c.newAPIRequest(http.MethodGet, PingCall, nil)
 	if err != nil {
-		return fmt.Errorf("Ping: %s", err)
+		return fmt.Errorf("Ping: %w", err)
 	}
 
 	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("Ping: %s", err)
+		return fmt.Errorf("Ping: %w", err)
 	}
 	return nil
 }
@@ -100,16 +249,16 @@ func (c *Client) Ping() error {
 func (c *Client) GetUsers() (users []User, err error) {
 	request, err := c.newAPIRequest(http.MethodGet, UsersCall, nil)
 	if err != nil {
-		return nil, fmt.Errorf("GetUsers: %s", err)
+		return nil, fmt.Errorf("GetUsers: %w", err)
 	}
 
 	body, err := c.do(request)
 	if err != nil {
-		return users, fmt.Errorf("GetUsers: %s", err)
+		return users, fmt.Errorf("GetUsers: %w", err)
 	}
 
 	if err = json.Unmarshal(body, &users); err != nil {
-		return nil, fmt.Errorf("GetUsers: %s", err)
+		return nil, fmt.Errorf("GetUsers: %w", err)
 	}
 
 	return users, err
@@ -119,78 +268,92 @@ func (c *Client) GetUsers() (users []User, err error) {
 func (c *Client) GetRooms() (rooms []Room, err error) {
 	request, err := c.newAPIRequest(http.MethodGet, RoomsCall, nil)
 	if err != nil {
-		return nil, fmt.Errorf("GetRooms: %s", err)
+		return nil, fmt.Errorf("GetRooms: %w", err)
 	}
 
 	body, err := c.do(request)
 	if err != nil {
-		return rooms, fmt.Errorf("GetRooms: %s", err)
+		return rooms, fmt.Errorf("GetRooms: %w", err)
 	}
 
 	if err = json.Unmarshal(body, &rooms); err != nil {
-		return nil, fmt.Errorf("body: %s", err)
+		return nil, fmt.Errorf("body: %w", err)
 	}
 
 	return rooms, err
 }
 
-// RoomCreate creates new room and sets user as owner
+// RoomCreate creates new public room and sets user as owner
 func (c *Client) RoomCreate(name string) error {
+	return c.RoomCreateWithAccess(name, "", "")
+}
+
+// RoomCreateWithAccess creates new room with access mode,
+// password is needed for password protected rooms
+func (c *Client) RoomCreateWithAccess(name, access, password string) error {
 	payload, err := json.Marshal(&Room{
-		Name: name,
+		Name:     name,
+		Access:   access,
+		Password: password,
 	})
 	if err != nil {
-		return fmt.Errorf("RoomCreate: %s", err)
+		return fmt.Errorf("RoomCreate: %w", err)
 	}
 
 	request, err := c.newAPIRequest(http.MethodPost, RoomsCreateCall, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("RoomCreate: %s", err)
+		return fmt.Errorf("RoomCreate: %w", err)
 	}
 
 	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("RoomCreate: %s", err)
+		return fmt.Errorf("RoomCreate: %w", err)
 	}
 	return nil
 }
 
 // RoomDelete deletes room from server
-// note: user must be owner of a room
+// note: user must be owner of a room or moderator
 func (c *Client) RoomDelete(name string) error {
 	payload, err := json.Marshal(&Room{
 		Name: name,
 	})
 	if err != nil {
-		return fmt.Errorf("RoomDelete: %s", err)
+		return fmt.Errorf("RoomDelete: %w", err)
 	}
 
 	request, err := c.newAPIRequest(http.MethodPost, RoomsDeleteCall, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("RoomDelete: %s", err)
+		return fmt.Errorf("RoomDelete: %w", err)
 	}
 
 	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("RoomDelete: %s", err)
+		return fmt.Errorf("RoomDelete: %w", err)
 	}
 	return nil
 }
 
 // RoomJoin joins user to room
 func (c *Client) RoomJoin(name string) error {
+	return c.RoomJoinWithPassword(name, "")
+}
+
+// RoomJoinWithPassword joins user to password protected room
+func (c *Client) RoomJoinWithPassword(name, password string) error {
 	payload, err := json.Marshal(&Room{
-		Name: name,
+		Name:     name,
+		Password: password,
 	})
 	if err != nil {
-		return fmt.Errorf("RoomJoin: %s", err)
+		return fmt.Errorf("RoomJoin: %w", err)
 	}
 
 	request, err := c.newAPIRequest(http.MethodPost, RoomsJoinCall, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("RoomJoin: %s", err)
+		return fmt.Errorf("RoomJoin: %w", err)
 	}
 
 	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("RoomJoin: %s", err)
+		return fmt.Errorf("RoomJoin: %w", err)
 	}
 	return nil
 }
@@ -202,53 +365,294 @@ func (c *Client) RoomExit(name string) error {
 		Name: name,
 	})
 	if err != nil {
-		return fmt.Errorf("RoomExit: %s", err)
+		return fmt.Errorf("RoomExit: %w", err)
 	}
 
 	request, err := c.newAPIRequest(http.MethodPost, RoomsExitCall, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("RoomExit: %s", err)
+		return fmt.Errorf("RoomExit: %w", err)
 	}
 
 	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("RoomExit: %s", err)
+		return fmt.Errorf("RoomExit: %w", err)
+	}
+	return nil
+}
+
+// RoomKick removes user from room
+// note: user must be room owner or moderator
+func (c *Client) RoomKick(room, user string) error {
+	if err := c.moderateRoom(RoomsKickCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomKick: %w", err)
 	}
 	return nil
 }
 
+// RoomBan removes user from room and prevents him from joining
+// note: user must be room owner or moderator
+func (c *Client) RoomBan(room, user string) error {
+	if err := c.moderateRoom(RoomsBanCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomBan: %w", err)
+	}
+	return nil
+}
+
+// RoomUnban lets banned user join room again
+// note: user must be room owner or moderator
+func (c *Client) RoomUnban(room, user string) error {
+	if err := c.moderateRoom(RoomsUnbanCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomUnban: %w", err)
+	}
+	return nil
+}
+
+// RoomMute prevents user from posting to room for duration rounded
+// to seconds, zero duration unmutes user
+// note: user must be room owner or moderator
+func (c *Client) RoomMute(room, user string, duration time.Duration) error {
+	action := &RoomAction{User: user, Duration: uint(duration / time.Second)}
+	if err := c.moderateRoom(RoomsMuteCall, room, action); err != nil {
+		return fmt.Errorf("RoomMute: %w", err)
+	}
+	return nil
+}
+
+// RoomPromote makes room member moderator
+// note: user must be room owner
+func (c *Client) RoomPromote(room, user string) error {
+	if err := c.moderateRoom(RoomsPromoteCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomPromote: %w", err)
+	}
+	return nil
+}
+
+// RoomDemote makes room moderator regular member
+// note: user must be room owner
+func (c *Client) RoomDemote(room, user string) error {
+	if err := c.moderateRoom(RoomsDemoteCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomDemote: %w", err)
+	}
+	return nil
+}
+
+// RoomTransfer makes room member owner of room
+// note: user must be room owner
+func (c *Client) RoomTransfer(room, user string) error {
+	if err := c.moderateRoom(RoomsTransferCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomTransfer: %w", err)
+	}
+	return nil
+}
+
+// RoomInvite lets user join invite-only or password protected room
+func (c *Client) RoomInvite(room, user string) error {
+	if err := c.moderateRoom(RoomsInviteCall, room, &RoomAction{User: user}); err != nil {
+		return fmt.Errorf("RoomInvite: %w", err)
+	}
+	return nil
+}
+
+// RoomAccept joins room user was invited to
+func (c *Client) RoomAccept(room string) error {
+	request, err := c.newAPIRequest(http.MethodPost, RoomCall(RoomsAcceptCall, room), nil)
+	if err != nil {
+		return fmt.Errorf("RoomAccept: %w", err)
+	}
+
+	if _, err := c.do(request); err != nil {
+		return fmt.Errorf("RoomAccept: %w", err)
+	}
+	return nil
+}
+
+// GetInvites returns names of rooms user was invited to
+func (c *Client) GetInvites() (rooms []string, err error) {
+	request, err := c.newAPIRequest(http.MethodGet, InvitesCall, nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetInvites: %w", err)
+	}
+
+	body, err := c.do(request)
+	if err != nil {
+		return nil, fmt.Errorf("GetInvites: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &rooms); err != nil {
+		return nil, fmt.Errorf("body: %w", err)
+	}
+	return rooms, nil
+}
+
+func (c *Client) moderateRoom(call, room string, action *RoomAction) error {
+	payload, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+
+	request, err := c.newAPIRequest(http.MethodPost, RoomCall(call, room), bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(request)
+	return err
+}
+
 // SendMessage send message to specified room
+// on success msg is updated with ID, sequence number and time set by server
 func (c *Client) SendMessage(msg *Message) error {
 	payload, err := json.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("SendMessage: %s", err)
+		return fmt.Errorf("SendMessage: %w", err)
 	}
 
 	request, err := c.newAPIRequest(http.MethodPost, MessageCall, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("SendMessage: %s", err)
+		return fmt.Errorf("SendMessage: %w", err)
 	}
 
-	if _, err := c.do(request); err != nil {
-		return fmt.Errorf("SendMessage: %s", err)
+	body, err := c.do(request)
+	if err != nil {
+		return fmt.Errorf("SendMessage: %w", err)
+	}
+
+	if err := json.Unmarshal(body, msg); err != nil {
+		return fmt.Errorf("SendMessage: %w", err)
 	}
 	return nil
 }
 
 // ReceiveMessage receives all messages
 func (c *Client) ReceiveMessage() (messages []Message, err error) {
+	messages, err = c.receive(0, 0)
+	if err != nil {
+		return messages, fmt.Errorf("ReceiveMessage: %w", err)
+	}
+	return messages, nil
+}
+
+// ReceiveMessageWait receives up to max messages, zero max receives all,
+// when there is none it waits up to wait for the first one,
+// server caps wait below its write timeout
+func (c *Client) ReceiveMessageWait(wait time.Duration, max uint) (messages []Message, err error) {
+	messages, err = c.receive(wait, max)
+	if err != nil {
+		return messages, fmt.Errorf("ReceiveMessageWait: %w", err)
+	}
+	return messages, nil
+}
+
+func (c *Client) receive(wait time.Duration, max uint) (messages []Message, err error) {
 	request, err := c.newAPIRequest(http.MethodGet, MessageCall, nil)
 	if err != nil {
-		return nil, fmt.Errorf("ReceiveMessage: %s", err)
+		return nil, err
+	}
+
+	query := url.Values{}
+	if ms := wait.Milliseconds(); ms > 0 {
+		query.Set("wait", strconv.FormatInt(ms, 10))
+	}
+	if max != 0 {
+		query.Set("max", strconv.FormatUint(uint64(max), 10))
 	}
+	request.URL.RawQuery = query.Encode()
 
 	body, err := c.do(request)
 	if err != nil {
-		return messages, fmt.Errorf("ReceiveMessage: %s", err)
+		return messages, err
 	}
 
 	if err = json.Unmarshal(body, &messages); err != nil {
-		return nil, fmt.Errorf("ReceiveMessage: %s", err)
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// RoomMessages returns page of room message history,
+// only messages with after < ID < before are returned,
+// zero value of before, after or limit means no bound or default
+func (c *Client) RoomMessages(room string, before, after uint64, limit uint) (messages []Message, err error) {
+	if messages, err = c.page(RoomCall(RoomsMessagesCall, room), before, after, limit); err != nil {
+		return nil, fmt.Errorf("RoomMessages: %w", err)
+	}
+	return messages, nil
+}
+
+// SendDirect sends direct message to user given by msg.To
+// on success msg is updated with ID, sequence number and time set by server
+func (c *Client) SendDirect(msg *Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("SendDirect: %w", err)
+	}
+
+	request, err := c.newAPIRequest(http.MethodPost, RoomCall(DirectUserCall, msg.To), bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("SendDirect: %w", err)
+	}
+
+	body, err := c.do(request)
+	if err != nil {
+		return fmt.Errorf("SendDirect: %w", err)
+	}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return fmt.Errorf("SendDirect: %w", err)
+	}
+	return nil
+}
+
+// DirectMessages returns page of direct messages exchanged with user
+// and marks messages from him read, bounds are as in RoomMessages
+func (c *Client) DirectMessages(user string, before, after uint64, limit uint) (messages []Message, err error) {
+	if messages, err = c.page(RoomCall(DirectMessagesCall, user), before, after, limit); err != nil {
+		return nil, fmt.Errorf("DirectMessages: %w", err)
+	}
+	return messages, nil
+}
+
+// GetUnread returns number of unread direct messages by sender
+func (c *Client) GetUnread() (unread map[string]uint, err error) {
+	request, err := c.newAPIRequest(http.MethodGet, DirectCall, nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetUnread: %w", err)
+	}
+
+	body, err := c.do(request)
+	if err != nil {
+		return nil, fmt.Errorf("GetUnread: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &unread); err != nil {
+		return nil, fmt.Errorf("GetUnread: %w", err)
+	}
+	return unread, nil
+}
+
+// page returns page of messages from history call
+func (c *Client) page(call string, before, after uint64, limit uint) (messages []Message, err error) {
+	request, err := c.newAPIRequest(http.MethodGet, call, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	if before != 0 {
+		query.Set("before", strconv.FormatUint(before, 10))
+	}
+	if after != 0 {
+		query.Set("after", strconv.FormatUint(after, 10))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.FormatUint(uint64(limit), 10))
+	}
+	request.URL.RawQuery = query.Encode()
+
+	body, err := c.do(request)
+	if err != nil {
+		return nil, err
 	}
 
+	err = json.Unmarshal(body, &messages)
 	return messages, err
 }
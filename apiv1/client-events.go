@@ -0,0 +1,265 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStreamClosed is returned when event stream was closed
+var ErrStreamClosed = errors.New("event stream closed")
+
+// EventStream is client subscription to SSE stream of user events
+type EventStream struct {
+	client    *Client
+	reconnect bool
+
+	body     io.ReadCloser
+	bodyLock sync.Mutex
+	// lastID is ID of last received event, stream resumes after it
+	lastID   uint64
+	lastLock sync.Mutex
+
+	events    chan *Event
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenEvents connects to SSE stream of user events, stream resumes
+// after lastEventID unless it is zero
+// if reconnect is set, broken stream is opened again with exponential
+// backoff, resuming after last received event, until it is closed
+func (c *Client) OpenEvents(lastEventID uint64, reconnect bool) (*EventStream, error) {
+	s := &EventStream{
+		client:    c,
+		reconnect: reconnect,
+		lastID:    lastEventID,
+		events:    make(chan *Event, defaultQueueSize),
+		errors:    make(chan error, sessionErrorsBuffer),
+		done:      make(chan struct{}),
+	}
+
+	body, err := s.connect()
+	if err != nil {
+		return nil, fmt.Errorf("OpenEvents: %w", err)
+	}
+	s.body = body
+
+	go s.readLoop()
+	return s, nil
+}
+
+// connect opens stream with the same auth as REST calls
+func (s *EventStream) connect() (io.ReadCloser, error) {
+	request, err := s.client.newAPIRequest(http.MethodGet, EventsCall, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+	if lastID := s.LastEventID(); lastID != 0 {
+		request.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+
+	resp, err := s.client.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, readError(resp.StatusCode, body)
+	}
+	return resp.Body, nil
+}
+
+// Events returns channel with received events,
+// channel is closed when stream ends
+func (s *EventStream) Events() <-chan *Event {
+	return s.events
+}
+
+// Errors returns channel with connection errors
+func (s *EventStream) Errors() <-chan error {
+	return s.errors
+}
+
+// LastEventID returns ID of last received event
+func (s *EventStream) LastEventID() uint64 {
+	s.lastLock.Lock()
+	defer s.lastLock.Unlock()
+	return s.lastID
+}
+
+// Close ends stream
+func (s *EventStream) Close() error {
+	err := ErrStreamClosed
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.getBody().Close()
+	})
+	return err
+}
+
+func (s *EventStream) getBody() io.ReadCloser {
+	s.bodyLock.Lock()
+	defer s.bodyLock.Unlock()
+	return s.body
+}
+
+func (s *EventStream) setBody(body io.ReadCloser) {
+	s.bodyLock.Lock()
+	defer s.bodyLock.Unlock()
+	s.body = body
+}
+
+func (s *EventStream) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportError passes error to Errors channel, drops it if nobody reads
+func (s *EventStream) reportError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+func (s *EventStream) readLoop() {
+	defer close(s.events)
+
+	for {
+		body := s.getBody()
+		err := s.readBody(body)
+		body.Close()
+		if !s.closed() {
+			s.reportError(fmt.Errorf("Events: %w", err))
+		}
+
+		if s.closed() || !s.reconnect {
+			return
+		}
+
+		body, ok := s.reopen()
+		if !ok {
+			return
+		}
+		s.setBody(body)
+		if s.closed() {
+			body.Close()
+			return
+		}
+	}
+}
+
+// readBody parses events from stream until it breaks
+func (s *EventStream) readBody(body io.Reader) error {
+	reader := bufio.NewReader(body)
+	event := &Event{}
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// blank line ends event, lines starting with colon are comments
+		if line == "" {
+			if len(data) > 0 {
+				if err := parseEventData(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+				if !s.deliver(event) {
+					return ErrStreamClosed
+				}
+			}
+			event, data = &Event{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			event.ID, _ = strconv.ParseUint(value, 10, 64)
+		case "event":
+			event.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
+
+// parseEventData decodes data of event, data of message event
+// is message itself
+func parseEventData(event *Event, data string) error {
+	if event.Type != EventMessage {
+		return json.Unmarshal([]byte(data), event)
+	}
+	event.Message = &Message{}
+	if err := json.Unmarshal([]byte(data), event.Message); err != nil {
+		return err
+	}
+	event.Room, event.User = event.Message.Room, event.Message.User
+	return nil
+}
+
+// deliver passes event to Events channel and remembers its ID,
+// false is returned when stream was closed meanwhile
+func (s *EventStream) deliver(event *Event) bool {
+	select {
+	case s.events <- event:
+	case <-s.done:
+		return false
+	}
+	if event.ID != 0 {
+		s.lastLock.Lock()
+		s.lastID = event.ID
+		s.lastLock.Unlock()
+	}
+	return true
+}
+
+// reopen tries to connect again with exponential backoff
+func (s *EventStream) reopen() (io.ReadCloser, bool) {
+	wait := minReconnectWait
+	for {
+		select {
+		case <-time.After(wait):
+		case <-s.done:
+			return nil, false
+		}
+
+		body, err := s.connect()
+		if err == nil {
+			return body, true
+		}
+		s.reportError(fmt.Errorf("Reconnect: %w", err))
+
+		if wait *= 2; wait > maxReconnectWait {
+			wait = maxReconnectWait
+		}
+	}
+}
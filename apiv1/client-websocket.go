@@ -0,0 +1,257 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// minReconnectWait is the first delay before reconnecting
+	minReconnectWait = 100 * time.Millisecond
+	// maxReconnectWait is the upper limit of delay between reconnects
+	maxReconnectWait = 10 * time.Second
+	// sessionErrorsBuffer is number of errors kept until read
+	sessionErrorsBuffer = 16
+)
+
+// ErrSessionClosed is returned when operation is done on closed session
+var ErrSessionClosed = errors.New("websocket session closed")
+
+// websocketFrame is message pushed by server or error
+// telling that message sent by session was rejected
+type websocketFrame struct {
+	Message
+	Error *Error `json:"error,omitempty"`
+}
+
+// WebsocketSession is client connection to websocket API,
+// messages posted in user rooms are pushed to Messages channel
+type WebsocketSession struct {
+	client    *Client
+	dialer    *websocket.Dialer
+	reconnect bool
+
+	conn      *websocket.Conn
+	connLock  sync.Mutex
+	writeLock sync.Mutex
+
+	messages  chan *Message
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenWebsocket connects to server websocket
+// if reconnect is set, broken connection is dialed again with
+// exponential backoff until session is closed
+func (c *Client) OpenWebsocket(reconnect bool) (*WebsocketSession, error) {
+	s := &WebsocketSession{
+		client:    c,
+		dialer:    &websocket.Dialer{HandshakeTimeout: writeWait, TLSClientConfig: c.tlsConfig},
+		reconnect: reconnect,
+		messages:  make(chan *Message, defaultQueueSize),
+		errors:    make(chan error, sessionErrorsBuffer),
+		done:      make(chan struct{}),
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, fmt.Errorf("OpenWebsocket: %w", err)
+	}
+	s.conn = conn
+
+	go s.readLoop()
+	go s.pingLoop()
+	return s, nil
+}
+
+// websocketPath returns ws or, for TLS clients, wss URL of websocket
+func (c *Client) websocketPath() string {
+	return strings.Replace(c.requestPath(WsPath), "http", "ws", 1)
+}
+
+// dial opens new connection with the same auth as REST calls
+func (s *WebsocketSession) dial() (*websocket.Conn, error) {
+	request, err := s.client.newAPIRequest(http.MethodGet, WsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, resp, err := s.dialer.Dial(s.client.websocketPath(), request.Header)
+	if err == websocket.ErrBadHandshake && resp != nil {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, readError(resp.StatusCode, body)
+	}
+	return conn, err
+}
+
+// Messages returns channel with received messages,
+// channel is closed when session ends
+func (s *WebsocketSession) Messages() <-chan *Message {
+	return s.messages
+}
+
+// Errors returns channel with connection errors
+func (s *WebsocketSession) Errors() <-chan error {
+	return s.errors
+}
+
+// Send posts message to room
+func (s *WebsocketSession) Send(msg *Message) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("Send: %w", ErrSessionClosed)
+	default:
+	}
+
+	conn := s.getConn()
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteJSON(msg); err != nil {
+		return fmt.Errorf("Send: %w", err)
+	}
+	return nil
+}
+
+// Close sends close frame to server and ends session
+func (s *WebsocketSession) Close() error {
+	err := ErrSessionClosed
+	s.closeOnce.Do(func() {
+		close(s.done)
+		conn := s.getConn()
+		s.writeLock.Lock()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(writeWait))
+		s.writeLock.Unlock()
+		err = conn.Close()
+	})
+	return err
+}
+
+func (s *WebsocketSession) getConn() *websocket.Conn {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	return s.conn
+}
+
+func (s *WebsocketSession) setConn(conn *websocket.Conn) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.conn = conn
+}
+
+func (s *WebsocketSession) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportError passes error to Errors channel, drops it if nobody reads
+func (s *WebsocketSession) reportError(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+func (s *WebsocketSession) readLoop() {
+	defer close(s.messages)
+
+	for {
+		conn := s.getConn()
+		s.readConn(conn)
+		conn.Close()
+
+		if s.closed() || !s.reconnect {
+			return
+		}
+
+		conn, ok := s.redial()
+		if !ok {
+			return
+		}
+		s.setConn(conn)
+		if s.closed() {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// readConn reads messages from connection until it breaks
+func (s *WebsocketSession) readConn(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	for {
+		frame := &websocketFrame{}
+		if err := conn.ReadJSON(frame); err != nil {
+			if !s.closed() {
+				s.reportError(fmt.Errorf("Websocket: %w", err))
+			}
+			return
+		}
+		if frame.Error != nil {
+			s.reportError(fmt.Errorf("Send: %w", frame.Error))
+			continue
+		}
+
+		msg := frame.Message
+		select {
+		case s.messages <- &msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// redial tries to connect again with exponential backoff
+func (s *WebsocketSession) redial() (*websocket.Conn, bool) {
+	wait := minReconnectWait
+	for {
+		select {
+		case <-time.After(wait):
+		case <-s.done:
+			return nil, false
+		}
+
+		conn, err := s.dial()
+		if err == nil {
+			return conn, true
+		}
+		s.reportError(fmt.Errorf("Reconnect: %w", err))
+
+		if wait *= 2; wait > maxReconnectWait {
+			wait = maxReconnectWait
+		}
+	}
+}
+
+func (s *WebsocketSession) pingLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			conn := s.getConn()
+			s.writeLock.Lock()
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			s.writeLock.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
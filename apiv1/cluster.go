@@ -0,0 +1,707 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+const (
+	// clusterSecretHeader carries secret shared by cluster nodes
+	clusterSecretHeader = "X-Cluster-Secret"
+	// clusterQueueSize is number of events waiting for single peer
+	clusterQueueSize = 10000
+	// clusterBatchSize is maximum number of events sent at once
+	clusterBatchSize = 100
+	// clusterRetries is number of attempts to send batch before it is dropped
+	clusterRetries = 5
+	// clusterResyncDelay is delay between attempts to pass state
+	// to peer which missed events
+	clusterResyncDelay = time.Second
+	// clusterTimeout limits single request to peer
+	clusterTimeout = 5 * time.Second
+	// clusterWait limits waiting for change made on other node
+	// and clusterWaitInterval is delay between checks if it arrived
+	clusterWait         = time.Second
+	clusterWaitInterval = 5 * time.Millisecond
+)
+
+// Kinds of events passed between cluster nodes
+const (
+	putUserEvent            = "put_user"
+	deleteUserEvent         = "delete_user"
+	putRoomEvent            = "put_room"
+	deleteRoomEvent         = "delete_room"
+	deliverEvent            = "deliver"
+	readEvent               = "read"
+	sessionOpenedEvent      = "session_opened"
+	sessionClosedEvent      = "session_closed"
+	userSessionsClosedEvent = "user_sessions_closed"
+	syncEvent               = "sync"
+)
+
+// clusterEvent is change made on one node passed to other nodes
+type clusterEvent struct {
+	Kind string           `json:"kind"`
+	User *chat.User       `json:"user,omitempty"`
+	Room *chat.RoomRecord `json:"room,omitempty"`
+	// Name is user or room event is about
+	Name string `json:"name,omitempty"`
+	// Peer is sender of direct messages read by Name
+	Peer string `json:"peer,omitempty"`
+	// Key is name of history delivered message belongs to
+	Key        string         `json:"key,omitempty"`
+	Message    *Message       `json:"message,omitempty"`
+	Recipients []string       `json:"recipients,omitempty"`
+	Session    *sessionClaims `json:"session,omitempty"`
+	// State has users and rooms owned by Node, it replaces
+	// ones peer has after it missed events
+	Node  string        `json:"node,omitempty"`
+	State *clusterState `json:"state,omitempty"`
+}
+
+// clusterState is what node joining cluster gets from its peer
+type clusterState struct {
+	Users    []*chat.User       `json:"users"`
+	Rooms    []*chat.RoomRecord `json:"rooms"`
+	Sessions []*sessionClaims   `json:"sessions"`
+}
+
+// ClusterConfig describes node of cluster, every node must be given
+// the same addresses and the same session secret
+type ClusterConfig struct {
+	// Node is address other nodes reach this node at
+	Node string
+	// Peers are addresses of other nodes, Node is skipped
+	// if present, so all nodes may share the same list
+	Peers []string
+	// Secret authenticates requests between nodes
+	Secret string
+	// TLS makes nodes talk https, nil gives plain http
+	TLS *tls.Config
+}
+
+// Cluster shares users, rooms and sessions of API with other nodes
+// and relays messages between them. Every user, room and direct
+// conversation is owned by single node chosen by hash of its name,
+// the owner stamps its messages so IDs and sequence numbers stay
+// consistent and makes all changes of its users and rooms, other nodes
+// forward posts and changes to it. Only owner passes users and rooms
+// to peers, so they end with the same ones. Changes reach every peer
+// in order they were made on node, peer which missed some of them
+// gets all users and rooms owned by node again.
+type Cluster struct {
+	api    *API
+	node   string
+	nodes  []string
+	secret string
+	scheme string
+	client *http.Client
+	peers  []*clusterPeer
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	senders  sync.WaitGroup
+}
+
+// clusterPeer is other node with events waiting for it
+type clusterPeer struct {
+	address string
+	events  chan *clusterEvent
+	// dropped is set when events for peer were lost,
+	// peer gets state of node before next events
+	dropped int32
+}
+
+// SetCluster makes API node of cluster, it should be set before
+// serving starts, state of running nodes is fetched with Sync
+func (a *API) SetCluster(config ClusterConfig) (*Cluster, error) {
+	if config.Node == "" || config.Secret == "" {
+		return nil, errors.New("cluster needs node address and secret")
+	}
+
+	c := &Cluster{
+		api:    a,
+		node:   config.Node,
+		secret: config.Secret,
+		scheme: "http",
+		client: &http.Client{Timeout: clusterTimeout},
+		stop:   make(chan struct{}),
+	}
+	if config.TLS != nil {
+		c.scheme = "https"
+		c.client.Transport = &http.Transport{TLSClientConfig: config.TLS}
+	}
+
+	nodes := map[string]bool{config.Node: true}
+	for _, address := range config.Peers {
+		if nodes[address] {
+			continue
+		}
+		nodes[address] = true
+		c.peers = append(c.peers, &clusterPeer{
+			address: address,
+			events:  make(chan *clusterEvent, clusterQueueSize),
+		})
+	}
+	if len(c.peers) == 0 {
+		return nil, errors.New("cluster needs peers")
+	}
+	for address := range nodes {
+		c.nodes = append(c.nodes, address)
+	}
+	sort.Strings(c.nodes)
+
+	node := sort.SearchStrings(c.nodes, c.node)
+	a.history.setNode(node, len(c.nodes))
+	a.events.setNode(node, len(c.nodes))
+	a.cluster = c
+	a.Engine.SetReplicator(c)
+	for _, peer := range c.peers {
+		c.senders.Add(1)
+		go c.send(peer)
+	}
+	return c, nil
+}
+
+// Close stops passing events to peers, events not sent yet are dropped
+func (c *Cluster) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.senders.Wait()
+}
+
+// Sync applies state of first reachable peer, it should be called
+// before serving starts, error is returned if no peer is reachable
+func (c *Cluster) Sync() error {
+	for _, peer := range c.peers {
+		state := &clusterState{}
+		if err := c.request(peer.address, http.MethodGet, ClusterStateCall, nil, state); err != nil {
+			continue
+		}
+		for _, user := range state.Users {
+			c.api.applyEvent(&clusterEvent{Kind: putUserEvent, User: user})
+		}
+		for _, room := range state.Rooms {
+			c.api.applyEvent(&clusterEvent{Kind: putRoomEvent, Room: room})
+		}
+		for _, session := range state.Sessions {
+			c.api.applyEvent(&clusterEvent{Kind: sessionOpenedEvent, Session: session})
+		}
+		return nil
+	}
+	return errors.New("no cluster peer reachable")
+}
+
+// PutUser passes added or changed user to peers if node owns him
+func (c *Cluster) PutUser(user *chat.User) {
+	if c.owns(user.Name) {
+		c.broadcast(&clusterEvent{Kind: putUserEvent, User: user})
+	}
+}
+
+// DeleteUser passes deleted user to peers if node owns him
+func (c *Cluster) DeleteUser(name string) {
+	if c.owns(name) {
+		c.broadcast(&clusterEvent{Kind: deleteUserEvent, Name: name})
+	}
+}
+
+// PutRoom passes added or changed room to peers if node owns it,
+// other nodes change rooms only when they sweep deleted user,
+// owner does the same and passes the result
+func (c *Cluster) PutRoom(room *chat.RoomRecord) {
+	if c.owns(room.Name) {
+		c.broadcast(&clusterEvent{Kind: putRoomEvent, Room: room})
+	}
+}
+
+// DeleteRoom passes deleted room to peers if node owns it
+func (c *Cluster) DeleteRoom(name string) {
+	if c.owns(name) {
+		c.broadcast(&clusterEvent{Kind: deleteRoomEvent, Name: name})
+	}
+}
+
+// owner returns address of node stamping messages of history with key
+func (c *Cluster) owner(key string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return c.nodes[hash.Sum32()%uint32(len(c.nodes))]
+}
+
+// owns tells if node stamps messages of history with key
+func (c *Cluster) owns(key string) bool {
+	return c.owner(key) == c.node
+}
+
+// broadcast queues event for every peer, it never blocks,
+// so events for peer which is down too long are dropped
+func (c *Cluster) broadcast(event *clusterEvent) {
+	for _, peer := range c.peers {
+		select {
+		case peer.events <- event:
+		default:
+			atomic.AddUint64(&c.api.stats.ClusterDropped, 1)
+			atomic.StoreInt32(&peer.dropped, 1)
+		}
+	}
+}
+
+// send passes queued events to peer in batches, failed batch
+// is retried with growing delay and dropped after clusterRetries,
+// peer which missed events is synced before next ones
+func (c *Cluster) send(peer *clusterPeer) {
+	defer c.senders.Done()
+	for {
+		if atomic.LoadInt32(&peer.dropped) == 1 && !c.resync(peer) {
+			select {
+			case <-time.After(clusterResyncDelay):
+				continue
+			case <-c.stop:
+				return
+			}
+		}
+
+		var batch []*clusterEvent
+		select {
+		case event := <-peer.events:
+			batch = append(batch, event)
+		case <-c.stop:
+			return
+		}
+	fill:
+		for len(batch) < clusterBatchSize {
+			select {
+			case event := <-peer.events:
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+
+		delay := 100 * time.Millisecond
+		for attempt := 1; ; attempt++ {
+			err := c.request(peer.address, http.MethodPost, ClusterEventsCall, batch, nil)
+			if err == nil {
+				break
+			}
+			if attempt == clusterRetries {
+				atomic.AddUint64(&c.api.stats.ClusterDropped, uint64(len(batch)))
+				atomic.StoreInt32(&peer.dropped, 1)
+				break
+			}
+			select {
+			case <-time.After(delay):
+			case <-c.stop:
+				return
+			}
+			delay *= 2
+		}
+	}
+}
+
+// resync passes users and rooms owned by node to peer which missed
+// events, queued changes of them are covered by state and dropped,
+// other queued events follow state
+func (c *Cluster) resync(peer *clusterPeer) bool {
+	atomic.StoreInt32(&peer.dropped, 0)
+	var kept []*clusterEvent
+drain:
+	for {
+		select {
+		case event := <-peer.events:
+			switch event.Kind {
+			case putUserEvent, deleteUserEvent, putRoomEvent, deleteRoomEvent:
+			default:
+				kept = append(kept, event)
+			}
+		default:
+			break drain
+		}
+	}
+
+	batch := append([]*clusterEvent{{Kind: syncEvent, Node: c.node, State: c.ownedState()}}, kept...)
+	if err := c.request(peer.address, http.MethodPost, ClusterEventsCall, batch, nil); err != nil {
+		atomic.AddUint64(&c.api.stats.ClusterDropped, uint64(len(kept)))
+		atomic.StoreInt32(&peer.dropped, 1)
+		return false
+	}
+	return true
+}
+
+// ownedState returns users and rooms owned by node and all sessions
+func (c *Cluster) ownedState() *clusterState {
+	users, rooms := c.api.Engine.Records()
+	state := &clusterState{Sessions: c.api.sessions.list()}
+	for _, user := range users {
+		if c.owns(user.Name) {
+			state.Users = append(state.Users, user)
+		}
+	}
+	for _, room := range rooms {
+		if c.owns(room.Name) {
+			state.Rooms = append(state.Rooms, room)
+		}
+	}
+	return state
+}
+
+// forward posts message on node owning history with key
+func (c *Cluster) forward(key string, msg *Message) (*Message, error) {
+	stored := &Message{}
+	if err := c.ask(key, ClusterPostCall, msg, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// forwardChange makes change on node owning room, changed room
+// reaches this node with other changes of owner, so they keep order
+func (c *Cluster) forwardChange(change *roomChange) error {
+	return c.ask(change.Room, ClusterRoomCall, change, nil)
+}
+
+// forwardUserChange makes change on node owning user
+// and returns changed user, nil when he was deleted
+func (c *Cluster) forwardUserChange(change *userChange) (*chat.User, error) {
+	var changed *chat.User
+	if err := c.ask(change.Name, ClusterUserCall, change, &changed); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// ask calls node owning key, its errors are returned as they are,
+// unreachable node gives ErrUnavailable
+func (c *Cluster) ask(key, call string, body, result interface{}) error {
+	err := c.request(c.owner(key), http.MethodPost, call, body, result)
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if err != nil {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// request calls API of node at address with body encoded as JSON,
+// response is decoded into result unless it is nil
+func (c *Cluster) request(address, method, call string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	url := fmt.Sprintf("%s://%s%s", c.scheme, address, GetPath(call))
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set(clusterSecretHeader, c.secret)
+
+	resp, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp.StatusCode, content)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(content, result)
+}
+
+// await checks condition until it holds or clusterWait passes,
+// it waits for changes of other nodes which arrive with their events
+func await(condition func() bool) bool {
+	deadline := time.Now().Add(clusterWait)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(clusterWaitInterval)
+	}
+	return true
+}
+
+// replicate passes event to peers if API is node of cluster
+func (a *API) replicate(event *clusterEvent) {
+	if a.cluster != nil {
+		a.cluster.broadcast(event)
+	}
+}
+
+// applyEvent applies change made on other node
+func (a *API) applyEvent(event *clusterEvent) error {
+	switch event.Kind {
+	case putUserEvent:
+		if event.User == nil {
+			return ErrMissingArg
+		}
+		if err := a.Engine.ApplyUser(event.User); err != nil {
+			return err
+		}
+		if event.User.Suspended {
+			a.sessions.closeUser(event.User.Name)
+			a.closeWebsocketClient(event.User.Name)
+			a.events.remove(event.User.Name)
+		}
+	case deleteUserEvent:
+		if err := a.Engine.ApplyUserDeleted(event.Name); err != nil {
+			return err
+		}
+		a.forgetUser(event.Name)
+	case putRoomEvent:
+		if event.Room == nil {
+			return ErrMissingArg
+		}
+		return a.Engine.ApplyRoom(event.Room)
+	case deleteRoomEvent:
+		if err := a.Engine.ApplyRoomDeleted(event.Name); err != nil {
+			return err
+		}
+		a.history.remove(event.Name)
+	case deliverEvent:
+		if event.Message == nil {
+			return ErrMissingArg
+		}
+		a.history.insertTo(event.Key, event.Message)
+		if isDirectKey(event.Key) {
+			a.unread.add(event.Message.To, event.Message.User)
+		}
+		a.publish(event.Message, event.Recipients)
+	case readEvent:
+		a.unread.read(event.Name, event.Peer)
+	case sessionOpenedEvent, sessionClosedEvent:
+		if event.Session == nil {
+			return ErrMissingArg
+		}
+		if event.Kind == sessionOpenedEvent {
+			a.sessions.add(event.Session)
+		} else {
+			a.sessions.revoke(event.Session.ID)
+		}
+	case userSessionsClosedEvent:
+		a.sessions.closeUser(event.Name)
+	case syncEvent:
+		if event.State == nil {
+			return ErrMissingArg
+		}
+		a.applyOwnedState(event.Node, event.State)
+	default:
+		return fmt.Errorf("unknown cluster event %q", event.Kind)
+	}
+	return nil
+}
+
+// applyOwnedState replaces users and rooms owned by node with its
+// state, ones missing in state were deleted on node
+func (a *API) applyOwnedState(node string, state *clusterState) {
+	users := make(map[string]bool)
+	for _, user := range state.Users {
+		users[user.Name] = true
+	}
+	rooms := make(map[string]bool)
+	for _, room := range state.Rooms {
+		rooms[room.Name] = true
+	}
+
+	for _, user := range a.Engine.ListUsers() {
+		if a.cluster.owner(user.Name) == node && !users[user.Name] {
+			a.applyEvent(&clusterEvent{Kind: deleteUserEvent, Name: user.Name})
+		}
+	}
+	for _, user := range state.Users {
+		a.applyEvent(&clusterEvent{Kind: putUserEvent, User: user})
+	}
+	for _, room := range a.Engine.ListRooms() {
+		if a.cluster.owner(room.Name) == node && !rooms[room.Name] {
+			a.applyEvent(&clusterEvent{Kind: deleteRoomEvent, Name: room.Name})
+		}
+	}
+	for _, room := range state.Rooms {
+		a.applyEvent(&clusterEvent{Kind: putRoomEvent, Room: room})
+	}
+	for _, session := range state.Sessions {
+		a.applyEvent(&clusterEvent{Kind: sessionOpenedEvent, Session: session})
+	}
+}
+
+// authorizeNode checks if request was made by cluster node,
+// otherwise sets error status and returns false
+func (a *API) authorizeNode(w http.ResponseWriter, r *http.Request) bool {
+	if a.cluster == nil {
+		writeError(w, ErrNotPermit)
+		return false
+	}
+	secret := r.Header.Get(clusterSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(a.cluster.secret)) != 1 {
+		writeError(w, ErrUnauthorized)
+		return false
+	}
+	return true
+}
+
+// ClusterEvents applies batch of changes made on other node,
+// failed event is not sent again, so following ones are applied
+func (a *API) ClusterEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !a.authorizeNode(w, r) {
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	var events []*clusterEvent
+	if err := json.Unmarshal(content, &events); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	for _, event := range events {
+		a.applyEvent(event)
+	}
+}
+
+// ClusterPost posts message forwarded by node not owning its history
+func (a *API) ClusterPost(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !a.authorizeNode(w, r) {
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(content, msg); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+
+	post := a.postMessage
+	if msg.To != "" {
+		post = a.postDirect
+	}
+	stored, err := post(msg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
+
+// ClusterRoom makes room change forwarded by node not owning room
+func (a *API) ClusterRoom(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !a.authorizeNode(w, r) {
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	change := &roomChange{}
+	if err := json.Unmarshal(content, change); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+
+	// users added on other node may not have reached this one yet,
+	// unknown users are still not found after wait
+	for _, name := range []string{change.Actor, change.Target} {
+		if name != "" {
+			await(func() bool { return a.Engine.UserExists(name) })
+		}
+	}
+	if err := a.applyRoomChange(change); err != nil {
+		writeError(w, err)
+	}
+}
+
+// ClusterUser makes user change forwarded by node not owning user
+// and returns changed user
+func (a *API) ClusterUser(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !a.authorizeNode(w, r) {
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	change := &userChange{}
+	if err := json.Unmarshal(content, change); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+
+	user, err := a.applyUserChange(change)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	payload, err := json.Marshal(user)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
+
+// ClusterState returns users, rooms and sessions to node joining cluster
+func (a *API) ClusterState(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !a.authorizeNode(w, r) {
+		return
+	}
+
+	users, rooms := a.Engine.Records()
+	payload, err := json.Marshal(&clusterState{
+		Users:    users,
+		Rooms:    rooms,
+		Sessions: a.sessions.list(),
+	})
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// directPrefix starts history names of direct conversations,
+// it can't be confused with room name as names of rooms and users
+// can't contain control characters
+const directPrefix = "\x00"
+
+// directKey returns history name of conversation between two users,
+// it is the same for both of them
+func directKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return directPrefix + a + directPrefix + b
+}
+
+func isDirectKey(key string) bool {
+	return strings.HasPrefix(key, directPrefix)
+}
+
+// hasDirectUser checks if user with name takes part in conversation
+func hasDirectUser(key, name string) bool {
+	for _, user := range strings.Split(strings.TrimPrefix(key, directPrefix), directPrefix) {
+		if user == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unread counts direct messages not read yet by recipient, per sender
+type unread struct {
+	sync.Mutex
+	counts map[string]map[string]uint
+}
+
+func newUnread() *unread {
+	return &unread{counts: make(map[string]map[string]uint)}
+}
+
+// add counts message from sender to recipient
+func (u *unread) add(recipient, sender string) {
+	u.Lock()
+	defer u.Unlock()
+	if u.counts[recipient] == nil {
+		u.counts[recipient] = make(map[string]uint)
+	}
+	u.counts[recipient][sender]++
+}
+
+// read marks all messages from sender to recipient as read
+func (u *unread) read(recipient, sender string) {
+	u.Lock()
+	defer u.Unlock()
+	delete(u.counts[recipient], sender)
+}
+
+// of returns copy of unread counts of recipient
+func (u *unread) of(recipient string) map[string]uint {
+	u.Lock()
+	defer u.Unlock()
+	counts := make(map[string]uint)
+	for sender, count := range u.counts[recipient] {
+		counts[sender] = count
+	}
+	return counts
+}
+
+// remove forgets counts of user with name as recipient and sender
+func (u *unread) remove(name string) {
+	u.Lock()
+	defer u.Unlock()
+	delete(u.counts, name)
+	for _, counts := range u.counts {
+		delete(counts, name)
+	}
+}
+
+// dispatchDirect stamps message and delivers it to inbox of recipient,
+// returns message as stored by server
+func (a *API) dispatchDirect(msg *Message) (*Message, error) {
+	if key := directKey(msg.User, msg.To); a.cluster != nil && !a.cluster.owns(key) {
+		return a.cluster.forward(key, msg)
+	}
+	return a.postDirect(msg)
+}
+
+// postDirect dispatches direct message of conversation owned by this node
+func (a *API) postDirect(msg *Message) (*Message, error) {
+	if _, err := a.Engine.GetUserByName(msg.User); err != nil {
+		return nil, err
+	}
+	if _, err := a.Engine.GetUserByName(msg.To); err != nil {
+		return nil, err
+	}
+	if msg.To == msg.User {
+		return nil, chat.ErrMissingArg
+	}
+	msg.Room = ""
+
+	key := directKey(msg.User, msg.To)
+	stored, duplicate := a.history.addTo(key, msg)
+	if duplicate {
+		return stored, nil
+	}
+
+	a.unread.add(msg.To, msg.User)
+	a.metrics.messageDispatched(1)
+	a.publish(msg, []string{msg.To})
+	a.replicate(&clusterEvent{Kind: deliverEvent, Key: key, Message: msg, Recipients: []string{msg.To}})
+	return msg, nil
+}
+
+// ReceiveDirect receives direct message to user from path
+func (a *API) ReceiveDirect(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.ChatPermission)
+	if !ok {
+		return
+	}
+	content, err := ioutil.ReadAll(r.Body)
+	atomic.AddUint64(&a.stats.BytesTotal, uint64(len(content)))
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(content, msg); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	msg.User = user.Name
+	msg.To = mux.Vars(r)["name"]
+
+	stored, err := a.dispatchDirect(msg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	atomic.AddUint64(&a.stats.MessagesReceived, 1)
+
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
+
+// DirectMessages returns page of direct messages exchanged with user
+// from path, messages from him are marked read
+func (a *API) DirectMessages(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.ChatPermission)
+	if !ok {
+		return
+	}
+
+	peer := mux.Vars(r)["name"]
+	if !a.Engine.UserExists(peer) {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	a.unread.read(user.Name, peer)
+	a.replicate(&clusterEvent{Kind: readEvent, Name: user.Name, Peer: peer})
+	a.writePage(w, r, directKey(user.Name, peer))
+}
+
+// GetUnread returns number of unread direct messages by sender
+func (a *API) GetUnread(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.ChatPermission)
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(a.unread.of(user.Name))
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// Error is JSON envelope of failed API call, Code is stable
+// and can be matched with errors.Is against errors below
+type Error struct {
+	// Status is HTTP status of response
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors returned by API, client errors match them with errors.Is
+var (
+	ErrBadRequest   = &Error{http.StatusBadRequest, "bad_request", "malformed request"}
+	ErrMissingArg   = &Error{http.StatusBadRequest, "missing_argument", "missing argument"}
+	ErrUnauthorized = &Error{http.StatusUnauthorized, "unauthorized", "unknown user or invalid session"}
+	ErrNotPermit    = &Error{http.StatusForbidden, "not_permitted", "not permitted"}
+	ErrSuspended    = &Error{http.StatusForbidden, "suspended", "user is suspended"}
+	ErrBanned       = &Error{http.StatusForbidden, "banned", "banned from room"}
+	ErrMuted        = &Error{http.StatusForbidden, "muted", "muted in room"}
+	ErrNotFound     = &Error{http.StatusNotFound, "not_found", "not found"}
+	ErrExists       = &Error{http.StatusConflict, "already_exists", "already exists"}
+	ErrNoResources  = &Error{http.StatusInsufficientStorage, "out_of_resources", "limit reached"}
+	ErrRateLimited  = &Error{http.StatusTooManyRequests, "rate_limited", "too many requests"}
+	ErrInternal     = &Error{http.StatusInternalServerError, "internal", "internal error"}
+	ErrUnavailable  = &Error{http.StatusServiceUnavailable, "unavailable", "cluster node unavailable"}
+)
+
+// chatErrors maps chat engine errors to API errors
+var chatErrors = map[error]*Error{
+	chat.ErrNotFound:    ErrNotFound,
+	chat.ErrExists:      ErrExists,
+	chat.ErrNoResources: ErrNoResources,
+	chat.ErrMissingArg:  ErrMissingArg,
+	chat.ErrNotPermit:   ErrNotPermit,
+	chat.ErrBanned:      ErrBanned,
+	chat.ErrMuted:       ErrMuted,
+	chat.ErrInvalidName: ErrBadRequest,
+	ErrInvalidSession:   ErrUnauthorized,
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Message
+}
+
+// Is matches errors with the same code, chat engine errors
+// match API errors they are mapped to
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return t.Code == e.Code
+	}
+	if mapped, ok := chatErrors[target]; ok {
+		return mapped.Code == e.Code
+	}
+	return false
+}
+
+// toError returns API error describing err,
+// errors not known to API are treated as internal
+func toError(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for chatErr, mapped := range chatErrors {
+		if errors.Is(err, chatErr) {
+			return &Error{Status: mapped.Status, Code: mapped.Code, Message: err.Error()}
+		}
+	}
+	return ErrInternal
+}
+
+// writeError writes JSON envelope of err with matching status
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toError(err)
+	payload, _ := json.Marshal(apiErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	w.Write(payload)
+}
+
+// readError returns API error from failed response body,
+// responses without envelope get error with code of their status
+func readError(status int, body []byte) *Error {
+	apiErr := &Error{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr = statusError(status)
+	}
+	apiErr.Status = status
+	return apiErr
+}
+
+// statusError returns API error matching HTTP status
+func statusError(status int) *Error {
+	for _, apiErr := range []*Error{ErrBadRequest, ErrUnauthorized, ErrNotPermit, ErrNotFound, ErrExists, ErrNoResources, ErrRateLimited, ErrInternal, ErrUnavailable} {
+		if apiErr.Status == status {
+			return &Error{Code: apiErr.Code, Message: http.StatusText(status)}
+		}
+	}
+	return &Error{Code: fmt.Sprintf("http_%d", status), Message: http.StatusText(status)}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// SSETransport delivers events as Server-Sent Events stream
+const SSETransport = "sse"
+
+// Types of events pushed to SSE streams
+const (
+	// EventMessage carries message posted to room or sent directly
+	EventMessage = "message"
+	// EventJoin tells that User joined Room
+	EventJoin = "join"
+	// EventExit tells that User left Room, also when kicked or deleted
+	EventExit = "exit"
+	// EventRoomDeleted tells that Room was deleted
+	EventRoomDeleted = "room_deleted"
+	// EventReset starts stream which could not resume after
+	// Last-Event-ID, given by other cluster node or before restart,
+	// events since then may be lost, so state should be reloaded
+	EventReset = "reset"
+)
+
+// Event is notification pushed to user over SSE stream,
+// ID grows with every event of user, in cluster IDs given by every
+// node are distinct, so ID of other node is recognized when resuming
+type Event struct {
+	ID      uint64   `json:"-"`
+	Type    string   `json:"-"`
+	Room    string   `json:"room,omitempty"`
+	User    string   `json:"user,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// eventLog keeps recent events of user, so streams can resume
+type eventLog struct {
+	lastID uint64
+	events []*Event
+	// changed is closed and replaced when event is added,
+	// it is closed for good when log is removed
+	changed chan struct{}
+}
+
+// eventLogs keeps logs of users who opened SSE stream,
+// they get events from the moment of opening
+type eventLogs struct {
+	sync.Mutex
+	size int
+	logs map[string]*eventLog
+	// node is remainder of IDs given by this node divided by nodes
+	node, nodes uint64
+	// done is closed when streams are ended on shutdown
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newEventLogs() *eventLogs {
+	return &eventLogs{
+		size:  defaultQueueSize,
+		logs:  make(map[string]*eventLog),
+		nodes: 1,
+		done:  make(chan struct{}),
+	}
+}
+
+// setNode makes IDs distinct from IDs of other cluster nodes
+func (e *eventLogs) setNode(node, nodes int) {
+	e.Lock()
+	defer e.Unlock()
+	e.node, e.nodes = uint64(node), uint64(nodes)
+}
+
+// resumes tells if stream of user with name can resume after id,
+// it can't when id was given by other node or before restart
+func (e *eventLogs) resumes(name string, id uint64) bool {
+	e.Lock()
+	defer e.Unlock()
+	log, ok := e.logs[name]
+	return ok && id%e.nodes == e.node && id <= log.lastID
+}
+
+// open returns ID of last event of user with name,
+// his log is started if needed
+func (e *eventLogs) open(name string) uint64 {
+	e.Lock()
+	defer e.Unlock()
+	log, ok := e.logs[name]
+	if !ok {
+		log = &eventLog{changed: make(chan struct{})}
+		e.logs[name] = log
+	}
+	return log.lastID
+}
+
+// add appends event to logs of users with names,
+// every user gets copy with his own ID
+func (e *eventLogs) add(names []string, event Event) {
+	e.Lock()
+	defer e.Unlock()
+	for _, name := range names {
+		log, ok := e.logs[name]
+		if !ok {
+			continue
+		}
+		added := event
+		log.lastID = (log.lastID/e.nodes+1)*e.nodes + e.node
+		added.ID = log.lastID
+		log.events = append(log.events, &added)
+		if drop := len(log.events) - e.size; drop > 0 {
+			log.events = append([]*Event(nil), log.events[drop:]...)
+		}
+		close(log.changed)
+		log.changed = make(chan struct{})
+	}
+}
+
+// since returns events of user with name after ID, number of such
+// events which are no longer kept and channel closed on next change,
+// ok is false when log was removed
+func (e *eventLogs) since(name string, id uint64) (events []*Event, missed uint64, changed <-chan struct{}, ok bool) {
+	e.Lock()
+	defer e.Unlock()
+	log, ok := e.logs[name]
+	if !ok {
+		return nil, 0, nil, false
+	}
+	for _, event := range log.events {
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+	if len(events) > 0 && events[0].ID > id+e.nodes {
+		missed = (events[0].ID-id)/e.nodes - 1
+	}
+	return events, missed, log.changed, true
+}
+
+// remove ends streams of user with name and drops his log
+func (e *eventLogs) remove(name string) {
+	e.Lock()
+	defer e.Unlock()
+	if log, ok := e.logs[name]; ok {
+		close(log.changed)
+		delete(e.logs, name)
+	}
+}
+
+// MembersChanged pushes join and exit events to room members,
+// users who left get their own exit events too
+func (e *eventLogs) MembersChanged(room string, joined, left, members []string) {
+	for _, name := range joined {
+		e.add(members, Event{Type: EventJoin, Room: room, User: name})
+	}
+	recipients := append(append([]string(nil), members...), left...)
+	for _, name := range left {
+		e.add(recipients, Event{Type: EventExit, Room: room, User: name})
+	}
+}
+
+// RoomDeleted pushes room deletion to its last members
+func (e *eventLogs) RoomDeleted(room string, members []string) {
+	e.add(members, Event{Type: EventRoomDeleted, Room: room})
+}
+
+// publish delivers message to subscriptions and event streams
+// of users with names
+func (a *API) publish(msg *Message, names []string) {
+	a.broker.Publish(msg, names)
+	a.events.add(names, Event{Type: EventMessage, Room: msg.Room, User: msg.User, Message: msg})
+}
+
+// SetEventLog sets number of events kept for resuming SSE streams
+// of every user, zero keeps default
+func (a *API) SetEventLog(size uint) {
+	a.events.Lock()
+	defer a.events.Unlock()
+	if size > 0 {
+		a.events.size = int(size)
+	}
+}
+
+// CloseEventStreams ends SSE streams, so server can shut down,
+// clients resume them after restart with Last-Event-ID
+func (a *API) CloseEventStreams() {
+	a.events.doneOnce.Do(func() { close(a.events.done) })
+}
+
+// Events streams events of user as Server-Sent Events,
+// stream resumes after Last-Event-ID header if it is given,
+// if it can't, stream starts with EventReset
+func (a *API) Events(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.ChatPermission)
+	if !ok {
+		return
+	}
+	resume := r.Header.Get("Last-Event-ID")
+	cursor, err := parseUintParam(resume, 0)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	lastID := a.events.open(user.Name)
+	reset := resume != "" && !a.events.resumes(user.Name, cursor)
+	if resume == "" || reset {
+		cursor = lastID
+	}
+
+	controller := http.NewResponseController(w)
+	// stream outlives write timeout of server
+	controller.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		if err := writeEvent(w, &Event{ID: cursor, Type: EventReset}); err != nil {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(pingPeriod)
+	defer keepalive.Stop()
+	for {
+		events, missed, changed, ok := a.events.since(user.Name, cursor)
+		if !ok {
+			return
+		}
+		if missed > 0 {
+			atomic.AddUint64(&a.stats.MessagesDropped, missed)
+			a.metrics.queueOverflowed(SSETransport, DropOldest, missed)
+		}
+		for _, event := range events {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			cursor = event.ID
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-a.events.done:
+			return
+		}
+	}
+}
+
+// writeEvent writes event in SSE format, data of message event
+// is message itself, other events carry room and user
+func writeEvent(w io.Writer, event *Event) error {
+	var data interface{} = event
+	if event.Message != nil {
+		data = event.Message
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+	return err
+}
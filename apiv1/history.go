@@ -0,0 +1,266 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistoryLimit is default number of messages kept per room
+	defaultHistoryLimit = 1000
+	// defaultPageLimit is number of messages returned when limit is not given
+	defaultPageLimit = 50
+	// maxPageLimit is maximum number of messages returned at once
+	maxPageLimit = 1000
+)
+
+// roomHistory keeps ordered messages posted in a room
+type roomHistory struct {
+	sync.Mutex
+	messages []*Message
+	lastSeq  uint64
+	// lastSeqFrom numbers direct messages per sender, so each side
+	// of conversation receives continuous sequence
+	lastSeqFrom map[string]uint64
+	// byClientID indexes retained messages by sender and client ID
+	byClientID map[string]*Message
+}
+
+// history keeps messages of all rooms bounded by count and age
+type history struct {
+	sync.Mutex
+	rooms  map[string]*roomHistory
+	lastID uint64
+	limit  uint
+	maxAge time.Duration
+	// node and nodes make IDs of cluster nodes distinct,
+	// node assigns only IDs equal to node modulo nodes
+	node  uint64
+	nodes uint64
+}
+
+func newHistory() *history {
+	return &history{
+		rooms: make(map[string]*roomHistory),
+		limit: defaultHistoryLimit,
+		nodes: 1,
+	}
+}
+
+// room returns history of room with name, creating it if needed
+func (h *history) room(name string) *roomHistory {
+	h.Lock()
+	defer h.Unlock()
+	room, ok := h.rooms[name]
+	if !ok {
+		room = &roomHistory{byClientID: make(map[string]*Message)}
+		h.rooms[name] = room
+	}
+	return room
+}
+
+// nextID returns next message ID, IDs grow across all rooms
+func (h *history) nextID() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	h.lastID = (h.lastID/h.nodes+1)*h.nodes + h.node
+	return h.lastID
+}
+
+// setNode makes IDs distinct from IDs of other cluster nodes
+func (h *history) setNode(node, nodes int) {
+	h.Lock()
+	defer h.Unlock()
+	h.node, h.nodes = uint64(node), uint64(nodes)
+}
+
+// observeID makes IDs assigned later greater than id of message
+// stamped by other node
+func (h *history) observeID(id uint64) {
+	h.Lock()
+	defer h.Unlock()
+	if id > h.lastID {
+		h.lastID = id
+	}
+}
+
+// clientKey identifies message by its sender and client ID
+func clientKey(msg *Message) string {
+	return msg.User + "/" + msg.ClientID
+}
+
+// add stamps message with ID, room sequence number and time and stores
+// it in room history, if message with the same client ID was already
+// posted by user, the stored one is returned with duplicate set
+func (h *history) add(msg *Message) (stored *Message, duplicate bool) {
+	return h.addTo(msg.Room, msg)
+}
+
+// addTo stores message in history with name, it is used directly
+// for conversations which are not rooms
+func (h *history) addTo(name string, msg *Message) (stored *Message, duplicate bool) {
+	room := h.room(name)
+	room.Lock()
+	defer room.Unlock()
+
+	if msg.ClientID != "" {
+		if stored, ok := room.byClientID[clientKey(msg)]; ok {
+			return stored, true
+		}
+		room.byClientID[clientKey(msg)] = msg
+	}
+
+	msg.ID = h.nextID()
+	msg.Seq = room.nextSeq(msg)
+	msg.Time = time.Now()
+	room.messages = append(room.messages, msg)
+	h.trim(room)
+	return msg, false
+}
+
+// insertTo stores message stamped by other node in history with name,
+// messages are kept ordered by ID
+func (h *history) insertTo(name string, msg *Message) {
+	h.observeID(msg.ID)
+	room := h.room(name)
+	room.Lock()
+	defer room.Unlock()
+
+	if msg.ClientID != "" {
+		if _, ok := room.byClientID[clientKey(msg)]; ok {
+			return
+		}
+		room.byClientID[clientKey(msg)] = msg
+	}
+	room.observeSeq(msg)
+	i := len(room.messages)
+	for i > 0 && room.messages[i-1].ID > msg.ID {
+		i--
+	}
+	room.messages = append(room.messages, nil)
+	copy(room.messages[i+1:], room.messages[i:])
+	room.messages[i] = msg
+	h.trim(room)
+}
+
+// nextSeq returns sequence number of message, room messages are
+// numbered together, direct ones per sender
+// room must be locked
+func (room *roomHistory) nextSeq(msg *Message) uint64 {
+	if msg.To == "" {
+		room.lastSeq++
+		return room.lastSeq
+	}
+	if room.lastSeqFrom == nil {
+		room.lastSeqFrom = make(map[string]uint64)
+	}
+	room.lastSeqFrom[msg.User]++
+	return room.lastSeqFrom[msg.User]
+}
+
+// observeSeq makes sequence numbers assigned later greater than
+// number of message stamped by other node
+// room must be locked
+func (room *roomHistory) observeSeq(msg *Message) {
+	if msg.To == "" {
+		if msg.Seq > room.lastSeq {
+			room.lastSeq = msg.Seq
+		}
+		return
+	}
+	if room.lastSeqFrom == nil {
+		room.lastSeqFrom = make(map[string]uint64)
+	}
+	if msg.Seq > room.lastSeqFrom[msg.User] {
+		room.lastSeqFrom[msg.User] = msg.Seq
+	}
+}
+
+// trim drops messages above count limit or older than maxAge
+// room must be locked
+func (h *history) trim(room *roomHistory) {
+	h.Lock()
+	limit, maxAge := h.limit, h.maxAge
+	h.Unlock()
+
+	drop := 0
+	if uint(len(room.messages)) > limit {
+		drop = len(room.messages) - int(limit)
+	}
+	if maxAge > 0 {
+		deadline := time.Now().Add(-maxAge)
+		for drop < len(room.messages) && room.messages[drop].Time.Before(deadline) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		for _, msg := range room.messages[:drop] {
+			if msg.ClientID != "" {
+				delete(room.byClientID, clientKey(msg))
+			}
+		}
+		room.messages = append([]*Message(nil), room.messages[drop:]...)
+	}
+}
+
+// remove drops history of room with name
+func (h *history) remove(name string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.rooms, name)
+}
+
+// removeDirect drops history of direct conversations of user with name
+func (h *history) removeDirect(name string) {
+	h.Lock()
+	defer h.Unlock()
+	for key := range h.rooms {
+		if isDirectKey(key) && hasDirectUser(key, name) {
+			delete(h.rooms, key)
+		}
+	}
+}
+
+// page returns up to limit messages with after < ID < before,
+// zero before or after means no bound, if there are more messages
+// than limit the newest ones are returned unless only after is set
+func (h *history) page(name string, before, after uint64, limit int) []Message {
+	room := h.room(name)
+	room.Lock()
+	defer room.Unlock()
+	h.trim(room)
+
+	var msgs []*Message
+	for _, msg := range room.messages {
+		if msg.ID <= after || (before != 0 && msg.ID >= before) {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) > limit {
+		if after != 0 && before == 0 {
+			msgs = msgs[:limit]
+		} else {
+			msgs = msgs[len(msgs)-limit:]
+		}
+	}
+
+	page := make([]Message, 0, len(msgs))
+	for _, msg := range msgs {
+		page = append(page, *msg)
+	}
+	return page
+}
+
+// SetHistoryLimits sets how many messages and for how long are kept
+// in each room history, zero count keeps default, zero maxAge
+// keeps messages until count limit is reached
+func (a *API) SetHistoryLimits(count uint, maxAge time.Duration) {
+	a.history.Lock()
+	defer a.history.Unlock()
+	if count > 0 {
+		a.history.limit = count
+	}
+	a.history.maxAge = maxAge
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// latencyBuckets are upper bounds of request duration buckets in seconds
+	latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// fanoutBuckets are upper bounds of message recipients buckets
+	fanoutBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+)
+
+// bucketHistogram is Prometheus histogram with fixed buckets
+type bucketHistogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newBucketHistogram(bounds []float64) *bucketHistogram {
+	return &bucketHistogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *bucketHistogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write writes histogram samples, labels are put before le label
+func (h *bucketHistogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+// routeMetrics are metrics of single API route
+type routeMetrics struct {
+	codes    map[int]uint64
+	latency  *bucketHistogram
+	inFlight int64
+}
+
+// Metrics are server metrics exposed in Prometheus text format
+type Metrics struct {
+	lock   sync.Mutex
+	routes map[string]*routeMetrics
+	fanout *bucketHistogram
+	// overflows counts full queues by transport and overflow policy
+	overflows map[string]map[string]uint64
+}
+
+// NewMetrics returns empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		routes:    make(map[string]*routeMetrics),
+		fanout:    newBucketHistogram(fanoutBuckets),
+		overflows: make(map[string]map[string]uint64),
+	}
+}
+
+func (m *Metrics) route(name string) *routeMetrics {
+	rm, ok := m.routes[name]
+	if !ok {
+		rm = &routeMetrics{
+			codes:   make(map[int]uint64),
+			latency: newBucketHistogram(latencyBuckets),
+		}
+		m.routes[name] = rm
+	}
+	return rm
+}
+
+func (m *Metrics) requestStarted(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.route(name).inFlight++
+}
+
+func (m *Metrics) requestFinished(name string, code int, elapsed time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rm := m.route(name)
+	rm.inFlight--
+	rm.codes[code]++
+	rm.latency.observe(elapsed.Seconds())
+}
+
+// messageDispatched records number of message recipients
+func (m *Metrics) messageDispatched(recipients int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.fanout.observe(float64(recipients))
+}
+
+// queueOverflowed counts policy applied count times to full subscriber queue
+func (m *Metrics) queueOverflowed(transport, policy string, count uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	policies, ok := m.overflows[transport]
+	if !ok {
+		policies = make(map[string]uint64)
+		m.overflows[transport] = policies
+	}
+	policies[policy] += count
+}
+
+// statusRecorder remembers status code written by handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets handlers control response, eg. flush SSE stream
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets websocket upgrader take over connection
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	r.code = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Instrument returns route which handler counts requests, their status codes
+// and duration, note that websocket requests last as long as connection
+func (a *API) Instrument(route *Route) *Route {
+	instrumented := *route
+	instrumented.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		a.metrics.requestStarted(route.Name)
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		defer func() {
+			a.metrics.requestFinished(route.Name, recorder.code, time.Since(start))
+		}()
+		route.HandlerFunc(recorder, r)
+	}
+	return &instrumented
+}
+
+// Metrics writes server metrics in Prometheus text exposition format
+func (a *API) Metrics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	stats := a.StatsSnapshot()
+	counter(w, "perfchat_added_users_total", "Number of added users.", stats.AddedUsers)
+	counter(w, "perfchat_added_rooms_total", "Number of added rooms.", stats.AddedRooms)
+	counter(w, "perfchat_deleted_rooms_total", "Number of deleted rooms.", stats.DeletedRooms)
+	counter(w, "perfchat_deleted_users_total", "Number of deleted users.", stats.DeletedUsers)
+	counter(w, "perfchat_messages_received_total", "Number of messages posted by users.", stats.MessagesReceived)
+	counter(w, "perfchat_received_bytes_total", "Number of bytes of posted messages.", stats.BytesTotal)
+	counter(w, "perfchat_rate_limited_total", "Number of requests rejected by rate limits.", stats.RateLimited)
+	counter(w, "perfchat_cluster_events_dropped_total", "Number of changes not passed to cluster peers.", stats.ClusterDropped)
+
+	a.wsLock.RLock()
+	websocketClients := len(a.websocketClients)
+	a.wsLock.RUnlock()
+	gauge(w, "perfchat_websocket_clients", "Number of connected websocket clients.", int64(websocketClients))
+	gauge(w, "perfchat_users", "Number of users.", int64(a.Engine.UsersCount()))
+	gauge(w, "perfchat_rooms", "Number of rooms.", int64(a.Engine.RoomsCount()))
+
+	rooms := a.Engine.ListRooms()
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	header(w, "perfchat_room_fanout", "Number of users receiving message posted to room.", "gauge")
+	for _, room := range rooms {
+		fmt.Fprintf(w, "perfchat_room_fanout{room=\"%s\"} %d\n", escapeLabel(room.Name), len(room.Users))
+	}
+
+	a.metrics.lock.Lock()
+	defer a.metrics.lock.Unlock()
+
+	header(w, "perfchat_message_fanout", "Number of recipients of dispatched messages.", "histogram")
+	a.metrics.fanout.write(w, "perfchat_message_fanout", "")
+
+	transports := []string{PollTransport, WebsocketTransport}
+	for transport := range a.metrics.overflows {
+		if transport != PollTransport && transport != WebsocketTransport {
+			transports = append(transports, transport)
+		}
+	}
+	sort.Strings(transports)
+	header(w, "perfchat_messages_dropped_total", "Number of messages dropped because subscriber queue was full.", "counter")
+	for _, transport := range transports {
+		policies := a.metrics.overflows[transport]
+		fmt.Fprintf(w, "perfchat_messages_dropped_total{transport=\"%s\"} %d\n", escapeLabel(transport), policies[DropOldest]+policies[DropNewest])
+	}
+	header(w, "perfchat_slow_consumers_disconnected_total", "Number of subscribers disconnected because their queue was full.", "counter")
+	for _, transport := range transports {
+		fmt.Fprintf(w, "perfchat_slow_consumers_disconnected_total{transport=\"%s\"} %d\n", escapeLabel(transport), a.metrics.overflows[transport][Disconnect])
+	}
+
+	var names []string
+	for name := range a.metrics.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header(w, "perfchat_http_requests_total", "Number of HTTP requests by route and status code.", "counter")
+	for _, name := range names {
+		rm := a.metrics.routes[name]
+		var codes []int
+		for code := range rm.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "perfchat_http_requests_total{route=\"%s\",code=\"%d\"} %d\n", escapeLabel(name), code, rm.codes[code])
+		}
+	}
+
+	header(w, "perfchat_http_requests_in_flight", "Number of HTTP requests being served.", "gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "perfchat_http_requests_in_flight{route=\"%s\"} %d\n", escapeLabel(name), a.metrics.routes[name].inFlight)
+	}
+
+	header(w, "perfchat_http_request_duration_seconds", "Duration of HTTP requests by route.", "histogram")
+	for _, name := range names {
+		a.metrics.routes[name].latency.write(w, "perfchat_http_request_duration_seconds", fmt.Sprintf("route=\"%s\",", escapeLabel(name)))
+	}
+}
+
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func counter(w io.Writer, name, help string, value uint64) {
+	header(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func gauge(w io.Writer, name, help string, value int64) {
+	header(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// braces turns labels with trailing comma into label set
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + strings.TrimSuffix(labels, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
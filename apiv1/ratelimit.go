@@ -0,0 +1,251 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// pruneBuckets is number of client buckets after which idle ones are dropped
+const pruneBuckets = 10000
+
+// RateLimit is token bucket refilled with Rate tokens per second
+// up to Burst tokens, zero Rate disables limit
+type RateLimit struct {
+	Rate  float64
+	Burst uint
+}
+
+// RateLimits configures limiting of API requests
+type RateLimits struct {
+	// Global limits all requests to server
+	Global RateLimit
+	// User limits requests of every user on all routes
+	User RateLimit
+	// Routes limit requests of every user on route with given name
+	Routes map[string]RateLimit
+}
+
+// capacity returns maximum number of tokens, at least one
+func (l RateLimit) capacity() float64 {
+	if l.Burst == 0 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// bucket is token bucket of single limit
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens earned since last refill
+func (b *bucket) refill(limit RateLimit, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > limit.capacity() {
+		b.tokens = limit.capacity()
+	}
+	b.last = now
+}
+
+// wait returns time after which bucket has a token
+func (b *bucket) wait(limit RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// limiter keeps buckets of global, user and user route limits
+type limiter struct {
+	sync.Mutex
+	limits RateLimits
+	global *bucket
+	// buckets of clients keyed by route name, "" is limit of all routes
+	buckets map[string]map[string]*bucket
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		buckets: make(map[string]map[string]*bucket),
+	}
+}
+
+// perClient tells if route has limits per client
+func (l *limiter) perClient(route string) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.limits.User.Rate > 0 || l.limits.Routes[route].Rate > 0
+}
+
+// check is bucket with its limit
+type check struct {
+	limit  RateLimit
+	bucket *bucket
+}
+
+// admit is done before request is authenticated, it takes token from
+// global bucket and refuses user claimed by request if his buckets are
+// empty, they are not taken from as claim can't be trusted yet
+func (l *limiter) admit(route, claimed string, now time.Time) (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	var global []check
+	if l.limits.Global.Rate > 0 {
+		if l.global == nil {
+			l.global = &bucket{tokens: l.limits.Global.capacity(), last: now}
+		}
+		global = append(global, check{l.limits.Global, l.global})
+	}
+	var claims []check
+	if claimed != "" {
+		claims = l.clientChecks(route, claimed, false, now)
+	}
+	if wait := waitAll(append(claims, global...), now); wait > 0 {
+		return wait, false
+	}
+	takeAll(global)
+	return 0, true
+}
+
+// take removes token from every bucket of client on route,
+// if any of them is empty nothing is taken and wait time is returned
+func (l *limiter) take(route, client string, now time.Time) (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	checks := l.clientChecks(route, client, true, now)
+	if wait := waitAll(checks, now); wait > 0 {
+		return wait, false
+	}
+	takeAll(checks)
+	return 0, true
+}
+
+// clientChecks returns user and route buckets of client,
+// missing buckets are added only when add is set
+func (l *limiter) clientChecks(route, client string, add bool, now time.Time) []check {
+	var checks []check
+	if l.limits.User.Rate > 0 {
+		if b := l.bucket("", client, l.limits.User, add, now); b != nil {
+			checks = append(checks, check{l.limits.User, b})
+		}
+	}
+	if limit := l.limits.Routes[route]; limit.Rate > 0 {
+		if b := l.bucket(route, client, limit, add, now); b != nil {
+			checks = append(checks, check{limit, b})
+		}
+	}
+	return checks
+}
+
+// waitAll refills buckets and returns time after which all have a token
+func waitAll(checks []check, now time.Time) time.Duration {
+	var wait time.Duration
+	for _, c := range checks {
+		c.bucket.refill(c.limit, now)
+		if w := c.bucket.wait(c.limit); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// takeAll removes token from every bucket
+func takeAll(checks []check) {
+	for _, c := range checks {
+		c.bucket.tokens--
+	}
+}
+
+// bucket returns bucket of client on route, new buckets are full,
+// missing bucket is nil unless add is set
+func (l *limiter) bucket(route, client string, limit RateLimit, add bool, now time.Time) *bucket {
+	clients, ok := l.buckets[route]
+	if !ok {
+		if !add {
+			return nil
+		}
+		clients = make(map[string]*bucket)
+		l.buckets[route] = clients
+	}
+	b, ok := clients[client]
+	if !ok {
+		if !add {
+			return nil
+		}
+		if len(clients) >= pruneBuckets {
+			prune(clients, limit, now)
+		}
+		b = &bucket{tokens: limit.capacity(), last: now}
+		clients[client] = b
+	}
+	return b
+}
+
+// prune drops buckets which were refilled to full, they are
+// the same as new ones
+func prune(clients map[string]*bucket, limit RateLimit, now time.Time) {
+	for client, b := range clients {
+		b.refill(limit, now)
+		if b.tokens >= limit.capacity() {
+			delete(clients, client)
+		}
+	}
+}
+
+// SetRateLimits replaces rate limits of API, buckets start full
+func (a *API) SetRateLimits(limits RateLimits) {
+	a.limiter.Lock()
+	defer a.limiter.Unlock()
+	a.limiter.limits = limits
+	a.limiter.global = nil
+	a.limiter.buckets = make(map[string]map[string]*bucket)
+}
+
+// rateLimitClient returns name of authenticated user, unauthenticated
+// requests are limited by remote host
+func rateLimitClient(r *http.Request, user *chat.User) string {
+	if user != nil {
+		return user.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "\x00" + host
+}
+
+// RateLimit returns route which handler rejects requests over
+// rate limits with StatusTooManyRequests and Retry-After header
+// limits are checked before authentication, so requests over them
+// cost no password hashing, user is charged after he is authenticated
+func (a *API) RateLimit(route *Route) *Route {
+	limited := *route
+	limited.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		wait, ok := a.limiter.admit(route.Name, a.claimedUser(r), time.Now())
+		if ok && a.limiter.perClient(route.Name) {
+			// handler reuses user authenticated here
+			var user *chat.User
+			r, user = a.authenticate(r)
+			wait, ok = a.limiter.take(route.Name, rateLimitClient(r, user), time.Now())
+		}
+		if !ok {
+			atomic.AddUint64(&a.stats.RateLimited, 1)
+			r.Body.Close()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, ErrRateLimited)
+			return
+		}
+		route.HandlerFunc(w, r)
+	}
+	return &limited
+}
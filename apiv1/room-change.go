@@ -0,0 +1,108 @@
+package api
+
+import (
+	"time"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// Kinds of room changes
+const (
+	createRoomChange   = "create"
+	deleteRoomChange   = "delete"
+	joinRoomChange     = "join"
+	acceptInviteChange = "accept"
+	exitRoomChange     = "exit"
+	inviteUserChange   = "invite"
+	kickUserChange     = "kick"
+	banUserChange      = "ban"
+	unbanUserChange    = "unban"
+	muteUserChange     = "mute"
+	promoteUserChange  = "promote"
+	demoteUserChange   = "demote"
+	transferRoomChange = "transfer"
+)
+
+// roomChange is change of Room requested by Actor, in cluster
+// it is made by node owning room, so rooms change in single order
+type roomChange struct {
+	Kind  string `json:"kind"`
+	Actor string `json:"actor"`
+	Room  string `json:"room"`
+	// Target is user moderation is done on
+	Target string `json:"target,omitempty"`
+	// Access and Password of created room, Password is also
+	// secret given when joining password protected room
+	Access   string `json:"access,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Duration of mute in seconds
+	Duration uint `json:"duration,omitempty"`
+}
+
+// changeRoom makes change on this node or on node owning room
+func (a *API) changeRoom(change *roomChange) error {
+	if a.cluster != nil && !a.cluster.owns(change.Room) {
+		return a.cluster.forwardChange(change)
+	}
+	return a.applyRoomChange(change)
+}
+
+// applyRoomChange makes change of room owned by this node
+func (a *API) applyRoomChange(change *roomChange) error {
+	actor, room, target := change.Actor, change.Room, change.Target
+	switch change.Kind {
+	case createRoomChange:
+		return a.createRoom(change)
+	case deleteRoomChange:
+		if err := a.Engine.DeleteRoom(actor, room); err != nil {
+			return err
+		}
+		a.history.remove(room)
+		return nil
+	case joinRoomChange:
+		return a.Engine.JoinRoomWithPassword(actor, room, change.Password)
+	case acceptInviteChange:
+		return a.Engine.AcceptInvite(actor, room)
+	case exitRoomChange:
+		return a.Engine.ExitRoom(actor, room)
+	case inviteUserChange:
+		return a.Engine.InviteUser(actor, room, target)
+	case kickUserChange:
+		return a.Engine.KickUser(actor, room, target)
+	case banUserChange:
+		return a.Engine.BanUser(actor, room, target)
+	case unbanUserChange:
+		return a.Engine.UnbanUser(actor, room, target)
+	case muteUserChange:
+		return a.Engine.MuteUser(actor, room, target, time.Duration(change.Duration)*time.Second)
+	case promoteUserChange:
+		return a.Engine.PromoteModerator(actor, room, target)
+	case demoteUserChange:
+		return a.Engine.DemoteModerator(actor, room, target)
+	case transferRoomChange:
+		return a.Engine.TransferOwnership(actor, room, target)
+	default:
+		return ErrBadRequest
+	}
+}
+
+// createRoom adds room with creator as its only member
+func (a *API) createRoom(change *roomChange) error {
+	creator, err := a.Engine.GetUserByName(change.Actor)
+	if err != nil {
+		return err
+	}
+	var password *chat.Password
+	if change.Password != "" {
+		if password, err = chat.NewPassword(change.Password); err != nil {
+			return ErrInternal
+		}
+	}
+	return a.Engine.AddRoom(&chat.Room{
+		Creator:  change.Actor,
+		Name:     change.Room,
+		Users:    []*chat.User{creator},
+		Access:   change.Access,
+		Password: password,
+	})
+}
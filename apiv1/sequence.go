@@ -0,0 +1,55 @@
+package api
+
+import "sync"
+
+// SequenceTracker follows per room sequence numbers of received
+// messages and detects lost and reordered ones
+type SequenceTracker struct {
+	sync.Mutex
+	last map[string]uint64
+}
+
+// NewSequenceTracker returns empty tracker
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{
+		last: make(map[string]uint64),
+	}
+}
+
+// Track records received message and returns number of messages in its
+// room which were skipped since previously tracked one
+// inOrder is false when message is duplicated or came after newer one
+// first message tracked in room is never reported as gap
+func (s *SequenceTracker) Track(msg *Message) (gap uint64, inOrder bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := trackKey(msg)
+	last, ok := s.last[key]
+	if !ok {
+		s.last[key] = msg.Seq
+		return 0, true
+	}
+	if msg.Seq <= last {
+		return 0, false
+	}
+
+	s.last[key] = msg.Seq
+	return msg.Seq - last - 1, true
+}
+
+// trackKey returns name of sequence message belongs to,
+// direct messages are numbered per sender, in each direction separately
+func trackKey(msg *Message) string {
+	if msg.To != "" {
+		return "@" + msg.User
+	}
+	return msg.Room
+}
+
+// Forget drops state of room, eg. after exiting it
+func (s *SequenceTracker) Forget(room string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.last, room)
+}
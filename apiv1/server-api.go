@@ -1,23 +1,36 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/phob0s-pl/perfchat/chat"
 )
 
-// Stats are superduper stats
+// Stats are superduper stats, fields are updated atomically
 type Stats struct {
-	AddedUsers       uint `json:"added_users"`
-	AddedRooms       uint `json:"added_rooms"`
-	DeletedRooms     uint `json:"deleted_rooms"`
-	DeletedUsers     uint `json:"deleted_users"`
-	MessagesReceived uint `json:"messages_received"`
-	BytesTotal       uint `json:"bytes_total"`
+	AddedUsers       uint64 `json:"added_users"`
+	AddedRooms       uint64 `json:"added_rooms"`
+	DeletedRooms     uint64 `json:"deleted_rooms"`
+	DeletedUsers     uint64 `json:"deleted_users"`
+	MessagesReceived uint64 `json:"messages_received"`
+	BytesTotal       uint64 `json:"bytes_total"`
+	RateLimited      uint64 `json:"rate_limited"`
+	MessagesDropped  uint64 `json:"messages_dropped"`
+	SlowDisconnects  uint64 `json:"slow_disconnects"`
+	ClusterDropped   uint64 `json:"cluster_events_dropped"`
 }
 
 type Route struct {
@@ -30,28 +43,67 @@ type Route struct {
 
 type API struct {
 	stats            Stats
+	metrics          *Metrics
 	Engine           *chat.Chat
 	upgrader         *websocket.Upgrader
 	websocketClients map[string]*websocketClient
-	message          chan *Message
-	msgBuffer        map[string]chan *Message
+	wsLock           sync.RWMutex
+	broker           Broker
+	history          *history
+	unread           *unread
+	sessions         *sessions
+	limiter          *limiter
+	cluster          *Cluster
+	events           *eventLogs
+	// maxPollWait caps how long poll waits for messages
+	maxPollWait time.Duration
+	// pollsDone is closed on shutdown to answer waiting polls
+	pollsDone chan struct{}
+	pollsOnce sync.Once
 }
 
 func NewAPI() *API {
-	return &API{
-		Engine: chat.NewChat(),
+	return NewAPIWithEngine(chat.NewChat())
+}
+
+// NewAPIWithEngine returns API serving given chat engine
+func NewAPIWithEngine(engine *chat.Chat) *API {
+	a := &API{
+		Engine: engine,
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024 * 1024,
 			WriteBufferSize: 1024 * 1024,
 		},
 		websocketClients: make(map[string]*websocketClient),
-		message:          make(chan *Message),
-		msgBuffer:        make(map[string]chan *Message),
-	}
+		history:          newHistory(),
+		unread:           newUnread(),
+		sessions:         newSessions(),
+		limiter:          newLimiter(),
+		metrics:          NewMetrics(),
+		events:           newEventLogs(),
+		maxPollWait:      defaultMaxPollWait,
+		pollsDone:        make(chan struct{}),
+	}
+	a.SetBroker(NewMemoryBroker(a.overflowed))
+	a.Engine.SetObserver(a.events)
+	return a
 }
 
 // getRequestingUser returns requesting user
+// session token is used when given, otherwise basic auth,
+// user authenticated earlier during request is reused
 func (a *API) getRequestingUser(r *http.Request) (*chat.User, error) {
+	if auth, ok := r.Context().Value(authenticationKey{}).(*authentication); ok {
+		return auth.user, auth.err
+	}
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		claims, err := a.sessions.claims(token)
+		if err != nil {
+			return nil, err
+		}
+		return a.Engine.GetUserByName(claims.User)
+	}
+
 	id, token, _ := r.BasicAuth()
 	user, err := a.Engine.GetUserByAuth(id, token)
 	if err != nil {
@@ -60,22 +112,59 @@ func (a *API) getRequestingUser(r *http.Request) (*chat.User, error) {
 	return user, nil
 }
 
+// claimedUser returns name of user request claims to be, or empty
+// one, it is not authenticated, so it is cheap but not trusted
+func (a *API) claimedUser(r *http.Request) string {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		claims, err := a.sessions.claims(token)
+		if err != nil {
+			return ""
+		}
+		return claims.User
+	}
+	id, _, _ := r.BasicAuth()
+	name, _ := a.Engine.ClaimedUser(id)
+	return name
+}
+
+// authenticationKey keys authentication done earlier during request
+type authenticationKey struct{}
+
+// authentication is result of authenticating request
+type authentication struct {
+	user *chat.User
+	err  error
+}
+
+// authenticate returns requesting user and request carrying him,
+// so handlers don't check token and password again
+func (a *API) authenticate(r *http.Request) (*http.Request, *chat.User) {
+	user, err := a.getRequestingUser(r)
+	ctx := context.WithValue(r.Context(), authenticationKey{}, &authentication{user: user, err: err})
+	return r.WithContext(ctx), user
+}
+
 // GetUsers returns all users in chat
 func (a *API) GetUsers(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	if _, ok := a.isUser(w, r); !ok {
+	if _, ok := a.authorize(w, r, chat.ListPermission); !ok {
 		return
 	}
 
 	var users []User
 	engineUsers := a.Engine.ListUsers()
 	for _, engineUser := range engineUsers {
-		users = append(users, User{Name: engineUser.Name, Role: engineUser.Role})
+		users = append(users, User{
+			Name:        engineUser.Name,
+			Role:        engineUser.Role,
+			DisplayName: engineUser.DisplayName,
+			Suspended:   engineUser.Suspended,
+		})
 	}
 
 	payload, err := json.Marshal(users)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrInternal)
 		return
 	}
 	w.Write(payload)
@@ -89,18 +178,18 @@ func (a *API) AddUser(w http.ResponseWriter, r *http.Request) {
 		user = &User{}
 	)
 
-	if _, ok := a.isUser(w, r); !ok {
+	if _, ok := a.authorize(w, r, chat.AddUserPermission); !ok {
 		return
 	}
 
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
 	if err := json.Unmarshal(content, user); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
@@ -111,20 +200,153 @@ func (a *API) AddUser(w http.ResponseWriter, r *http.Request) {
 		Token:  user.Token,
 	}
 
-	if err := a.Engine.AddUser(engineUser); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if _, err := a.changeUser(&userChange{Kind: addUserChange, Name: user.Name, User: engineUser}); err != nil {
+		writeError(w, err)
 		return
 	}
-	if err := a.Engine.AddRoom(&chat.Room{
-		Name:    user.Name,
-		Creator: user.Name,
-		Users:   []*chat.User{engineUser},
-	}); err != nil {
-		_ = a.Engine.JoinRoom(user.Name, user.Name)
+	atomic.AddUint64(&a.stats.AddedUsers, 1)
+}
+
+// DeleteUser deletes user with his personal room and memberships,
+// his websocket connection and event streams are closed and pending
+// messages dropped
+// Only admin can delete user
+func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	name, ok := a.managedUser(w, r, chat.DeleteUserPermission)
+	if !ok {
+		return
+	}
+
+	if _, err := a.changeUser(&userChange{Kind: deleteUserChange, Name: name}); err != nil {
+		writeError(w, err)
+		return
+	}
+	atomic.AddUint64(&a.stats.DeletedUsers, 1)
+}
+
+// forgetUser drops history, sessions and subscriptions of deleted user
+func (a *API) forgetUser(name string) {
+	a.history.remove(name)
+	a.history.removeDirect(name)
+	a.unread.remove(name)
+	a.sessions.closeUser(name)
+	a.closeWebsocketClient(name)
+	a.events.remove(name)
+	a.broker.UnsubscribeUser(name)
+}
+
+// UpdateUser changes role, token or display name of user
+// Only admin can update user
+func (a *API) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	name, ok := a.managedUser(w, r, chat.UpdateUserPermission)
+	if !ok {
+		return
 	}
 
-	a.msgBuffer[user.Name] = make(chan *Message, 256)
-	a.stats.AddedUsers++
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+
+	update := &UserUpdate{}
+	if err := json.Unmarshal(content, update); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	if update.RotateToken {
+		if update.Token, err = newToken(); err != nil {
+			writeError(w, ErrInternal)
+			return
+		}
+	}
+
+	user, err := a.changeUser(&userChange{Kind: updateUserChange, Name: name, Update: &chat.UserUpdate{
+		Role:        update.Role,
+		Token:       update.Token,
+		DisplayName: update.DisplayName,
+	}})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// only new token is returned, chat keeps its hash
+	payload, err := json.Marshal(&User{
+		Name:        user.Name,
+		Role:        user.Role,
+		AuthID:      user.AuthID,
+		Token:       update.Token,
+		DisplayName: user.DisplayName,
+		Suspended:   user.Suspended,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Write(payload)
+}
+
+// SuspendUser suspends user and closes his websocket connection and event streams
+// Only admin can suspend user
+func (a *API) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	a.setSuspended(w, r, true)
+}
+
+// ResumeUser resumes suspended user
+// Only admin can resume user
+func (a *API) ResumeUser(w http.ResponseWriter, r *http.Request) {
+	a.setSuspended(w, r, false)
+}
+
+func (a *API) setSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	defer r.Body.Close()
+
+	name, ok := a.managedUser(w, r, chat.SuspendUserPermission)
+	if !ok {
+		return
+	}
+
+	kind := resumeUserChange
+	if suspended {
+		kind = suspendUserChange
+	}
+	if _, err := a.changeUser(&userChange{Kind: kind, Name: name}); err != nil {
+		writeError(w, err)
+	}
+}
+
+// managedUser returns name of user managed by request
+// if requesting user lacks permission or can't manage him
+// sets error status and returns false
+func (a *API) managedUser(w http.ResponseWriter, r *http.Request, permission chat.Permission) (string, bool) {
+	user, ok := a.authorize(w, r, permission)
+	if !ok {
+		return "", false
+	}
+	target, err := a.Engine.GetUserByName(mux.Vars(r)["name"])
+	if err != nil {
+		writeError(w, err)
+		return "", false
+	}
+	if !user.CanManage(target) {
+		writeError(w, ErrNotPermit)
+		return "", false
+	}
+	return target.Name, true
+}
+
+// newToken returns random token
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
 }
 
 // Ping respongs wint 200 to ping message
@@ -135,26 +357,34 @@ func (a *API) Ping(w http.ResponseWriter, r *http.Request) {
 // GetRooms returns list of rooms in chat
 func (a *API) GetRooms(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	if _, ok := a.isUser(w, r); !ok {
+	user, ok := a.authorize(w, r, chat.ListPermission)
+	if !ok {
 		return
 	}
 
 	var rooms []Room
-	engineRooms := a.Engine.ListRooms()
+	engineRooms := a.Engine.ListVisibleRooms(user)
 	for _, engineRoom := range engineRooms {
 		var userlist []string
 		for _, userInRoom := range engineRoom.Users {
 			userlist = append(userlist, userInRoom.Name)
 		}
+		var moderators []string
+		for name := range engineRoom.Moderators {
+			moderators = append(moderators, name)
+		}
+		sort.Strings(moderators)
 		rooms = append(rooms, Room{
-			Name:    engineRoom.Name,
-			Creator: engineRoom.Creator,
-			Users:   userlist})
+			Name:       engineRoom.Name,
+			Creator:    engineRoom.Creator,
+			Users:      userlist,
+			Moderators: moderators,
+			Access:     engineRoom.Access})
 	}
 
 	payload, err := json.Marshal(rooms)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrInternal)
 		return
 	}
 	w.Write(payload)
@@ -164,85 +394,133 @@ func (a *API) GetRooms(w http.ResponseWriter, r *http.Request) {
 func (a *API) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.CreateRoomPermission)
 	if !ok {
 		return
 	}
 
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
 	room := &Room{}
 	if err := json.Unmarshal(content, room); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
-	if err := a.Engine.AddRoom(&chat.Room{
-		Creator: user.Name,
-		Name:    room.Name,
-		Users:   []*chat.User{user},
+	if !chat.ValidName(room.Name) {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	// personal rooms are created only with users
+	if room.Access == chat.PersonalAccess {
+		writeError(w, ErrNotPermit)
+		return
+	}
+	if err := a.changeRoom(&roomChange{
+		Kind:     createRoomChange,
+		Actor:    user.Name,
+		Room:     room.Name,
+		Access:   room.Access,
+		Password: room.Password,
 	}); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
+		return
 	}
-	a.stats.AddedRooms++
+	atomic.AddUint64(&a.stats.AddedRooms, 1)
 }
 
 // RoomDelete deletes room from server
-// note: user must be owner of a room
+// note: user must be owner of a room or moderator
 func (a *API) RoomDelete(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.DeleteRoomPermission)
 	if !ok {
 		return
 	}
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
 	room := &Room{}
 	if err := json.Unmarshal(content, room); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
-	if err := a.Engine.DeleteRoom(user.Name, room.Name); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := a.changeRoom(&roomChange{Kind: deleteRoomChange, Actor: user.Name, Room: room.Name}); err != nil {
+		writeError(w, err)
 		return
 	}
-	a.stats.DeletedRooms++
+	atomic.AddUint64(&a.stats.DeletedRooms, 1)
 }
 
 // RoomJoin joins user to room
 func (a *API) RoomJoin(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.JoinRoomPermission)
 	if !ok {
 		return
 	}
 
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
 	room := &Room{}
 	if err := json.Unmarshal(content, room); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
-	if err := a.Engine.JoinRoom(user.Name, room.Name); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := a.changeRoom(&roomChange{Kind: joinRoomChange, Actor: user.Name, Room: room.Name, Password: room.Password}); err != nil {
+		writeError(w, err)
+	}
+}
+
+// RoomInvite lets user join invite-only or password protected room,
+// note: only room owner and moderators can invite
+func (a *API) RoomInvite(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, inviteUserChange)
+}
+
+// RoomAccept joins user to room he was invited to
+func (a *API) RoomAccept(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.JoinRoomPermission)
+	if !ok {
+		return
+	}
+
+	if err := a.changeRoom(&roomChange{Kind: acceptInviteChange, Actor: user.Name, Room: mux.Vars(r)["name"]}); err != nil {
+		writeError(w, err)
+	}
+}
+
+// GetInvites lists rooms user was invited to
+func (a *API) GetInvites(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.JoinRoomPermission)
+	if !ok {
 		return
 	}
+
+	payload, err := json.Marshal(a.Engine.Invites(user.Name))
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
 }
 
 // RoomExit exits user to room
@@ -250,112 +528,353 @@ func (a *API) RoomJoin(w http.ResponseWriter, r *http.Request) {
 func (a *API) RoomExit(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.JoinRoomPermission)
 	if !ok {
 		return
 	}
 
 	content, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
 	room := &Room{}
 	if err := json.Unmarshal(content, room); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 
-	if err := a.Engine.ExitRoom(user.Name, room.Name); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := a.changeRoom(&roomChange{Kind: exitRoomChange, Actor: user.Name, Room: room.Name}); err != nil {
+		writeError(w, err)
 		return
 	}
 }
 
+// RoomKick removes user from room
+// note: user must be room owner or moderator
+func (a *API) RoomKick(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, kickUserChange)
+}
+
+// RoomBan removes user from room and prevents him from joining
+// note: user must be room owner or moderator
+func (a *API) RoomBan(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, banUserChange)
+}
+
+// RoomUnban lets banned user join room again
+// note: user must be room owner or moderator
+func (a *API) RoomUnban(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, unbanUserChange)
+}
+
+// RoomMute prevents user from posting to room for duration
+// note: user must be room owner or moderator
+func (a *API) RoomMute(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, muteUserChange)
+}
+
+// RoomPromote makes room member moderator
+// note: user must be room owner
+func (a *API) RoomPromote(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, promoteUserChange)
+}
+
+// RoomDemote makes room moderator regular member
+// note: user must be room owner
+func (a *API) RoomDemote(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, demoteUserChange)
+}
+
+// RoomTransfer makes room member owner of room
+// note: user must be room owner
+func (a *API) RoomTransfer(w http.ResponseWriter, r *http.Request) {
+	a.moderateRoom(w, r, transferRoomChange)
+}
+
+// moderateRoom reads moderation action and performs it on room from path
+func (a *API) moderateRoom(w http.ResponseWriter, r *http.Request, kind string) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.ModerateRoomPermission)
+	if !ok {
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+
+	action := &RoomAction{}
+	if err := json.Unmarshal(content, action); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	if action.User == "" {
+		writeError(w, ErrMissingArg)
+		return
+	}
+
+	if err := a.changeRoom(&roomChange{
+		Kind:     kind,
+		Actor:    user.Name,
+		Room:     mux.Vars(r)["name"],
+		Target:   action.User,
+		Duration: action.Duration,
+	}); err != nil {
+		writeError(w, err)
+	}
+}
+
 // ReceiveMessage receives message from client
 func (a *API) ReceiveMessage(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.ChatPermission)
 	if !ok {
 		return
 	}
 	content, err := ioutil.ReadAll(r.Body)
-	a.stats.BytesTotal += uint(len(content))
+	atomic.AddUint64(&a.stats.BytesTotal, uint64(len(content)))
 	if err != nil {
 		fmt.Println("asd")
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		return
 	}
 	msg := &Message{}
 	if err := json.Unmarshal(content, msg); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrBadRequest)
 		fmt.Println("asd2")
 		return
 	}
 	msg.User = user.Name
 
-	room, err := a.Engine.GetRoomByName(msg.Room)
+	stored, err := a.dispatchMessage(msg)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
+	atomic.AddUint64(&a.stats.MessagesReceived, 1)
 
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
+
+// dispatchMessage checks if message can be posted, stamps it and delivers
+// it to all users in room, both polling and websocket ones
+// returns message as stored by server
+func (a *API) dispatchMessage(msg *Message) (*Message, error) {
+	if a.cluster != nil && !a.cluster.owns(msg.Room) {
+		return a.cluster.forward(msg.Room, msg)
+	}
+	return a.postMessage(msg)
+}
+
+// postMessage dispatches message of room owned by this node,
+// other cluster nodes deliver it to their subscribers
+func (a *API) postMessage(msg *Message) (*Message, error) {
+	if _, err := a.Engine.GetUserByName(msg.User); err != nil {
+		return nil, err
+	}
+
+	room, err := a.Engine.GetRoomByName(msg.Room)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Engine.CheckPost(msg.User, msg.Room); err != nil {
+		return nil, err
+	}
+
+	stored, duplicate := a.history.add(msg)
+	if duplicate {
+		return stored, nil
+	}
+
+	a.metrics.messageDispatched(len(room.Users))
+	names := make([]string, 0, len(room.Users))
 	for _, roomUser := range room.Users {
-		c, ok := a.msgBuffer[roomUser.Name]
-		if !ok {
-			continue
-		}
-		if len(c) < cap(c) {
-			c <- msg
-		}
+		names = append(names, roomUser.Name)
 	}
-	a.stats.MessagesReceived++
+	a.publish(msg, names)
+	a.replicate(&clusterEvent{Kind: deliverEvent, Key: msg.Room, Message: msg, Recipients: names})
+	return msg, nil
 }
 
-// SendMessage sends messages to client
+// SendMessage sends messages to client, messages are queued for user
+// since his first poll, so users of push transports don't fill the queue
+// query parameters: wait - time in ms to wait for first message when
+// there is none, capped by SetMaxPollWait, max - number of returned
+// messages, the rest stays queued
 func (a *API) SendMessage(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.ChatPermission)
 	if !ok {
 		return
 	}
+	query := r.URL.Query()
+	wait, err := parseUintParam(query.Get("wait"), 0)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	max, err := parseUintParam(query.Get("max"), 0)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
 
-	channel, ok := a.msgBuffer[user.Name]
+	// subscription is opened by first poll, subscription closed
+	// as slow consumer is opened again and its messages are lost
+	sub, ok := a.broker.Subscription(user.Name, PollTransport)
+	if !ok {
+		sub = a.broker.Subscribe(user.Name, PollTransport)
+	}
+	msgs, ok := a.waitMessages(r.Context(), sub, time.Duration(wait)*time.Millisecond, int(max))
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	msgs := unchannelMsg(channel)
-
 	payload, err := json.Marshal(msgs)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrInternal)
 		return
 	}
 	w.Write(payload)
 }
 
-func unchannelMsg(channel chan *Message) (msgs []Message) {
-	for {
-		select {
-		case msg := <-channel:
-			msgs = append(msgs, *msg)
-		default:
-			return
+// waitMessages returns up to max queued messages of subscription, zero
+// max returns all, if none is queued it waits up to wait for the first
+// one, ok is false when client is gone and nothing was taken from queue
+func (a *API) waitMessages(ctx context.Context, sub *Subscription, wait time.Duration, max int) (msgs []Message, ok bool) {
+	if wait > a.maxPollWait {
+		wait = a.maxPollWait
+	}
+	if wait <= 0 || sub.Len() > 0 {
+		return sub.DrainMax(max), true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case msg := <-sub.Messages():
+		msgs = []Message{*msg}
+		if max == 1 {
+			return msgs, true
+		}
+		if max > 1 {
+			max--
 		}
+		return append(msgs, sub.DrainMax(max)...), true
+	case <-sub.Closed():
+		return sub.DrainMax(max), true
+	case <-timer.C:
+		return nil, true
+	case <-a.pollsDone:
+		return sub.DrainMax(max), true
+	case <-ctx.Done():
+		return nil, false
 	}
 }
 
-// Checks if request was done by user
-// if not sets StatusUnauthorized on response and returns false
-func (a *API) isUser(w http.ResponseWriter, r *http.Request) (*chat.User, bool) {
+// ClosePolls answers polls waiting for messages, so server can shut
+// down without waiting for them, later polls don't wait
+func (a *API) ClosePolls() {
+	a.pollsOnce.Do(func() { close(a.pollsDone) })
+}
+
+// SetMaxPollWait caps how long poll waits for messages, it must be
+// shorter than write timeout of server so response can be written
+func (a *API) SetMaxPollWait(max time.Duration) {
+	a.maxPollWait = max
+}
+
+// RoomMessages returns page of room message history
+// note: user must be in room
+func (a *API) RoomMessages(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	user, ok := a.authorize(w, r, chat.ChatPermission)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	room, err := a.Engine.GetRoomByName(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !room.HasUser(user.Name) {
+		writeError(w, ErrNotPermit)
+		return
+	}
+
+	a.writePage(w, r, name)
+}
+
+// writePage writes page of history with name selected by query parameters
+func (a *API) writePage(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	before, err := parseUintParam(query.Get("before"), 0)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	after, err := parseUintParam(query.Get("after"), 0)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	limit, err := parseUintParam(query.Get("limit"), defaultPageLimit)
+	if err != nil || limit == 0 {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	payload, err := json.Marshal(a.history.page(name, before, after, int(limit)))
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
+
+// parseUintParam parses query parameter, empty value gives def
+func parseUintParam(value string, def uint64) (uint64, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// authorize checks if request was done by user with permission,
+// it is the only authorization check of handlers, Ping, Stats
+// and Metrics are public
+// if user is unknown sets StatusUnauthorized on response and returns false,
+// suspended user and user without permission get StatusForbidden
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, permission chat.Permission) (*chat.User, bool) {
 	user, err := a.getRequestingUser(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		writeError(w, ErrUnauthorized)
+		return nil, false
+	}
+	if user.Suspended {
+		writeError(w, ErrSuspended)
+		return nil, false
+	}
+	if !user.Can(permission) {
+		writeError(w, ErrNotPermit)
 		return nil, false
 	}
 	return user, true
@@ -364,32 +883,52 @@ func (a *API) isUser(w http.ResponseWriter, r *http.Request) (*chat.User, bool)
 // Websocket handles messages from clients
 func (a *API) Websocket(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	user, ok := a.isUser(w, r)
+	user, ok := a.authorize(w, r, chat.ChatPermission)
 	if !ok {
 		return
 	}
+	// on failure upgrader already replied with error
 	conn, err := a.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	client := &websocketClient{
-		conn: conn,
-		msg:  make(chan *Message, 256),
-		name: user.Name,
-	}
+	client := newWebsocketClient(conn, user.Name, a.broker.Subscribe(user.Name, WebsocketTransport))
 
-	a.websocketClients[user.Name] = client
-	a.writeClientMessage(client)
+	a.addWebsocketClient(client)
+	go a.writeClientMessage(client)
 	a.readClientMessage(client)
 }
 
 func (a *API) Stats(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	payload, err := json.Marshal(a.stats)
+	payload, err := json.Marshal(a.StatsSnapshot())
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, ErrInternal)
 		return
 	}
 	w.Write(payload)
 }
+
+// PendingMessages returns number of messages waiting for polling users
+func (a *API) PendingMessages() (pending int) {
+	for _, sub := range a.broker.Subscriptions(PollTransport) {
+		pending += sub.Len()
+	}
+	return pending
+}
+
+// StatsSnapshot returns copy of stats safe to read
+func (a *API) StatsSnapshot() Stats {
+	return Stats{
+		AddedUsers:       atomic.LoadUint64(&a.stats.AddedUsers),
+		AddedRooms:       atomic.LoadUint64(&a.stats.AddedRooms),
+		DeletedRooms:     atomic.LoadUint64(&a.stats.DeletedRooms),
+		DeletedUsers:     atomic.LoadUint64(&a.stats.DeletedUsers),
+		MessagesReceived: atomic.LoadUint64(&a.stats.MessagesReceived),
+		BytesTotal:       atomic.LoadUint64(&a.stats.BytesTotal),
+		RateLimited:      atomic.LoadUint64(&a.stats.RateLimited),
+		MessagesDropped:  atomic.LoadUint64(&a.stats.MessagesDropped),
+		SlowDisconnects:  atomic.LoadUint64(&a.stats.SlowDisconnects),
+		ClusterDropped:   atomic.LoadUint64(&a.stats.ClusterDropped),
+	}
+}
@@ -8,7 +8,47 @@ func (a *API) AddUserRoute() *Route {
 		Method:      http.MethodPost,
 		Name:        "AddUser",
 		Pattern:     GetPath(UsersCall),
-		WithPrefix:  true,
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) DeleteUserRoute() *Route {
+	return &Route{
+		HandlerFunc: a.DeleteUser,
+		Method:      http.MethodDelete,
+		Name:        "DeleteUser",
+		Pattern:     GetPath(UserCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) UpdateUserRoute() *Route {
+	return &Route{
+		HandlerFunc: a.UpdateUser,
+		Method:      http.MethodPatch,
+		Name:        "UpdateUser",
+		Pattern:     GetPath(UserCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) SuspendUserRoute() *Route {
+	return &Route{
+		HandlerFunc: a.SuspendUser,
+		Method:      http.MethodPost,
+		Name:        "SuspendUser",
+		Pattern:     GetPath(UserSuspendCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ResumeUserRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ResumeUser,
+		Method:      http.MethodPost,
+		Name:        "ResumeUser",
+		Pattern:     GetPath(UserResumeCall),
+		WithPrefix:  false,
 	}
 }
 
@@ -52,6 +92,16 @@ func (a *API) CreateRoomRoute() *Route {
 	}
 }
 
+func (a *API) DeleteRoomRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomDelete,
+		Method:      http.MethodPost,
+		Name:        "DeleteRoom",
+		Pattern:     GetPath(RoomsDeleteCall),
+		WithPrefix:  false,
+	}
+}
+
 func (a *API) JoinRoomRoute() *Route {
 	return &Route{
 		HandlerFunc: a.RoomJoin,
@@ -72,6 +122,16 @@ func (a *API) ExitRoomRoute() *Route {
 	}
 }
 
+func (a *API) RoomMessagesRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomMessages,
+		Method:      http.MethodGet,
+		Name:        "RoomMessages",
+		Pattern:     GetPath(RoomsMessagesCall),
+		WithPrefix:  false,
+	}
+}
+
 func (a *API) SendMessageRoute() *Route {
 	return &Route{
 		HandlerFunc: a.SendMessage,
@@ -101,3 +161,233 @@ func (a *API) StatsRoute() *Route {
 		WithPrefix:  false,
 	}
 }
+
+func (a *API) WebsocketRoute() *Route {
+	return &Route{
+		HandlerFunc: a.Websocket,
+		Method:      http.MethodGet,
+		Name:        "Websocket",
+		Pattern:     GetPath(WsPath),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) MetricsRoute() *Route {
+	return &Route{
+		HandlerFunc: a.Metrics,
+		Method:      http.MethodGet,
+		Name:        "Metrics",
+		Pattern:     MetricsPath,
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomKickRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomKick,
+		Method:      http.MethodPost,
+		Name:        "RoomKick",
+		Pattern:     GetPath(RoomsKickCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomBanRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomBan,
+		Method:      http.MethodPost,
+		Name:        "RoomBan",
+		Pattern:     GetPath(RoomsBanCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomUnbanRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomUnban,
+		Method:      http.MethodPost,
+		Name:        "RoomUnban",
+		Pattern:     GetPath(RoomsUnbanCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomMuteRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomMute,
+		Method:      http.MethodPost,
+		Name:        "RoomMute",
+		Pattern:     GetPath(RoomsMuteCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomPromoteRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomPromote,
+		Method:      http.MethodPost,
+		Name:        "RoomPromote",
+		Pattern:     GetPath(RoomsPromoteCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomDemoteRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomDemote,
+		Method:      http.MethodPost,
+		Name:        "RoomDemote",
+		Pattern:     GetPath(RoomsDemoteCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomTransferRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomTransfer,
+		Method:      http.MethodPost,
+		Name:        "RoomTransfer",
+		Pattern:     GetPath(RoomsTransferCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomInviteRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomInvite,
+		Method:      http.MethodPost,
+		Name:        "RoomInvite",
+		Pattern:     GetPath(RoomsInviteCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) RoomAcceptRoute() *Route {
+	return &Route{
+		HandlerFunc: a.RoomAccept,
+		Method:      http.MethodPost,
+		Name:        "RoomAccept",
+		Pattern:     GetPath(RoomsAcceptCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) GetInvitesRoute() *Route {
+	return &Route{
+		HandlerFunc: a.GetInvites,
+		Method:      http.MethodGet,
+		Name:        "GetInvites",
+		Pattern:     GetPath(InvitesCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ReceiveDirectRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ReceiveDirect,
+		Method:      http.MethodPost,
+		Name:        "ReceiveDirect",
+		Pattern:     GetPath(DirectUserCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) DirectMessagesRoute() *Route {
+	return &Route{
+		HandlerFunc: a.DirectMessages,
+		Method:      http.MethodGet,
+		Name:        "DirectMessages",
+		Pattern:     GetPath(DirectMessagesCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) GetUnreadRoute() *Route {
+	return &Route{
+		HandlerFunc: a.GetUnread,
+		Method:      http.MethodGet,
+		Name:        "GetUnread",
+		Pattern:     GetPath(DirectCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) LoginRoute() *Route {
+	return &Route{
+		HandlerFunc: a.Login,
+		Method:      http.MethodPost,
+		Name:        "Login",
+		Pattern:     GetPath(LoginCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) LogoutRoute() *Route {
+	return &Route{
+		HandlerFunc: a.Logout,
+		Method:      http.MethodPost,
+		Name:        "Logout",
+		Pattern:     GetPath(LogoutCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ClusterEventsRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ClusterEvents,
+		Method:      http.MethodPost,
+		Name:        "ClusterEvents",
+		Pattern:     GetPath(ClusterEventsCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ClusterPostRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ClusterPost,
+		Method:      http.MethodPost,
+		Name:        "ClusterPost",
+		Pattern:     GetPath(ClusterPostCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ClusterRoomRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ClusterRoom,
+		Method:      http.MethodPost,
+		Name:        "ClusterRoom",
+		Pattern:     GetPath(ClusterRoomCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ClusterUserRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ClusterUser,
+		Method:      http.MethodPost,
+		Name:        "ClusterUser",
+		Pattern:     GetPath(ClusterUserCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) ClusterStateRoute() *Route {
+	return &Route{
+		HandlerFunc: a.ClusterState,
+		Method:      http.MethodGet,
+		Name:        "ClusterState",
+		Pattern:     GetPath(ClusterStateCall),
+		WithPrefix:  false,
+	}
+}
+
+func (a *API) EventsRoute() *Route {
+	return &Route{
+		HandlerFunc: a.Events,
+		Method:      http.MethodGet,
+		Name:        "Events",
+		Pattern:     GetPath(EventsCall),
+		WithPrefix:  false,
+	}
+}
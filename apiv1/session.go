@@ -0,0 +1,251 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSessionTTL is how long session token is valid
+	defaultSessionTTL = 24 * time.Hour
+	// sessionSecretSize is number of bytes of generated secret
+	sessionSecretSize = 32
+)
+
+// ErrInvalidSession is returned for forged, expired or revoked session token
+var ErrInvalidSession = errors.New("invalid session")
+
+// sessionClaims are signed part of session token
+type sessionClaims struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Expires int64  `json:"exp"`
+}
+
+// sessions issues session tokens signed with server secret
+// and keeps them until logout or expiry
+type sessions struct {
+	sync.Mutex
+	secret []byte
+	ttl    time.Duration
+	// active maps session ID to its claims
+	active map[string]*sessionClaims
+}
+
+func newSessions() *sessions {
+	secret := make([]byte, sessionSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &sessions{
+		secret: secret,
+		ttl:    defaultSessionTTL,
+		active: make(map[string]*sessionClaims),
+	}
+}
+
+func (s *sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// open starts session of user with name and returns its token
+func (s *sessions) open(name string) (string, time.Time, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	s.dropExpired(now)
+
+	expires := now.Add(s.ttl)
+	claims := &sessionClaims{
+		ID:      hex.EncodeToString(id),
+		User:    name,
+		Expires: expires.Unix(),
+	}
+	content, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(content)
+	s.active[claims.ID] = claims
+	return payload + "." + s.sign(payload), expires, nil
+}
+
+// claims verifies token and returns its claims if session is active
+func (s *sessions) claims(token string) (*sessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidSession
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return nil, ErrInvalidSession
+	}
+	content, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	claims := &sessionClaims{}
+	if err := json.Unmarshal(content, claims); err != nil {
+		return nil, ErrInvalidSession
+	}
+
+	active, ok := s.active[claims.ID]
+	if !ok || active.User != claims.User || time.Now().Unix() >= active.Expires {
+		return nil, ErrInvalidSession
+	}
+	return active, nil
+}
+
+// close revokes session with token and returns its claims
+func (s *sessions) close(token string) (*sessionClaims, error) {
+	claims, err := s.claims(token)
+	if err != nil {
+		return nil, err
+	}
+	s.revoke(claims.ID)
+	return claims, nil
+}
+
+// closeUser revokes all sessions of user with name
+func (s *sessions) closeUser(name string) {
+	s.Lock()
+	defer s.Unlock()
+	for id, claims := range s.active {
+		if claims.User == name {
+			delete(s.active, id)
+		}
+	}
+}
+
+// add keeps session opened on other cluster node
+func (s *sessions) add(claims *sessionClaims) {
+	s.Lock()
+	defer s.Unlock()
+	s.active[claims.ID] = claims
+}
+
+// revoke forgets session with ID
+func (s *sessions) revoke(id string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.active, id)
+}
+
+// list returns active sessions
+func (s *sessions) list() (list []*sessionClaims) {
+	s.Lock()
+	defer s.Unlock()
+	s.dropExpired(time.Now())
+	for _, claims := range s.active {
+		list = append(list, claims)
+	}
+	return list
+}
+
+// dropExpired forgets sessions which are over, sessions must be locked
+func (s *sessions) dropExpired(now time.Time) {
+	for id, claims := range s.active {
+		if now.Unix() >= claims.Expires {
+			delete(s.active, id)
+		}
+	}
+}
+
+// SetSessions sets secret signing session tokens and their lifetime,
+// empty secret keeps random one generated at start, zero ttl keeps default
+func (a *API) SetSessions(secret string, ttl time.Duration) {
+	a.sessions.Lock()
+	defer a.sessions.Unlock()
+	if secret != "" {
+		a.sessions.secret = []byte(secret)
+	}
+	if ttl > 0 {
+		a.sessions.ttl = ttl
+	}
+}
+
+// bearerToken returns session token from Authorization header
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "Bearer "
+	if !strings.HasPrefix(authorization, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(authorization, prefix), true
+}
+
+// Login exchanges AuthID and token of user for session token
+func (a *API) Login(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	credentials := &User{}
+	if err := json.Unmarshal(content, credentials); err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+
+	user, err := a.Engine.GetUserByAuth(credentials.AuthID, credentials.Token)
+	if err != nil {
+		writeError(w, ErrUnauthorized)
+		return
+	}
+	if user.Suspended {
+		writeError(w, ErrSuspended)
+		return
+	}
+
+	token, expires, err := a.sessions.open(user.Name)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	if claims, err := a.sessions.claims(token); err == nil {
+		a.replicate(&clusterEvent{Kind: sessionOpenedEvent, Session: claims})
+	}
+	payload, err := json.Marshal(&Session{Token: token, Expires: expires})
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	w.Write(payload)
+}
+
+// Logout revokes session token used for request
+func (a *API) Logout(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		writeError(w, ErrMissingArg)
+		return
+	}
+	claims, err := a.sessions.close(token)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	a.replicate(&clusterEvent{Kind: sessionClosedEvent, Session: claims})
+}
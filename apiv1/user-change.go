@@ -0,0 +1,99 @@
+package api
+
+import (
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// Kinds of user changes
+const (
+	addUserChange     = "add"
+	deleteUserChange  = "delete"
+	updateUserChange  = "update"
+	suspendUserChange = "suspend"
+	resumeUserChange  = "resume"
+)
+
+// userChange is change of user with Name, in cluster it is made by
+// node owning user, so changes of user are made in single order
+type userChange struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// User is added user with his token
+	User *chat.User `json:"user,omitempty"`
+	// Update of changed user
+	Update *chat.UserUpdate `json:"update,omitempty"`
+}
+
+// changeUser makes change on this node or on node owning user and
+// returns copy of changed user, nil when he was deleted. Change made
+// by other node is awaited, so next request here finds it
+func (a *API) changeUser(change *userChange) (*chat.User, error) {
+	if a.cluster == nil || a.cluster.owns(change.Name) {
+		return a.applyUserChange(change)
+	}
+	changed, err := a.cluster.forwardUserChange(change)
+	if err != nil {
+		return nil, err
+	}
+	await(func() bool {
+		user, err := a.Engine.GetUserByName(change.Name)
+		if changed == nil {
+			return err != nil
+		}
+		return err == nil && user.Version >= changed.Version
+	})
+	return changed, nil
+}
+
+// applyUserChange makes change of user owned by this node
+func (a *API) applyUserChange(change *userChange) (*chat.User, error) {
+	name := change.Name
+	switch change.Kind {
+	case addUserChange:
+		if change.User == nil || change.User.Name != name {
+			return nil, ErrBadRequest
+		}
+		if err := a.Engine.AddUser(change.User); err != nil {
+			return nil, err
+		}
+		// personal room has the same owner as user
+		if err := a.changeRoom(&roomChange{
+			Kind:   createRoomChange,
+			Actor:  name,
+			Room:   name,
+			Access: chat.PersonalAccess,
+		}); err != nil {
+			_ = a.changeRoom(&roomChange{Kind: joinRoomChange, Actor: name, Room: name})
+		}
+	case deleteUserChange:
+		if err := a.Engine.DeleteUser(name); err != nil {
+			return nil, err
+		}
+		a.forgetUser(name)
+		return nil, nil
+	case updateUserChange:
+		if change.Update == nil {
+			return nil, ErrBadRequest
+		}
+		if _, err := a.Engine.UpdateUser(name, change.Update); err != nil {
+			return nil, err
+		}
+		if change.Update.Token != "" {
+			a.sessions.closeUser(name)
+			a.replicate(&clusterEvent{Kind: userSessionsClosedEvent, Name: name})
+		}
+	case suspendUserChange, resumeUserChange:
+		suspended := change.Kind == suspendUserChange
+		if err := a.Engine.SuspendUser(name, suspended); err != nil {
+			return nil, err
+		}
+		if suspended {
+			a.sessions.closeUser(name)
+			a.closeWebsocketClient(name)
+			a.events.remove(name)
+		}
+	default:
+		return nil, ErrBadRequest
+	}
+	return a.Engine.GetUserByName(name)
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"encoding/json"
@@ -17,39 +20,109 @@ const (
 
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
+
+	// Number of error frames waiting for writer, more are dropped.
+	clientErrorsBuffer = 16
 )
 
+// websocketError is frame telling client that its message was rejected
+type websocketError struct {
+	ClientID string `json:"client_id,omitempty"`
+	Error    *Error `json:"error"`
+}
+
 type websocketClient struct {
 	conn *websocket.Conn
-	msg  chan *Message
+	sub  *Subscription
 	name string
+	// errors are frames of rejected messages written by writer
+	errors chan *websocketError
+	// done is closed when reading from connection stops
+	done chan struct{}
+	// stop is closed on server shutdown, writer flushes pending
+	// messages, sends close frame and closes stopped
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
 }
 
-// StartWebsocket starts receiving messages from websocket
-func (a *API) StartWebsocket() {
-	go func() {
-		for {
-			select {
-			case msg := <-a.message:
-				_, err := a.Engine.GetUserByName(msg.User)
-				if err != nil {
-					continue
-				}
-				room, err := a.Engine.GetRoomByName(msg.Room)
-				if err != nil {
-					continue
-				}
+func newWebsocketClient(conn *websocket.Conn, name string, sub *Subscription) *websocketClient {
+	return &websocketClient{
+		conn:    conn,
+		sub:     sub,
+		name:    name,
+		errors:  make(chan *websocketError, clientErrorsBuffer),
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
 
-				for _, roomUser := range room.Users {
-					ws, ok := a.websocketClients[roomUser.Name]
-					if !ok {
-						continue
-					}
-					ws.msg <- msg
-				}
-			} // <- really nice bracketception xDDD
-		}
-	}()
+// postWebsocket posts message read from connection of client, it is
+// done by reader of connection, so post waiting for other cluster node
+// holds back only next posts of the same client
+func (a *API) postWebsocket(client *websocketClient, msg *Message) {
+	dispatch := a.dispatchMessage
+	if msg.To != "" {
+		dispatch = a.dispatchDirect
+	}
+	if _, err := dispatch(msg); err != nil {
+		client.reject(msg, err)
+		return
+	}
+	atomic.AddUint64(&a.stats.MessagesReceived, 1)
+}
+
+// reject queues error frame for message which could not be posted,
+// it is dropped when client does not keep up
+func (c *websocketClient) reject(msg *Message, err error) {
+	select {
+	case c.errors <- &websocketError{ClientID: msg.ClientID, Error: toError(err)}:
+	default:
+	}
+}
+
+// addWebsocketClient registers client, connection of the same user
+// which was registered before is closed
+func (a *API) addWebsocketClient(client *websocketClient) {
+	a.wsLock.Lock()
+	old, ok := a.websocketClients[client.name]
+	a.websocketClients[client.name] = client
+	a.wsLock.Unlock()
+
+	if ok {
+		old.conn.Close()
+	}
+}
+
+// removeWebsocketClient unregisters client if it is still the current
+// connection of the user
+func (a *API) removeWebsocketClient(client *websocketClient) {
+	a.wsLock.Lock()
+	defer a.wsLock.Unlock()
+	if current, ok := a.websocketClients[client.name]; ok && current == client {
+		delete(a.websocketClients, client.name)
+	}
+}
+
+// closeWebsocketClient unregisters and closes connection of user with name
+func (a *API) closeWebsocketClient(name string) {
+	a.wsLock.Lock()
+	client, ok := a.websocketClients[name]
+	delete(a.websocketClients, name)
+	a.wsLock.Unlock()
+
+	if ok {
+		client.conn.Close()
+	}
+}
+
+// getWebsocketClient returns websocket client of user with name
+func (a *API) getWebsocketClient(name string) (*websocketClient, bool) {
+	a.wsLock.RLock()
+	defer a.wsLock.RUnlock()
+	client, ok := a.websocketClients[name]
+	return client, ok
 }
 
 func (a *API) writeClientMessage(client *websocketClient) {
@@ -57,43 +130,95 @@ func (a *API) writeClientMessage(client *websocketClient) {
 	defer func() {
 		ticker.Stop()
 		client.conn.Close()
+		close(client.stopped)
 	}()
 
 	for {
 		select {
-		case message, ok := <-client.msg:
-			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				client.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case message := <-client.sub.Messages():
+			if err := writeWebsocketMessage(client.conn, message); err != nil {
 				return
 			}
-
-			w, err := client.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+		case rejected := <-client.errors:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.conn.WriteJSON(rejected); err != nil {
 				return
 			}
-
-			payload, err := json.Marshal(message)
-			if err == nil {
-				w.Write(payload)
-			}
-			if err := w.Close(); err != nil {
+		case <-client.sub.Closed():
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if client.sub.Overflowed() {
+				client.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"))
 				return
 			}
+			client.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case <-client.stop:
+			for _, message := range client.sub.Drain() {
+				if err := writeWebsocketMessage(client.conn, &message); err != nil {
+					return
+				}
+			}
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			client.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"))
+			return
 		case <-ticker.C:
 			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-client.done:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			client.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		}
 	}
 
 }
 
+func writeWebsocketMessage(conn *websocket.Conn, message *Message) error {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	w, err := conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(message)
+	if err == nil {
+		w.Write(payload)
+	}
+	return w.Close()
+}
+
+// CloseWebsockets flushes messages pending for websocket clients,
+// sends them close frames and waits until they are closed or ctx is done
+func (a *API) CloseWebsockets(ctx context.Context) error {
+	a.wsLock.RLock()
+	clients := make([]*websocketClient, 0, len(a.websocketClients))
+	for _, client := range a.websocketClients {
+		clients = append(clients, client)
+	}
+	a.wsLock.RUnlock()
+
+	for _, client := range clients {
+		client.stopOnce.Do(func() { close(client.stop) })
+	}
+	for _, client := range clients {
+		select {
+		case <-client.stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 func (a *API) readClientMessage(client *websocketClient) {
 	defer func() {
-		delete(a.websocketClients, client.name)
-		client.conn.Close()
+		a.removeWebsocketClient(client)
+		a.broker.Unsubscribe(client.sub)
+		close(client.done)
 	}()
 	client.conn.SetReadDeadline(time.Now().Add(pongWait))
 	client.conn.SetPongHandler(func(string) error { client.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
@@ -103,8 +228,12 @@ func (a *API) readClientMessage(client *websocketClient) {
 			break
 		}
 		msg := &Message{}
-		if err := json.Unmarshal(message, msg); err == nil {
-			a.message <- msg
+		if err := json.Unmarshal(message, msg); err != nil {
+			client.reject(msg, ErrBadRequest)
+			continue
 		}
+		// sender is always the authenticated owner of connection
+		msg.User = client.name
+		a.postWebsocket(client, msg)
 	}
 }
@@ -2,7 +2,11 @@ package chat
 
 import (
 	"errors"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 )
 
 var (
@@ -16,6 +20,12 @@ var (
 	ErrMissingArg = errors.New("missing argument")
 	// ErrNotPermit is returned when operation is not permited
 	ErrNotPermit = errors.New("not permited")
+	// ErrBanned is returned when banned user joins room
+	ErrBanned = errors.New("banned")
+	// ErrMuted is returned when muted user posts to room
+	ErrMuted = errors.New("muted")
+	// ErrInvalidName is returned when name of user or room is not valid
+	ErrInvalidName = errors.New("invalid name")
 )
 
 const (
@@ -25,199 +35,616 @@ const (
 )
 
 // Chat is main chat engine
+// Users are never changed in place, updates replace them with changed
+// copies, so users returned by chat may be read without locks.
+// Membership and moderation state of every room is guarded by
+// its own lock and chat returns only snapshots of rooms.
+// Locks are taken in order: rooms, room, users.
 type Chat struct {
-	roomLimit  uint
+	usersLock  sync.RWMutex
 	usersLimit uint
+	users      map[string]*User
+	// byAuthID indexes users by AuthID
+	byAuthID map[string]*User
+
+	roomsLock sync.RWMutex
+	roomLimit uint
+	rooms     map[string]*lockedRoom
+
+	store      Store
+	replicator Replicator
+	observer   Observer
+}
+
+// lockedRoom is room with lock guarding it,
+// deleted is set when room is removed from chat
+type lockedRoom struct {
 	sync.Mutex
-	users map[string]*User
-	rooms map[string]*Room
+	room    *Room
+	deleted bool
 }
 
 // SetRoomLimit sets room limit to non default value
 func (c *Chat) SetRoomLimit(limit uint) {
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
 	c.roomLimit = limit
 }
 
 // SetUsersLimit sets users limit to non default value
 func (c *Chat) SetUsersLimit(limit uint) {
+	c.usersLock.Lock()
+	defer c.usersLock.Unlock()
 	c.usersLimit = limit
 }
 
-// NewChat returns new chat
+// NewChat returns new chat kept in memory
 func NewChat() *Chat {
+	c, _ := NewChatWithStore(NewMemoryStore())
+	return c
+}
+
+// NewChatWithStore returns chat which persists its state in store,
+// users and rooms already present in store are restored
+func NewChatWithStore(store Store) (*Chat, error) {
 	c := &Chat{
 		users:      make(map[string]*User),
-		rooms:      make(map[string]*Room),
+		byAuthID:   make(map[string]*User),
+		rooms:      make(map[string]*lockedRoom),
 		roomLimit:  defaultRoomsLimit,
 		usersLimit: defaultUsersLimit,
+		store:      store,
 	}
-	c.rooms[mainRoom] = &Room{Name: mainRoom}
-	return c
+	c.rooms[mainRoom] = &lockedRoom{room: &Room{Name: mainRoom}}
+
+	users, err := store.Users()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		// tokens stored before hashing was introduced are hashed now
+		if user.Token != "" {
+			if err := hashToken(user); err != nil {
+				return nil, err
+			}
+			if err := store.PutUser(user); err != nil {
+				return nil, err
+			}
+		}
+		c.users[user.Name] = user
+		if user.AuthID != "" {
+			c.byAuthID[user.AuthID] = user
+		}
+	}
+
+	records, err := store.Rooms()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		room := roomFromRecord(record, func(name string) *User { return c.users[name] })
+		c.rooms[room.Name] = &lockedRoom{room: room}
+	}
+	return c, nil
 }
 
-// AddUser adds user to chat
+// roomFromRecord returns room described by record, users are
+// looked up by name, unknown ones are left out
+func roomFromRecord(record *RoomRecord, lookup func(name string) *User) *Room {
+	room := &Room{
+		Name:     record.Name,
+		Creator:  record.Creator,
+		Access:   record.Access,
+		Password: record.Password,
+	}
+	for _, name := range record.Users {
+		if user := lookup(name); user != nil {
+			room.Users = append(room.Users, user)
+		}
+	}
+	for _, name := range record.Moderators {
+		room.setModerator(name, true)
+	}
+	for _, name := range record.Banned {
+		room.ban(&User{Name: name})
+	}
+	for _, name := range record.Invites {
+		room.invite(name)
+	}
+	for name, until := range record.Muted {
+		room.mute(name, until)
+	}
+	room.dropExpiredMutes(time.Now())
+	return room
+}
+
+// Close closes chat store
+func (c *Chat) Close() error {
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+	c.usersLock.Lock()
+	defer c.usersLock.Unlock()
+	return c.store.Close()
+}
+
+// ValidName checks that name of user or room has no control characters,
+// they are reserved for names of direct conversations
+func ValidName(name string) bool {
+	return strings.IndexFunc(name, unicode.IsControl) < 0
+}
+
+// AddUser adds copy of user to chat, token of user is kept hashed
 func (c *Chat) AddUser(user *User) error {
-	c.Lock()
-	defer c.Unlock()
+	if !ValidName(user.Name) {
+		return ErrInvalidName
+	}
+	added := *user
+	added.Version = 1
+	// hashing is done without lock, so it doesn't block other users
+	if err := hashToken(&added); err != nil {
+		return err
+	}
 
-	if uint(c.UsersCount()) >= c.usersLimit {
+	c.usersLock.Lock()
+	defer c.usersLock.Unlock()
+
+	if uint(len(c.users)) >= c.usersLimit {
 		return ErrNoResources
 	}
 
-	if _, ok := c.users[user.Name]; ok {
+	if _, ok := c.users[added.Name]; ok {
+		return ErrExists
+	}
+	if _, ok := c.byAuthID[added.AuthID]; ok && added.AuthID != "" {
 		return ErrExists
 	}
 
-	c.users[user.Name] = user
+	if err := c.persistUser(&added); err != nil {
+		return err
+	}
+	c.users[added.Name] = &added
+	if added.AuthID != "" {
+		c.byAuthID[added.AuthID] = &added
+	}
 	return nil
 }
 
-// AddRoom adds user to chat
-func (c *Chat) AddRoom(room *Room) error {
-	c.Lock()
-	defer c.Unlock()
+// hashToken replaces token of user with its hash
+func hashToken(user *User) error {
+	if user.Token == "" {
+		return nil
+	}
+	password, err := NewPassword(user.Token)
+	if err != nil {
+		return err
+	}
+	user.Password = password
+	user.Token = ""
+	return nil
+}
+
+// user returns user with name, it must not be changed
+func (c *Chat) user(name string) (*User, bool) {
+	c.usersLock.RLock()
+	defer c.usersLock.RUnlock()
+	user, ok := c.users[name]
+	return user, ok
+}
+
+// room returns locked room with name, callers must check
+// if room was deleted after locking it
+func (c *Chat) room(name string) (*lockedRoom, bool) {
+	c.roomsLock.RLock()
+	defer c.roomsLock.RUnlock()
+	room, ok := c.rooms[name]
+	return room, ok
+}
+
+// withRoom calls change with room with name locked
+func (c *Chat) withRoom(name string, change func(room *Room) error) error {
+	locked, ok := c.room(name)
+	if !ok {
+		return ErrNotFound
+	}
+	locked.Lock()
+	defer locked.Unlock()
+	if locked.deleted {
+		return ErrNotFound
+	}
+	return change(locked.room)
+}
 
+// AddRoom adds copy of room to chat
+func (c *Chat) AddRoom(room *Room) error {
 	if len(room.Users) != 1 || room.Name == "" || room.Creator == "" {
 		return ErrMissingArg
 	}
+	if !validAccess(room) {
+		return ErrMissingArg
+	}
+	if !ValidName(room.Name) {
+		return ErrInvalidName
+	}
+
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
 
 	if _, ok := c.rooms[room.Name]; ok {
 		return ErrExists
 	}
 
-	c.rooms[room.Name] = room
+	added := room.snapshot()
+	for i, member := range added.Users {
+		if user, ok := c.user(member.Name); ok {
+			added.Users[i] = user
+		} else {
+			copied := *member
+			added.Users[i] = &copied
+		}
+	}
+	if err := c.persistRoom(NewRoomRecord(added)); err != nil {
+		return err
+	}
+	c.rooms[added.Name] = &lockedRoom{room: added}
+	c.notifyMembers(added, nil)
 	return nil
 }
 
 // JoinRoom joins user with name to room
 func (c *Chat) JoinRoom(username, roomname string) error {
-	c.Lock()
-	defer c.Unlock()
+	return c.JoinRoomWithPassword(username, roomname, "")
+}
 
-	user, err := c.GetUserByName(username)
-	if err != nil {
-		return err
-	}
+// JoinRoomWithPassword joins user with name to room if access mode
+// of room lets him, password is needed only for protected rooms
+func (c *Chat) JoinRoomWithPassword(username, roomname, password string) error {
+	return c.join(username, roomname, func(room *Room, user *User) error {
+		return room.CanJoin(user, password)
+	})
+}
 
-	room, err := c.GetRoomByName(roomname)
-	if err != nil {
-		return err
+// AcceptInvite joins user with name to room he was invited to
+func (c *Chat) AcceptInvite(username, roomname string) error {
+	return c.join(username, roomname, func(room *Room, user *User) error {
+		if !room.Invites[user.Name] {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// join joins user with name to room if check passes,
+// invite of user is used up
+func (c *Chat) join(username, roomname string, check func(room *Room, user *User) error) error {
+	if _, ok := c.user(username); !ok {
+		return ErrNotFound
 	}
+	return c.changeRoom(roomname, func(room *Room) error {
+		// user is looked up again under room lock, so user deleted
+		// in the meantime is not left in room
+		user, ok := c.user(username)
+		if !ok {
+			return ErrNotFound
+		}
+		if err := check(room, user); err != nil {
+			return err
+		}
+		if err := room.Join(user); err != nil {
+			return err
+		}
+		delete(room.Invites, username)
+		return c.persistRoom(NewRoomRecord(room))
+	})
+}
 
-	return room.Join(user)
+// Invites returns names of rooms user with name is invited to
+func (c *Chat) Invites(username string) (rooms []string) {
+	for _, room := range c.snapshots(func(room *Room) bool { return room.Invites[username] }) {
+		rooms = append(rooms, room.Name)
+	}
+	sort.Strings(rooms)
+	return rooms
 }
 
 // ExitRoom removes user with name from room
 func (c *Chat) ExitRoom(username, roomname string) error {
-	c.Lock()
-	defer c.Unlock()
-
-	user, err := c.GetUserByName(username)
-	if err != nil {
-		return err
-	}
-
-	room, err := c.GetRoomByName(roomname)
-	if err != nil {
-		return err
+	user, ok := c.user(username)
+	if !ok {
+		return ErrNotFound
 	}
-
-	return room.Exit(user)
+	return c.changeRoom(roomname, func(room *Room) error {
+		if err := room.Exit(user); err != nil {
+			return err
+		}
+		return c.persistRoom(NewRoomRecord(room))
+	})
 }
 
 // RoomExists checks if room exists
 func (c *Chat) RoomExists(roomname string) bool {
-	c.Lock()
-	defer c.Unlock()
-	_, ok := c.rooms[roomname]
+	_, ok := c.room(roomname)
 	return ok
 }
 
 // UserExists checks if user with name exists
 func (c *Chat) UserExists(username string) bool {
-	c.Lock()
-	defer c.Unlock()
-	_, ok := c.users[username]
+	_, ok := c.user(username)
 	return ok
 }
 
-// GetUserByName returns user by his name
+// GetUserByName returns copy of user by his name
 func (c *Chat) GetUserByName(name string) (*User, error) {
-	c.Lock()
-	defer c.Unlock()
-	user, ok := c.users[name]
+	user, ok := c.user(name)
 	if !ok {
 		return nil, ErrNotFound
 	}
-	return user, nil
+	copied := *user
+	return &copied, nil
 }
 
-// GetRoomByName returns room by its name
-func (c *Chat) GetRoomByName(name string) (*Room, error) {
-	c.Lock()
-	defer c.Unlock()
-	room, ok := c.rooms[name]
+// ClaimedUser returns name of user with auth id, token is not
+// checked, so user must not be trusted
+func (c *Chat) ClaimedUser(id string) (string, bool) {
+	c.usersLock.RLock()
+	defer c.usersLock.RUnlock()
+	user, ok := c.byAuthID[id]
 	if !ok {
-		return nil, ErrNotFound
+		return "", false
 	}
-	return room, nil
+	return user.Name, true
+}
+
+// GetRoomByName returns snapshot of room by its name
+func (c *Chat) GetRoomByName(name string) (room *Room, err error) {
+	err = c.withRoom(name, func(r *Room) error {
+		room = r.snapshot()
+		return nil
+	})
+	return room, err
 }
 
-// ListUsers returns all users
+// ListUsers returns copies of all users
 func (c *Chat) ListUsers() (u []*User) {
-	c.Lock()
-	defer c.Unlock()
+	c.usersLock.RLock()
+	defer c.usersLock.RUnlock()
 	for _, user := range c.users {
-		u = append(u, user)
+		copied := *user
+		u = append(u, &copied)
 	}
 	return u
 }
 
-// ListRooms returns all rooms
-func (c *Chat) ListRooms() (r []*Room) {
-	c.Lock()
-	defer c.Unlock()
-	for _, room := range c.rooms {
-		r = append(r, room)
+// ListRooms returns snapshots of all rooms
+func (c *Chat) ListRooms() []*Room {
+	return c.snapshots(func(*Room) bool { return true })
+}
+
+// ListVisibleRooms returns snapshots of rooms user can see
+func (c *Chat) ListVisibleRooms(user *User) []*Room {
+	return c.snapshots(func(room *Room) bool { return room.VisibleTo(user) })
+}
+
+// snapshots returns snapshots of rooms matching filter,
+// every room is locked only while it is filtered and copied
+func (c *Chat) snapshots(filter func(room *Room) bool) (r []*Room) {
+	c.roomsLock.RLock()
+	defer c.roomsLock.RUnlock()
+	for _, locked := range c.rooms {
+		locked.Lock()
+		if filter(locked.room) {
+			r = append(r, locked.room.snapshot())
+		}
+		locked.Unlock()
 	}
 	return r
 }
 
-// GetUserByAuth returns user by auth parameters
+// GetUserByAuth returns copy of user by auth parameters,
+// token is compared in constant time
 func (c *Chat) GetUserByAuth(id, token string) (*User, error) {
-	for _, user := range c.users {
-		if user.AuthID == id && user.Token == token {
-			return user, nil
-		}
+	c.usersLock.RLock()
+	user, ok := c.byAuthID[id]
+	password := dummyPassword()
+	if ok {
+		password = user.Password
+	}
+	c.usersLock.RUnlock()
+
+	// hashing is done without lock, so it doesn't block other users
+	if !password.Matches(token) || !ok {
+		return nil, ErrNotFound
 	}
-	return nil, ErrNotFound
+	copied := *user
+	return &copied, nil
 }
 
 // UsersCount returns number of users in chat
 func (c *Chat) UsersCount() int {
+	c.usersLock.RLock()
+	defer c.usersLock.RUnlock()
 	return len(c.users)
 }
 
 // RoomsCount returns number of rooms in chat
 func (c *Chat) RoomsCount() int {
+	c.roomsLock.RLock()
+	defer c.roomsLock.RUnlock()
 	return len(c.rooms)
 }
 
 // DeleteRoom deletes room from chat if exists
-// and username is room creator
+// and username is room creator or may delete any room,
+// main room and personal rooms of other users are never deleted
 func (c *Chat) DeleteRoom(username, roomname string) error {
-	c.Lock()
-	defer c.Unlock()
+	user, ok := c.user(username)
+	if !ok {
+		return ErrNotFound
+	}
 
-	room, ok := c.rooms[roomname]
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+
+	locked, ok := c.rooms[roomname]
 	if !ok {
 		return ErrNotFound
 	}
+	locked.Lock()
+	defer locked.Unlock()
 
+	room := locked.room
 	if room.Creator != username {
-		return ErrNotPermit
+		personal := room.Name == room.Creator
+		if roomname == mainRoom || personal || !user.Can(DeleteAnyRoomPermission) {
+			return ErrNotPermit
+		}
 	}
 
+	if err := c.persistRoomDeleted(roomname); err != nil {
+		return err
+	}
 	delete(c.rooms, roomname)
+	locked.deleted = true
+	c.notifyDeleted(room)
+	return nil
+}
+
+// DeleteUser deletes user with name, removes him from all rooms
+// and deletes his personal room
+func (c *Chat) DeleteUser(username string) error {
+	c.usersLock.Lock()
+	user, ok := c.users[username]
+	if !ok {
+		c.usersLock.Unlock()
+		return ErrNotFound
+	}
+	if err := c.persistUserDeleted(username); err != nil {
+		c.usersLock.Unlock()
+		return err
+	}
+	delete(c.users, username)
+	delete(c.byAuthID, user.AuthID)
+	c.usersLock.Unlock()
+
+	// user is already gone, so he can't join rooms
+	// which were already swept
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+	for name, locked := range c.rooms {
+		if err := c.removeMember(name, locked, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeMember removes deleted user from room,
+// personal room of user is deleted
+func (c *Chat) removeMember(name string, locked *lockedRoom, user *User) error {
+	locked.Lock()
+	defer locked.Unlock()
+
+	if name == user.Name && locked.room.Creator == user.Name {
+		if err := c.persistRoomDeleted(name); err != nil {
+			return err
+		}
+		delete(c.rooms, name)
+		locked.deleted = true
+		c.notifyDeleted(locked.room)
+		return nil
+	}
+	before := memberNames(locked.room)
+	if locked.room.Exit(user) == nil {
+		if err := c.persistRoom(NewRoomRecord(locked.room)); err != nil {
+			return err
+		}
+		c.notifyMembers(locked.room, before)
+	}
 	return nil
 }
+
+// UpdateUser changes role, token or display name of user with name
+// and returns copy of updated user
+func (c *Chat) UpdateUser(username string, update *UserUpdate) (*User, error) {
+	if update.Role != "" && !validRole(update.Role) {
+		return nil, ErrMissingArg
+	}
+	var password *Password
+	if update.Token != "" {
+		hashed := &User{Token: update.Token}
+		if err := hashToken(hashed); err != nil {
+			return nil, err
+		}
+		password = hashed.Password
+	}
+
+	updated, err := c.replaceUser(username, func(user *User) {
+		if update.Role != "" {
+			user.Role = update.Role
+		}
+		if password != nil {
+			user.Password = password
+		}
+		if update.DisplayName != "" {
+			user.DisplayName = update.DisplayName
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	copied := *updated
+	return &copied, nil
+}
+
+// SuspendUser suspends or resumes user with name
+func (c *Chat) SuspendUser(username string, suspended bool) error {
+	_, err := c.replaceUser(username, func(user *User) {
+		user.Suspended = suspended
+	})
+	return err
+}
+
+// replaceUser stores changed copy of user with name in place of him,
+// rooms get the new copy too
+func (c *Chat) replaceUser(username string, change func(user *User)) (*User, error) {
+	c.usersLock.Lock()
+	user, ok := c.users[username]
+	if !ok {
+		c.usersLock.Unlock()
+		return nil, ErrNotFound
+	}
+	updated := *user
+	change(&updated)
+	updated.Version++
+	if err := c.persistUser(&updated); err != nil {
+		c.usersLock.Unlock()
+		return nil, err
+	}
+	c.users[username] = &updated
+	if updated.AuthID != "" {
+		c.byAuthID[updated.AuthID] = &updated
+	}
+	c.usersLock.Unlock()
+
+	c.refreshMember(username)
+	return &updated, nil
+}
+
+// refreshMember replaces user with name in rooms with his current copy
+func (c *Chat) refreshMember(username string) {
+	c.roomsLock.RLock()
+	defer c.roomsLock.RUnlock()
+	for _, locked := range c.rooms {
+		locked.Lock()
+		for i, member := range locked.room.Users {
+			if member.Name != username {
+				continue
+			}
+			// current copy is read under room lock, so concurrent
+			// updates leave room with the latest one
+			if user, ok := c.user(username); ok {
+				locked.room.Users[i] = user
+			}
+		}
+		locked.Unlock()
+	}
+}
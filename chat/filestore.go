@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "log.jsonl"
+
+	// defaultCompactAfter is number of log entries after which
+	// snapshot is written and log truncated
+	defaultCompactAfter = 10000
+)
+
+const (
+	opPutUser    = "put_user"
+	opDeleteUser = "delete_user"
+	opPutRoom    = "put_room"
+	opDeleteRoom = "delete_room"
+)
+
+// logEntry is single change appended to log
+type logEntry struct {
+	Op   string      `json:"op"`
+	User *User       `json:"user,omitempty"`
+	Room *RoomRecord `json:"room,omitempty"`
+	Name string      `json:"name,omitempty"`
+}
+
+// snapshot is full state of store
+type snapshot struct {
+	Users []*User       `json:"users"`
+	Rooms []*RoomRecord `json:"rooms"`
+}
+
+// FileStore keeps chat state in directory on disk
+// Every change is appended to log, log is compacted into
+// snapshot when it grows above limit
+type FileStore struct {
+	sync.Mutex
+	dir          string
+	state        *MemoryStore
+	log          *os.File
+	writer       *bufio.Writer
+	entries      uint
+	compactAfter uint
+}
+
+// NewFileStore opens store in dir, creating it if needed,
+// and restores state from snapshot and log
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		dir:          dir,
+		state:        NewMemoryStore(),
+		compactAfter: defaultCompactAfter,
+	}
+
+	if err := f.readSnapshot(); err != nil {
+		return nil, fmt.Errorf("snapshot: %s", err)
+	}
+	if err := f.replayLog(); err != nil {
+		return nil, fmt.Errorf("log: %s", err)
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.log = log
+	f.writer = bufio.NewWriter(log)
+
+	// start with fresh log, this also drops entry torn by crash
+	if err := f.compact(); err != nil {
+		log.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetCompactAfter sets number of log entries after which log is compacted
+func (f *FileStore) SetCompactAfter(entries uint) {
+	f.Lock()
+	defer f.Unlock()
+	if entries > 0 {
+		f.compactAfter = entries
+	}
+}
+
+func (f *FileStore) readSnapshot() error {
+	content, err := ioutil.ReadFile(filepath.Join(f.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snap := &snapshot{}
+	if err := json.Unmarshal(content, snap); err != nil {
+		return err
+	}
+	for _, user := range snap.Users {
+		f.state.PutUser(user)
+	}
+	for _, room := range snap.Rooms {
+		f.state.PutRoom(room)
+	}
+	return nil
+}
+
+func (f *FileStore) replayLog() error {
+	log, err := os.Open(filepath.Join(f.dir, logFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	decoder := json.NewDecoder(log)
+	for {
+		entry := &logEntry{}
+		err := decoder.Decode(entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// entry torn by crash while writing, everything before is valid
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		f.apply(entry)
+		f.entries++
+	}
+}
+
+func (f *FileStore) apply(entry *logEntry) {
+	switch entry.Op {
+	case opPutUser:
+		f.state.PutUser(entry.User)
+	case opDeleteUser:
+		f.state.DeleteUser(entry.Name)
+	case opPutRoom:
+		f.state.PutRoom(entry.Room)
+	case opDeleteRoom:
+		f.state.DeleteRoom(entry.Name)
+	}
+}
+
+// append writes entry to log and applies it to state
+func (f *FileStore) append(entry *logEntry) error {
+	f.Lock()
+	defer f.Unlock()
+
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.writer.Write(append(payload, '\n')); err != nil {
+		return err
+	}
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+
+	f.apply(entry)
+	f.entries++
+	if f.entries >= f.compactAfter {
+		return f.compact()
+	}
+	return nil
+}
+
+// compact writes current state to snapshot and truncates log
+func (f *FileStore) compact() error {
+	users, _ := f.state.Users()
+	rooms, _ := f.state.Rooms()
+	payload, err := json.Marshal(&snapshot{Users: users, Rooms: rooms})
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(f.dir, snapshotFile+".tmp")
+	if err := ioutil.WriteFile(tmp, payload, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(f.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	if err := f.log.Truncate(0); err != nil {
+		return err
+	}
+	f.writer.Reset(f.log)
+	f.entries = 0
+	return nil
+}
+
+// PutUser adds or replaces user
+func (f *FileStore) PutUser(user *User) error {
+	return f.append(&logEntry{Op: opPutUser, User: user})
+}
+
+// DeleteUser removes user with name
+func (f *FileStore) DeleteUser(name string) error {
+	return f.append(&logEntry{Op: opDeleteUser, Name: name})
+}
+
+// PutRoom adds or replaces room
+func (f *FileStore) PutRoom(room *RoomRecord) error {
+	return f.append(&logEntry{Op: opPutRoom, Room: room})
+}
+
+// DeleteRoom removes room with name
+func (f *FileStore) DeleteRoom(name string) error {
+	return f.append(&logEntry{Op: opDeleteRoom, Name: name})
+}
+
+// Users returns all stored users
+func (f *FileStore) Users() ([]*User, error) {
+	return f.state.Users()
+}
+
+// Rooms returns all stored rooms
+func (f *FileStore) Rooms() ([]*RoomRecord, error) {
+	return f.state.Rooms()
+}
+
+// Close compacts log and closes files
+func (f *FileStore) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if err := f.compact(); err != nil {
+		return err
+	}
+	if err := f.log.Sync(); err != nil {
+		return err
+	}
+	return f.log.Close()
+}
@@ -0,0 +1,144 @@
+package chat
+
+import "time"
+
+// roomRank returns rank of user in room,
+// users which may moderate any room outrank room owner
+func roomRank(room *Room, user *User) int {
+	if user.Can(ModerateAnyRoomPermission) {
+		return roomRoleRank[RoomOwner] + 1
+	}
+	return roomRoleRank[room.RoleOf(user.Name)]
+}
+
+// moderate changes target in room if actor has at least minRole
+// and outranks target, room is stored after change
+func (c *Chat) moderate(actorname, roomname, targetname, minRole string, change func(room *Room, target *User) error) error {
+	actor, ok := c.user(actorname)
+	if !ok {
+		return ErrNotFound
+	}
+	target, ok := c.user(targetname)
+	if !ok {
+		return ErrNotFound
+	}
+
+	return c.changeRoom(roomname, func(room *Room) error {
+		rank := roomRank(room, actor)
+		if rank < roomRoleRank[minRole] || rank <= roomRank(room, target) {
+			return ErrNotPermit
+		}
+
+		if err := change(room, target); err != nil {
+			return err
+		}
+		return c.persistRoom(NewRoomRecord(room))
+	})
+}
+
+// KickUser removes target from room, he can join again
+func (c *Chat) KickUser(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomModerator, func(room *Room, target *User) error {
+		room.setModerator(target.Name, false)
+		return room.Exit(target)
+	})
+}
+
+// BanUser removes target from room and prevents him from joining again
+func (c *Chat) BanUser(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomModerator, func(room *Room, target *User) error {
+		room.ban(target)
+		return nil
+	})
+}
+
+// UnbanUser lets banned target join room again
+func (c *Chat) UnbanUser(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomModerator, func(room *Room, target *User) error {
+		if !room.Banned[target.Name] {
+			return ErrNotFound
+		}
+		delete(room.Banned, target.Name)
+		return nil
+	})
+}
+
+// MuteUser prevents target from posting to room for duration,
+// zero duration unmutes target
+func (c *Chat) MuteUser(actorname, roomname, targetname string, duration time.Duration) error {
+	return c.moderate(actorname, roomname, targetname, RoomModerator, func(room *Room, target *User) error {
+		now := time.Now()
+		room.dropExpiredMutes(now)
+		if duration <= 0 {
+			room.mute(target.Name, time.Time{})
+			return nil
+		}
+		room.mute(target.Name, now.Add(duration))
+		return nil
+	})
+}
+
+// PromoteModerator makes target member moderator of room
+func (c *Chat) PromoteModerator(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomOwner, func(room *Room, target *User) error {
+		if !room.HasUser(target.Name) {
+			return ErrNotFound
+		}
+		room.setModerator(target.Name, true)
+		return nil
+	})
+}
+
+// DemoteModerator makes target moderator regular member of room
+func (c *Chat) DemoteModerator(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomOwner, func(room *Room, target *User) error {
+		if !room.Moderators[target.Name] {
+			return ErrNotFound
+		}
+		room.setModerator(target.Name, false)
+		return nil
+	})
+}
+
+// TransferOwnership makes target member owner of room,
+// previous owner stays in room as moderator
+// personal rooms can't be transferred
+func (c *Chat) TransferOwnership(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomOwner, func(room *Room, target *User) error {
+		if room.Name == room.Creator || room.Name == mainRoom {
+			return ErrNotPermit
+		}
+		if !room.HasUser(target.Name) {
+			return ErrNotFound
+		}
+		previous := room.Creator
+		room.Creator = target.Name
+		room.setModerator(target.Name, false)
+		room.setModerator(previous, true)
+		return nil
+	})
+}
+
+// InviteUser lets target join room even if it is invite-only
+// or password protected, invite is used up by joining
+func (c *Chat) InviteUser(actorname, roomname, targetname string) error {
+	return c.moderate(actorname, roomname, targetname, RoomModerator, func(room *Room, target *User) error {
+		switch {
+		case room.Access == PersonalAccess:
+			return ErrNotPermit
+		case room.Banned[target.Name]:
+			return ErrBanned
+		case room.HasUser(target.Name):
+			return ErrExists
+		}
+		room.invite(target.Name)
+		return nil
+	})
+}
+
+// CheckPost checks if user with name may post to room
+func (c *Chat) CheckPost(username, roomname string) error {
+	return c.withRoom(roomname, func(room *Room) error {
+		return room.CanPost(username, time.Now())
+	})
+}
@@ -0,0 +1,77 @@
+package chat
+
+// Observer is told about changes of room membership, both made
+// on this node and applied from other nodes of cluster.
+// It is called with room locked, so it must not block.
+type Observer interface {
+	// MembersChanged reports users which joined and left room,
+	// members are users in room after change
+	MembersChanged(room string, joined, left, members []string)
+	// RoomDeleted reports deleted room with its last members
+	RoomDeleted(room string, members []string)
+}
+
+// SetObserver sets observer of membership changes,
+// it should be set before chat is used
+func (c *Chat) SetObserver(observer Observer) {
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+	c.observer = observer
+}
+
+// changeRoom calls change with room with name locked
+// and reports membership changes made by it
+func (c *Chat) changeRoom(name string, change func(room *Room) error) error {
+	return c.withRoom(name, func(room *Room) error {
+		before := memberNames(room)
+		if err := change(room); err != nil {
+			return err
+		}
+		c.notifyMembers(room, before)
+		return nil
+	})
+}
+
+// notifyMembers reports difference between members
+// before change and current members of room
+func (c *Chat) notifyMembers(room *Room, before []string) {
+	if c.observer == nil {
+		return
+	}
+	after := memberNames(room)
+	joined, left := missing(after, before), missing(before, after)
+	if len(joined) == 0 && len(left) == 0 {
+		return
+	}
+	c.observer.MembersChanged(room.Name, joined, left, after)
+}
+
+// notifyDeleted reports deleted room
+func (c *Chat) notifyDeleted(room *Room) {
+	if c.observer != nil {
+		c.observer.RoomDeleted(room.Name, memberNames(room))
+	}
+}
+
+// memberNames returns names of room members
+func memberNames(room *Room) []string {
+	names := make([]string, 0, len(room.Users))
+	for _, user := range room.Users {
+		names = append(names, user.Name)
+	}
+	return names
+}
+
+// missing returns names from list which are not in other
+func missing(list, other []string) (names []string) {
+	present := make(map[string]bool, len(other))
+	for _, name := range other {
+		present[name] = true
+	}
+	for _, name := range list {
+		if !present[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
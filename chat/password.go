@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+const (
+	// saltSize is number of random bytes added to password before hashing
+	saltSize = 16
+	// keySize is number of bytes of derived hash
+	keySize = sha256.Size
+)
+
+// PasswordIterations is cost of PBKDF2 used for new passwords, every
+// password keeps its own cost, so it can be changed at any time
+// secrets are hashed on every authenticated request, so higher cost
+// slows down all clients not using sessions
+var PasswordIterations = 100000
+
+// Password is salted hash of secret, secret itself is never stored
+type Password struct {
+	Salt string `json:"salt"`
+	Hash string `json:"hash"`
+	// Iterations of PBKDF2, zero marks plain salted SHA-256
+	// of passwords stored before it was used
+	Iterations int `json:"iterations,omitempty"`
+}
+
+// NewPassword returns salted hash of secret
+func NewPassword(secret string) (*Password, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	p := &Password{Salt: hex.EncodeToString(salt), Iterations: PasswordIterations}
+	hash, err := p.hash(secret)
+	if err != nil {
+		return nil, err
+	}
+	p.Hash = hex.EncodeToString(hash)
+	return p, nil
+}
+
+func (p *Password) hash(secret string) ([]byte, error) {
+	if p.Iterations == 0 {
+		sum := sha256.Sum256([]byte(p.Salt + secret))
+		return sum[:], nil
+	}
+	return pbkdf2.Key(sha256.New, secret, []byte(p.Salt), p.Iterations, keySize)
+}
+
+// dummyPassword is checked when user is not found,
+// so that missing and existing users take the same time
+func dummyPassword() *Password {
+	return &Password{Salt: "dummy", Hash: "dummy", Iterations: PasswordIterations}
+}
+
+// Matches checks if secret is the hashed one, nil password matches nothing
+func (p *Password) Matches(secret string) bool {
+	if p == nil {
+		return false
+	}
+	stored, _ := hex.DecodeString(p.Hash)
+	hash, err := p.hash(secret)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, stored) == 1
+}
@@ -0,0 +1,78 @@
+package chat
+
+// Permission is right to perform single operation
+type Permission string
+
+// Permissions checked before operations
+const (
+	// ListPermission allows listing users and rooms
+	ListPermission Permission = "list"
+	// ChatPermission allows sending and receiving messages
+	// and reading history of joined rooms
+	ChatPermission Permission = "chat"
+	// CreateRoomPermission allows creating rooms
+	CreateRoomPermission Permission = "create_room"
+	// JoinRoomPermission allows joining and exiting rooms
+	JoinRoomPermission Permission = "join_room"
+	// DeleteRoomPermission allows deleting rooms created by user
+	DeleteRoomPermission Permission = "delete_room"
+	// DeleteAnyRoomPermission allows deleting rooms created by others
+	DeleteAnyRoomPermission Permission = "delete_any_room"
+	// ModerateRoomPermission allows moderating rooms according to role in room
+	ModerateRoomPermission Permission = "moderate_room"
+	// ModerateAnyRoomPermission allows moderating every room with rank above owner
+	ModerateAnyRoomPermission Permission = "moderate_any_room"
+	// SuspendUserPermission allows suspending and resuming users
+	SuspendUserPermission Permission = "suspend_user"
+	// AddUserPermission allows adding users
+	AddUserPermission Permission = "add_user"
+	// DeleteUserPermission allows deleting users
+	DeleteUserPermission Permission = "delete_user"
+	// UpdateUserPermission allows changing role, token and name of users
+	UpdateUserPermission Permission = "update_user"
+)
+
+var (
+	userPermissions = []Permission{
+		ListPermission,
+		ChatPermission,
+		CreateRoomPermission,
+		JoinRoomPermission,
+		DeleteRoomPermission,
+		ModerateRoomPermission,
+	}
+
+	moderatorPermissions = append([]Permission{
+		DeleteAnyRoomPermission,
+		ModerateAnyRoomPermission,
+		SuspendUserPermission,
+	}, userPermissions...)
+
+	adminPermissions = append([]Permission{
+		AddUserPermission,
+		DeleteUserPermission,
+		UpdateUserPermission,
+	}, moderatorPermissions...)
+)
+
+// rolePermissions are permissions granted by each role
+var rolePermissions = map[string]map[Permission]bool{
+	UserRole:      permissionSet(userPermissions),
+	ModeratorRole: permissionSet(moderatorPermissions),
+	AdminRole:     permissionSet(adminPermissions),
+}
+
+// roleRank orders roles, user can manage only users with lower rank
+var roleRank = map[string]int{
+	UserRole:      0,
+	ModeratorRole: 1,
+	AdminRole:     2,
+}
+
+func permissionSet(permissions []Permission) map[Permission]bool {
+	set := make(map[Permission]bool)
+	for _, permission := range permissions {
+		set[permission] = true
+	}
+	return set
+}
@@ -0,0 +1,174 @@
+package chat
+
+// Replicator is told about every change of chat state made
+// on this node, so it can pass it to other nodes of cluster
+// Changes applied from other nodes are not passed to it, except
+// rooms swept after user was deleted on other node.
+// It is called with chat locks held, so it must not block.
+type Replicator interface {
+	PutUser(user *User)
+	DeleteUser(name string)
+	PutRoom(room *RoomRecord)
+	DeleteRoom(name string)
+}
+
+// SetReplicator sets replicator of chat changes,
+// it should be set before chat is used
+func (c *Chat) SetReplicator(replicator Replicator) {
+	c.usersLock.Lock()
+	defer c.usersLock.Unlock()
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+	c.replicator = replicator
+}
+
+func (c *Chat) persistUser(user *User) error {
+	if err := c.store.PutUser(user); err != nil {
+		return err
+	}
+	if c.replicator != nil {
+		c.replicator.PutUser(user)
+	}
+	return nil
+}
+
+func (c *Chat) persistUserDeleted(name string) error {
+	if err := c.store.DeleteUser(name); err != nil {
+		return err
+	}
+	if c.replicator != nil {
+		c.replicator.DeleteUser(name)
+	}
+	return nil
+}
+
+func (c *Chat) persistRoom(room *RoomRecord) error {
+	if err := c.store.PutRoom(room); err != nil {
+		return err
+	}
+	if c.replicator != nil {
+		c.replicator.PutRoom(room)
+	}
+	return nil
+}
+
+func (c *Chat) persistRoomDeleted(name string) error {
+	if err := c.store.DeleteRoom(name); err != nil {
+		return err
+	}
+	if c.replicator != nil {
+		c.replicator.DeleteRoom(name)
+	}
+	return nil
+}
+
+// ApplyUser adds or replaces user with one changed on other node,
+// user is expected to have token already hashed
+func (c *Chat) ApplyUser(user *User) error {
+	applied := *user
+	applied.Token = ""
+
+	c.usersLock.Lock()
+	if err := c.store.PutUser(&applied); err != nil {
+		c.usersLock.Unlock()
+		return err
+	}
+	if previous, ok := c.users[applied.Name]; ok && previous.AuthID != applied.AuthID {
+		delete(c.byAuthID, previous.AuthID)
+	}
+	c.users[applied.Name] = &applied
+	if applied.AuthID != "" {
+		c.byAuthID[applied.AuthID] = &applied
+	}
+	c.usersLock.Unlock()
+
+	c.refreshMember(applied.Name)
+	return nil
+}
+
+// ApplyUserDeleted deletes user deleted on other node, he is
+// removed from rooms like on that node, also when he is not known
+// here and rooms keep him only by name
+func (c *Chat) ApplyUserDeleted(name string) error {
+	c.usersLock.Lock()
+	user, ok := c.users[name]
+	if ok {
+		if err := c.store.DeleteUser(name); err != nil {
+			c.usersLock.Unlock()
+			return err
+		}
+		delete(c.users, name)
+		delete(c.byAuthID, user.AuthID)
+	} else {
+		user = &User{Name: name}
+	}
+	c.usersLock.Unlock()
+
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+	for roomname, locked := range c.rooms {
+		if err := c.removeMember(roomname, locked, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyRoom adds or replaces room with one changed on other node,
+// members not known yet are kept by name until they arrive
+func (c *Chat) ApplyRoom(record *RoomRecord) error {
+	room := roomFromRecord(record, func(name string) *User {
+		if user, ok := c.user(name); ok {
+			return user
+		}
+		return &User{Name: name}
+	})
+
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+	if err := c.store.PutRoom(record); err != nil {
+		return err
+	}
+	locked, ok := c.rooms[room.Name]
+	if !ok {
+		c.rooms[room.Name] = &lockedRoom{room: room}
+		c.notifyMembers(room, nil)
+		return nil
+	}
+	locked.Lock()
+	defer locked.Unlock()
+	before := memberNames(locked.room)
+	locked.room = room
+	c.notifyMembers(room, before)
+	return nil
+}
+
+// ApplyRoomDeleted deletes room deleted on other node
+func (c *Chat) ApplyRoomDeleted(name string) error {
+	c.roomsLock.Lock()
+	defer c.roomsLock.Unlock()
+
+	locked, ok := c.rooms[name]
+	if !ok {
+		return nil
+	}
+	if err := c.store.DeleteRoom(name); err != nil {
+		return err
+	}
+	locked.Lock()
+	defer locked.Unlock()
+	delete(c.rooms, name)
+	locked.deleted = true
+	c.notifyDeleted(locked.room)
+	return nil
+}
+
+// Records returns users and records of rooms describing whole
+// chat state, so that other node can apply it
+func (c *Chat) Records() (users []*User, rooms []*RoomRecord) {
+	users = c.ListUsers()
+	for _, room := range c.ListRooms() {
+		rooms = append(rooms, NewRoomRecord(room))
+	}
+	return users, rooms
+}
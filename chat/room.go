@@ -1,17 +1,64 @@
 package chat
 
+import "time"
+
+// Roles of users in room, ordered by rank
+const (
+	// RoomMember can chat in room
+	RoomMember = "member"
+	// RoomModerator can kick, ban and mute members
+	RoomModerator = "moderator"
+	// RoomOwner can also promote moderators and transfer ownership
+	RoomOwner = "owner"
+)
+
+// Access modes of rooms
+const (
+	// PublicAccess room can be seen and joined by everyone
+	PublicAccess = "public"
+	// InviteAccess room can be seen and joined only by invited users
+	InviteAccess = "invite"
+	// PasswordAccess room can be seen by everyone and joined with password
+	PasswordAccess = "password"
+	// PersonalAccess room is inbox of its owner, others can only post to it
+	PersonalAccess = "personal"
+)
+
+var roomRoleRank = map[string]int{
+	"":            0,
+	RoomMember:    1,
+	RoomModerator: 2,
+	RoomOwner:     3,
+}
+
 // Room represents place where users can chat
 type Room struct {
 	// Name is human readable name for room
 	Name string
-	// Creator is a name of user which created room
+	// Creator is a name of user which owns room,
+	// it is user which created room until ownership is transferred
 	Creator string
 	// Users is list of users currently in chat
 	Users []*User
+	// Moderators are names of room moderators
+	Moderators map[string]bool
+	// Banned are names of users which can't join room
+	Banned map[string]bool
+	// Muted are names of users which can't post until given time
+	Muted map[string]time.Time
+	// Access is access mode of room, PublicAccess when empty
+	Access string
+	// Password protects room with PasswordAccess
+	Password *Password
+	// Invites are names of users invited to room
+	Invites map[string]bool
 }
 
 // Join adds user to room
 func (r *Room) Join(user *User) error {
+	if r.Banned[user.Name] {
+		return ErrBanned
+	}
 	for _, roomUser := range r.Users {
 		if user.Name == roomUser.Name {
 			return ErrExists
@@ -21,6 +68,74 @@ func (r *Room) Join(user *User) error {
 	return nil
 }
 
+// CanJoin checks if user may join room with access mode,
+// invited users join any room except personal one without password
+func (r *Room) CanJoin(user *User, password string) error {
+	if user.Name == r.Creator || r.Invites[user.Name] {
+		return nil
+	}
+	switch r.Access {
+	case PersonalAccess:
+		return ErrNotPermit
+	case InviteAccess:
+		if !r.Invites[user.Name] {
+			return ErrNotPermit
+		}
+	case PasswordAccess:
+		if !r.Password.Matches(password) {
+			return ErrNotPermit
+		}
+	}
+	return nil
+}
+
+// VisibleTo checks if user can see room
+func (r *Room) VisibleTo(user *User) bool {
+	switch r.Access {
+	case PersonalAccess:
+		return user.Name == r.Creator
+	case InviteAccess:
+		return r.HasUser(user.Name) || r.Invites[user.Name] || user.Can(ModerateAnyRoomPermission)
+	default:
+		return true
+	}
+}
+
+// CanPost checks if user may post to room, personal rooms accept
+// posts from everyone, other rooms only from members
+func (r *Room) CanPost(name string, now time.Time) error {
+	if r.IsMuted(name, now) {
+		return ErrMuted
+	}
+	if r.Access != PersonalAccess && !r.HasUser(name) {
+		return ErrNotPermit
+	}
+	return nil
+}
+
+// validAccess checks if room has known access mode
+// with everything this mode needs
+func validAccess(r *Room) bool {
+	switch r.Access {
+	case "", PublicAccess, InviteAccess:
+		return true
+	case PasswordAccess:
+		return r.Password != nil
+	case PersonalAccess:
+		return r.Name == r.Creator
+	default:
+		return false
+	}
+}
+
+// invite lets user with name join room
+func (r *Room) invite(name string) {
+	if r.Invites == nil {
+		r.Invites = make(map[string]bool)
+	}
+	r.Invites[name] = true
+}
+
 // Exit removes user from room
 func (r *Room) Exit(user *User) error {
 	for i, roomUser := range r.Users {
@@ -31,3 +146,103 @@ func (r *Room) Exit(user *User) error {
 	}
 	return ErrNotFound
 }
+
+// HasUser checks if user with name is in room
+func (r *Room) HasUser(name string) bool {
+	for _, roomUser := range r.Users {
+		if roomUser.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleOf returns role of user with name in room,
+// empty if user is not in room
+func (r *Room) RoleOf(name string) string {
+	switch {
+	case name == r.Creator:
+		return RoomOwner
+	case !r.HasUser(name):
+		return ""
+	case r.Moderators[name]:
+		return RoomModerator
+	default:
+		return RoomMember
+	}
+}
+
+// IsMuted checks if user with name can't post at time
+func (r *Room) IsMuted(name string, now time.Time) bool {
+	until, ok := r.Muted[name]
+	return ok && now.Before(until)
+}
+
+// ban removes user from room and prevents him from joining again
+func (r *Room) ban(user *User) {
+	r.Exit(user)
+	delete(r.Moderators, user.Name)
+	if r.Banned == nil {
+		r.Banned = make(map[string]bool)
+	}
+	r.Banned[user.Name] = true
+}
+
+// mute prevents user from posting until given time,
+// zero time unmutes user
+func (r *Room) mute(name string, until time.Time) {
+	if until.IsZero() {
+		delete(r.Muted, name)
+		return
+	}
+	if r.Muted == nil {
+		r.Muted = make(map[string]time.Time)
+	}
+	r.Muted[name] = until
+}
+
+func (r *Room) setModerator(name string, moderator bool) {
+	if !moderator {
+		delete(r.Moderators, name)
+		return
+	}
+	if r.Moderators == nil {
+		r.Moderators = make(map[string]bool)
+	}
+	r.Moderators[name] = true
+}
+
+// snapshot returns copy of room which shares nothing changed
+// by chat with room, users in it are never changed in place
+func (r *Room) snapshot() *Room {
+	copied := *r
+	copied.Users = append([]*User(nil), r.Users...)
+	copied.Moderators = copySet(r.Moderators)
+	copied.Banned = copySet(r.Banned)
+	copied.Invites = copySet(r.Invites)
+	copied.Muted = nil
+	for name, until := range r.Muted {
+		copied.mute(name, until)
+	}
+	return &copied
+}
+
+func copySet(set map[string]bool) map[string]bool {
+	if set == nil {
+		return nil
+	}
+	copied := make(map[string]bool, len(set))
+	for name, value := range set {
+		copied[name] = value
+	}
+	return copied
+}
+
+// dropExpiredMutes forgets mutes which are over
+func (r *Room) dropExpiredMutes(now time.Time) {
+	for name, until := range r.Muted {
+		if !now.Before(until) {
+			delete(r.Muted, name)
+		}
+	}
+}
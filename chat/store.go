@@ -0,0 +1,161 @@
+package chat
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists users and rooms of chat
+// Chat writes every change through store and restores
+// its state from store when created with NewChatWithStore
+type Store interface {
+	// PutUser adds or replaces user
+	PutUser(user *User) error
+	// DeleteUser removes user with name
+	DeleteUser(name string) error
+	// PutRoom adds or replaces room
+	PutRoom(room *RoomRecord) error
+	// DeleteRoom removes room with name
+	DeleteRoom(name string) error
+	// Users returns all stored users
+	Users() ([]*User, error)
+	// Rooms returns all stored rooms
+	Rooms() ([]*RoomRecord, error)
+	// Close flushes and closes store
+	Close() error
+}
+
+// RoomRecord is room in form suitable for storing,
+// users are referenced by their names
+type RoomRecord struct {
+	Name       string               `json:"name"`
+	Creator    string               `json:"creator"`
+	Users      []string             `json:"users"`
+	Moderators []string             `json:"moderators,omitempty"`
+	Banned     []string             `json:"banned,omitempty"`
+	Muted      map[string]time.Time `json:"muted,omitempty"`
+	Access     string               `json:"access,omitempty"`
+	Password   *Password            `json:"password,omitempty"`
+	Invites    []string             `json:"invites,omitempty"`
+}
+
+// NewRoomRecord returns record describing room
+func NewRoomRecord(room *Room) *RoomRecord {
+	record := &RoomRecord{
+		Name:     room.Name,
+		Creator:  room.Creator,
+		Access:   room.Access,
+		Password: room.Password,
+	}
+	for _, user := range room.Users {
+		record.Users = append(record.Users, user.Name)
+	}
+	record.Moderators = sortedNames(room.Moderators)
+	record.Banned = sortedNames(room.Banned)
+	record.Invites = sortedNames(room.Invites)
+	if len(room.Muted) > 0 {
+		record.Muted = make(map[string]time.Time)
+		for name, until := range room.Muted {
+			record.Muted[name] = until
+		}
+	}
+	return record
+}
+
+func sortedNames(set map[string]bool) (names []string) {
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MemoryStore keeps chat state in memory only
+type MemoryStore struct {
+	sync.Mutex
+	users map[string]User
+	rooms map[string]RoomRecord
+}
+
+// NewMemoryStore returns empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[string]User),
+		rooms: make(map[string]RoomRecord),
+	}
+}
+
+// PutUser adds or replaces user
+func (m *MemoryStore) PutUser(user *User) error {
+	m.Lock()
+	defer m.Unlock()
+	m.users[user.Name] = *user
+	return nil
+}
+
+// DeleteUser removes user with name
+func (m *MemoryStore) DeleteUser(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.users, name)
+	return nil
+}
+
+// PutRoom adds or replaces room
+func (m *MemoryStore) PutRoom(room *RoomRecord) error {
+	m.Lock()
+	defer m.Unlock()
+	m.rooms[room.Name] = *copyRoomRecord(room)
+	return nil
+}
+
+// DeleteRoom removes room with name
+func (m *MemoryStore) DeleteRoom(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.rooms, name)
+	return nil
+}
+
+// Users returns all stored users
+func (m *MemoryStore) Users() (users []*User, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, user := range m.users {
+		u := user
+		users = append(users, &u)
+	}
+	return users, nil
+}
+
+// Rooms returns all stored rooms
+func (m *MemoryStore) Rooms() (rooms []*RoomRecord, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, room := range m.rooms {
+		rooms = append(rooms, copyRoomRecord(&room))
+	}
+	return rooms, nil
+}
+
+// Close does nothing for memory store
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// copyRoomRecord returns deep copy of record
+func copyRoomRecord(room *RoomRecord) *RoomRecord {
+	record := *room
+	record.Users = append([]string(nil), room.Users...)
+	record.Moderators = append([]string(nil), room.Moderators...)
+	record.Banned = append([]string(nil), room.Banned...)
+	record.Invites = append([]string(nil), room.Invites...)
+	if room.Muted != nil {
+		record.Muted = make(map[string]time.Time)
+		for name, until := range room.Muted {
+			record.Muted[name] = until
+		}
+	}
+	return &record
+}
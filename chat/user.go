@@ -3,6 +3,8 @@ package chat
 const (
 	// AdminRole represents administrative role
 	AdminRole = "admin"
+	// ModeratorRole represents role keeping order in chat
+	ModeratorRole = "moderator"
 	// UserRole represents regular user role
 	UserRole = "user"
 )
@@ -11,18 +13,55 @@ const (
 type User struct {
 	// Name is unique user name
 	Name string
-	// Role determines user permissions
+	// Role determines user permissions, see rolePermissions
 	// Currently supported are:
-	// - admin : can add users +
+	// - admin : can add, delete and update users +
+	// - moderator : can suspend users and delete any room +
 	// - user - can chat, create and delete rooms
 	Role string
 	// AuthID is username for authorization
 	AuthID string
-	// Token is password for authorization
+	// Token is password for authorization, it is only passed
+	// to AddUser and UpdateUser, chat keeps its hash in Password
 	Token string
+	// Password is salted hash of Token
+	Password *Password
+	// DisplayName is name shown to other users, Name when empty
+	DisplayName string
+	// Suspended user exists but can't use chat
+	Suspended bool
+	// Version grows with every change of user, so other nodes
+	// of cluster can tell if they got it already
+	Version uint64
+}
+
+// Can checks whether user role grants permission
+func (u *User) Can(permission Permission) bool {
+	return rolePermissions[u.Role][permission]
 }
 
 // CanAddUser checks whether user can add another one
 func (u *User) CanAddUser() bool {
-	return u.Role == AdminRole
+	return u.Can(AddUserPermission)
+}
+
+// CanManage checks whether user can act on target user,
+// only users with higher role can be managed, admin can manage other admins
+func (u *User) CanManage(target *User) bool {
+	if u.Name == target.Name {
+		return false
+	}
+	return u.Role == AdminRole || roleRank[u.Role] > roleRank[target.Role]
+}
+
+// UserUpdate describes changes of user, empty fields are left unchanged
+type UserUpdate struct {
+	Role        string
+	Token       string
+	DisplayName string
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
 }
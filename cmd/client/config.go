@@ -26,11 +26,65 @@ type Config struct {
 
 	// MessageChance is time in ms is chance to join room with random user and send message
 	MessageToUserChance uint
+
+	// Scenario is path to scenario file, when set it replaces
+	// Workers, RoomOp and MessageToUserChance
+	Scenario string
+
+	// Delivery is how workers receive messages, "poll" (default),
+	// "longpoll", "websocket" or "sse"
+	Delivery string
+
+	// PollInterval is time in ms between polls for messages,
+	// with long poll it is time between retries of failed poll
+	PollInterval uint
+
+	// PollWait is time in ms long poll waits for messages
+	PollWait uint
+
+	// PollMax is maximal number of messages returned by long poll,
+	// zero returns all
+	PollMax uint
+
+	// SummaryInterval is time in seconds between printed summaries
+	SummaryInterval uint
+
+	// ReportPath is path of final report without extension,
+	// report is written as .json and .csv
+	ReportPath string
+
+	// TLS makes clients connect with https and wss
+	TLS bool
+
+	// TLSCA is path of CA bundle trusted by clients, system roots when empty
+	TLSCA string
+
+	// TLSCert and TLSKey are paths of client certificate and key for mutual TLS
+	TLSCert string
+	TLSKey  string
 }
 
+const (
+	// PollDelivery receives messages by polling GET message
+	PollDelivery = "poll"
+	// LongPollDelivery receives messages by polling GET message
+	// which waits for them
+	LongPollDelivery = "longpoll"
+	// WebsocketDelivery receives messages pushed over websocket
+	WebsocketDelivery = "websocket"
+	// SSEDelivery receives messages from Server-Sent Events stream
+	SSEDelivery = "sse"
+)
+
 // ReadConfig reads config from file
 func ReadConfig(path string) (*Config, error) {
-	cfg := &Config{}
+	cfg := &Config{
+		Delivery:        PollDelivery,
+		PollInterval:    100,
+		PollWait:        5000,
+		SummaryInterval: 10,
+		ReportPath:      "perfchat_report",
+	}
 	_, err := toml.DecodeFile(path, cfg)
 	return cfg, err
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets precision of histogram, each power of two range
+// is split into 2^subBucketBits buckets which gives error below 1%
+const (
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+)
+
+// Histogram is HDR-style histogram of durations with microsecond
+// resolution, buckets grow exponentially so that relative error
+// is constant for whole range
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewHistogram returns empty histogram
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - (subBucketBits + 1)
+	return subBucketCount*(shift+1) + int(v>>uint(shift)) - subBucketCount
+}
+
+// bucketValue returns middle of bucket value range
+func bucketValue(index int) uint64 {
+	if index < subBucketCount {
+		return uint64(index)
+	}
+	shift := uint(index/subBucketCount - 1)
+	mantissa := uint64(index%subBucketCount + subBucketCount)
+	return mantissa<<shift + (uint64(1)<<shift)/2
+}
+
+// Record adds duration to histogram
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	index := bucketIndex(uint64(d / time.Microsecond))
+	if index >= len(h.counts) {
+		counts := make([]uint64, index+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[index]++
+
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += d
+}
+
+// Merge adds all values from other histogram
+func (h *Histogram) Merge(other *Histogram) {
+	if other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]uint64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.total += other.total
+	h.sum += other.sum
+}
+
+// Count returns number of recorded values
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+// Min returns smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns average of recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Quantile returns value below which q (0..1) of recorded values are
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	if rank >= h.total {
+		return h.max
+	}
+
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			value := time.Duration(bucketValue(i)) * time.Microsecond
+			// bucket middle can be outside of recorded range
+			if value > h.max {
+				return h.max
+			}
+			if value < h.min {
+				return h.min
+			}
+			return value
+		}
+	}
+	return h.max
+}
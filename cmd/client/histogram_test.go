@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 1000 {
+		t.Errorf("Count, got %d, expected %d", h.Count(), 1000)
+	}
+	if h.Min() != time.Millisecond || h.Max() != time.Second {
+		t.Errorf("Range, got %s-%s, expected %s-%s", h.Min(), h.Max(), time.Millisecond, time.Second)
+	}
+
+	for q, expected := range map[float64]time.Duration{
+		0.5:  500 * time.Millisecond,
+		0.99: 990 * time.Millisecond,
+	} {
+		got := h.Quantile(q)
+		if diff := got - expected; diff > expected/100 || diff < -expected/100 {
+			t.Errorf("Quantile(%v), got %s, expected %s", q, got, expected)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.Record(time.Microsecond)
+	b.Record(time.Hour)
+	a.Merge(b)
+
+	if a.Count() != 2 || a.Min() != time.Microsecond || a.Max() != time.Hour {
+		t.Errorf("Merge, got count=%d min=%s max=%s", a.Count(), a.Min(), a.Max())
+	}
+	if q := a.Quantile(1); q != time.Hour {
+		t.Errorf("Quantile(1), got %s, expected %s", q, time.Hour)
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	api "github.com/phob0s-pl/perfchat/apiv1"
@@ -35,85 +39,106 @@ func main() {
 	}
 	log.Debugf("Read configuration from %q: %+v", *configPath, config)
 
+	scenario := DefaultScenario(config)
+	if config.Scenario != "" {
+		if scenario, err = ReadScenario(config.Scenario); err != nil {
+			log.Fatalf("Failed to read scenario, err=%s", err)
+		}
+	} else if err := scenario.Validate(); err != nil {
+		log.Fatalf("Invalid config, err=%s", err)
+	}
+
 	admin := &chat.User{
 		Role:   chat.AdminRole,
 		AuthID: config.AuthID,
 		Token:  config.Token,
 		Name:   "admin",
 	}
-	adminClient := api.NewClient(admin, config.Address)
-
-	for i := uint(0); i < config.Workers; i++ {
-		workerUser := &chat.User{
-			AuthID: RandString(),
-			Name:   RandString(),
-			Role:   chat.UserRole,
-			Token:  RandString(),
+	var tlsConfig *tls.Config
+	if config.TLS {
+		if tlsConfig, err = api.NewClientTLSConfig(config.TLSCA, config.TLSCert, config.TLSKey); err != nil {
+			log.Fatalf("Failed to load TLS config, err=%s", err)
 		}
-		if err := adminClient.AddUser(workerUser); err != nil {
-			log.Fatalf("Failed to add user, err=%s", err)
-		}
-		go worker(config, workerUser)
+	}
+	adminClient := api.NewClientWithTLS(admin, config.Address, tlsConfig)
+	if err := adminClient.Login(); err != nil {
+		log.Fatalf("Failed to login admin, err=%s", err)
 	}
 
-	select {}
-}
-
-func worker(c *Config, user *chat.User) {
 	var (
-		msgCount uint
-		msg      = fmt.Sprintf("%s_msg", user.Name)
-		client   = api.NewClient(user, c.Address)
-		roomT    = time.NewTicker(time.Duration(c.RoomOp) * time.Millisecond)
-		messageT = time.NewTicker(time.Duration(c.MessageToUserChance) * time.Millisecond)
-		myRoom   string
+		workers []*Worker
+		windows [][2]time.Duration
 	)
-
-	for {
-		select {
-		case <-messageT.C:
-			users, err := client.GetUsers()
-			if err != nil {
-				log.Debugf("Failed to list users, err=%s", err)
-				continue
-			}
-			if len(users) == 0 {
-				continue
+	for _, population := range scenario.Population {
+		for i := uint(0); i < population.Count; i++ {
+			workerUser := &chat.User{
+				AuthID: RandString(),
+				Name:   RandString(),
+				Role:   chat.UserRole,
+				Token:  RandString(),
 			}
-
-			rooms, err := client.GetRooms()
-			if err != nil {
-				log.Debugf("Failed to list rooms, err=%s", err)
-				continue
-			}
-			randomUser := users[randSrc.Uint32()%uint32(len(users))]
-			if randomUser.Name == "admin" {
-				continue
+			if err := adminClient.AddUser(workerUser); err != nil {
+				log.Fatalf("Failed to add user, err=%s", err)
 			}
-			dstRoom := findUserInRoom(randomUser.Name, rooms)
+			workers = append(workers, NewWorker(config, tlsConfig, scenario, population, workerUser))
+			// ramp each population separately to keep their proportions
+			startAt, stopAt := scenario.Ramp.Window(i, population.Count)
+			windows = append(windows, [2]time.Duration{startAt, stopAt})
+		}
+	}
 
-			if err := client.SendMessage(&api.Message{Content: msg, Room: dstRoom}); err != nil {
-				log.Debugf("Failed to send msg, err=%s", err)
-			}
-			log.Debugf("[%d]msg sent", msgCount)
-			msgCount++
+	var (
+		stats    = NewStats()
+		start    = time.Now()
+		finished = make(chan bool)
+		wg       sync.WaitGroup
+	)
+	if d := scenario.Duration(); d > 0 {
+		fmt.Printf("Running %d workers for %s\n", len(workers), d)
+	}
+	for i, w := range workers {
+		wg.Add(1)
+		go func(w *Worker, window [2]time.Duration) {
+			defer wg.Done()
+			w.Run(stats, start, window[0], window[1])
+		}(w, windows[i])
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	go summary(config, stats)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-signals:
+	case <-finished:
+	}
 
-		case <-roomT.C:
-			_ = client.RoomDelete(myRoom)
-			myRoom = RandString()
-			_ = client.RoomCreate(myRoom)
+	report := stats.Report()
+	report.Violations = scenario.SLO.Check(report)
+	fmt.Printf("Final: %s\n", report)
+	if err := report.WriteJSON(config.ReportPath + ".json"); err != nil {
+		log.Errorf("Failed to write report, err=%s", err)
+	}
+	if err := report.WriteCSV(config.ReportPath + ".csv"); err != nil {
+		log.Errorf("Failed to write report, err=%s", err)
+	}
 
+	if len(report.Violations) > 0 {
+		for _, violation := range report.Violations {
+			fmt.Printf("SLO violated: %s\n", violation)
 		}
+		os.Exit(SLOExitCode)
 	}
 }
 
-func findUserInRoom(username string, rooms []api.Room) string {
-	for _, room := range rooms {
-		for _, roomUser := range room.Users {
-			if roomUser == username {
-				return room.Name
-			}
-		}
+// summary periodically prints results collected so far
+func summary(c *Config, stats *Stats) {
+	summaryT := time.NewTicker(time.Duration(c.SummaryInterval) * time.Second)
+	for range summaryT.C {
+		fmt.Printf("Summary: %s\n", stats.Report())
 	}
-	return "i_want_to_sleep_:<"
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLOExitCode is returned by client when run violated SLO
+const SLOExitCode = 2
+
+// Ramp describes how workers are started and stopped,
+// all values are in seconds
+// when ramp is set, run lasts Up + Steady + Down
+type Ramp struct {
+	// Up is time in which workers are started one by one
+	Up uint
+	// Steady is time all workers are running
+	Steady uint
+	// Down is time in which workers are stopped one by one
+	Down uint
+}
+
+// Enabled checks if ramp is set
+func (r *Ramp) Enabled() bool {
+	return r.Up > 0 || r.Steady > 0 || r.Down > 0
+}
+
+// Duration returns time of whole run
+func (r *Ramp) Duration() time.Duration {
+	return time.Duration(r.Up+r.Steady+r.Down) * time.Second
+}
+
+// Window returns when i-th of n workers starts and stops counting
+// from start of run, workers stop in reverse order of starting
+// zero stop means worker runs until scenario phases are over
+func (r *Ramp) Window(i, n uint) (start, stop time.Duration) {
+	if !r.Enabled() || n == 0 {
+		return 0, 0
+	}
+	up := time.Duration(r.Up) * time.Second
+	down := time.Duration(r.Down) * time.Second
+	steady := time.Duration(r.Steady) * time.Second
+
+	start = up * time.Duration(i) / time.Duration(n)
+	stop = up + steady + down*time.Duration(n-i)/time.Duration(n)
+	return start, stop
+}
+
+// SLO are thresholds which run must meet, zero disables check
+type SLO struct {
+	// LatencyP99 is maximum 99th percentile of message latency in ms
+	LatencyP99 uint
+	// MaxErrorRate is maximum number of failed API calls per 1000 calls
+	MaxErrorRate uint
+	// MaxLossRate is maximum number of lost messages per 1000 received
+	MaxLossRate uint
+}
+
+// Check returns descriptions of all thresholds violated in report
+func (s *SLO) Check(r *Report) (violations []string) {
+	if s.LatencyP99 > 0 {
+		p99 := time.Duration(r.MessageLatency.P99) * time.Microsecond
+		if limit := time.Duration(s.LatencyP99) * time.Millisecond; p99 > limit {
+			violations = append(violations, fmt.Sprintf("latency p99 %s above %s", p99, limit))
+		}
+	}
+
+	if s.MaxErrorRate > 0 {
+		var calls uint64
+		for _, call := range r.Calls {
+			calls += call.Count
+		}
+		if calls > 0 && r.Errors*1000 > calls*uint64(s.MaxErrorRate) {
+			violations = append(violations, fmt.Sprintf("%d errors in %d calls above %d per 1000", r.Errors, calls, s.MaxErrorRate))
+		}
+	}
+
+	if s.MaxLossRate > 0 {
+		total := r.Received + r.Lost
+		if total > 0 && r.Lost*1000 > total*uint64(s.MaxLossRate) {
+			violations = append(violations, fmt.Sprintf("%d lost of %d messages above %d per 1000", r.Lost, total, s.MaxLossRate))
+		}
+	}
+	return violations
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRampWindow(t *testing.T) {
+	ramp := &Ramp{Up: 10, Steady: 60, Down: 20}
+	if d := ramp.Duration(); d != 90*time.Second {
+		t.Errorf("Duration, got %s, expected %s", d, 90*time.Second)
+	}
+
+	tests := []struct {
+		i           uint
+		start, stop time.Duration
+	}{
+		{0, 0, 90 * time.Second},
+		{1, 5 * time.Second, 80 * time.Second},
+	}
+	for _, test := range tests {
+		start, stop := ramp.Window(test.i, 2)
+		if start != test.start || stop != test.stop {
+			t.Errorf("Window(%d, 2), got %s-%s, expected %s-%s", test.i, start, stop, test.start, test.stop)
+		}
+	}
+
+	if start, stop := (&Ramp{}).Window(1, 2); start != 0 || stop != 0 {
+		t.Errorf("Disabled ramp should not limit workers, got %s-%s", start, stop)
+	}
+}
+
+func TestSLOCheck(t *testing.T) {
+	report := &Report{
+		Received:       990,
+		Lost:           10,
+		Errors:         2,
+		MessageLatency: LatencyReport{P99: 300000},
+		Calls:          map[string]CallReport{"SendMessage": {Count: 1000}},
+	}
+
+	slo := &SLO{LatencyP99: 500, MaxErrorRate: 5, MaxLossRate: 20}
+	if violations := slo.Check(report); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+
+	slo = &SLO{LatencyP99: 200, MaxErrorRate: 1, MaxLossRate: 5}
+	if violations := slo.Check(report); len(violations) != 3 {
+		t.Errorf("Expected 3 violations, got %v", violations)
+	}
+}
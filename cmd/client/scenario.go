@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Actions which can be performed by workers
+const (
+	// CreateRoomAction creates new room owned by worker
+	CreateRoomAction = "CreateRoom"
+	// DeleteRoomAction deletes one of rooms created by worker
+	DeleteRoomAction = "DeleteRoom"
+	// JoinRoomAction joins random room
+	JoinRoomAction = "JoinRoom"
+	// ExitRoomAction exits one of joined rooms
+	ExitRoomAction = "ExitRoom"
+	// SendMessageAction sends message to one of worker rooms
+	SendMessageAction = "SendMessage"
+	// MessageToUserAction sends message to random user
+	MessageToUserAction = "MessageToUser"
+	// ListUsersAction lists all users
+	ListUsersAction = "ListUsers"
+	// ListRoomsAction lists all rooms
+	ListRoomsAction = "ListRooms"
+)
+
+var knownActions = []string{
+	CreateRoomAction,
+	DeleteRoomAction,
+	JoinRoomAction,
+	ExitRoomAction,
+	SendMessageAction,
+	MessageToUserAction,
+	ListUsersAction,
+	ListRoomsAction,
+}
+
+// Load modes of scenario
+const (
+	// ClosedLoop workers start next action after previous one finished
+	// and think time passed, offered load drops when server slows down
+	ClosedLoop = "closed"
+	// OpenLoop workers start actions with population Rate no matter
+	// how long previous ones take
+	OpenLoop = "open"
+)
+
+// Distributions of random values
+const (
+	// FixedDistribution always returns Value
+	FixedDistribution = "fixed"
+	// UniformDistribution returns values between Min and Max
+	UniformDistribution = "uniform"
+	// NormalDistribution returns values around Mean with StdDev
+	NormalDistribution = "normal"
+	// ExponentialDistribution returns values with Mean
+	ExponentialDistribution = "exponential"
+)
+
+// Distribution describes random value,
+// if Max is set values are clipped to Min..Max
+type Distribution struct {
+	Type   string
+	Value  uint
+	Min    uint
+	Max    uint
+	Mean   uint
+	StdDev uint
+}
+
+// Sample returns random value from distribution
+func (d *Distribution) Sample(rnd *rand.Rand) float64 {
+	var v float64
+	switch d.Type {
+	case UniformDistribution:
+		v = float64(d.Min) + rnd.Float64()*float64(d.Max-d.Min)
+	case NormalDistribution:
+		v = float64(d.Mean) + rnd.NormFloat64()*float64(d.StdDev)
+	case ExponentialDistribution:
+		v = rnd.ExpFloat64() * float64(d.Mean)
+	default:
+		v = float64(d.Value)
+	}
+
+	if d.Max > 0 {
+		v = math.Min(v, float64(d.Max))
+	}
+	return math.Max(v, float64(d.Min))
+}
+
+func (d *Distribution) validate() error {
+	switch d.Type {
+	case "", FixedDistribution, UniformDistribution, NormalDistribution, ExponentialDistribution:
+	default:
+		return fmt.Errorf("unknown distribution %q", d.Type)
+	}
+	if (d.Max > 0 || d.Type == UniformDistribution) && d.Max < d.Min {
+		return fmt.Errorf("distribution Max %d is below Min %d", d.Max, d.Min)
+	}
+	return nil
+}
+
+// Population is group of workers with the same behaviour
+type Population struct {
+	// Name identifies population in phases
+	Name string
+	// Count is number of workers
+	Count uint
+	// Actions are weights of actions, action is chosen with
+	// probability weight / sum of weights
+	Actions map[string]uint
+	// Think is time in ms worker waits between actions in closed loop
+	Think Distribution
+	// Rate is number of actions per second of whole population in open loop
+	Rate uint
+	// MessageSize is size in bytes of message content
+	MessageSize Distribution
+}
+
+// Phase is part of run with fixed duration
+type Phase struct {
+	Name string
+	// Duration is phase time in seconds
+	Duration uint
+	// Populations are names of populations active in phase, all when empty
+	Populations []string
+	// Actions if set replace weights of actions of active populations
+	Actions map[string]uint
+	// ThinkScale is think time or interval between actions in percent
+	// of population ones, 100 when not set
+	ThinkScale uint
+}
+
+// Scenario describes what workers do during run
+// if there are no phases nor ramp scenario runs until interrupted
+type Scenario struct {
+	// Mode is ClosedLoop or OpenLoop, ClosedLoop when not set
+	Mode       string
+	Population []*Population
+	Phase      []*Phase
+	Ramp       Ramp
+	SLO        SLO
+}
+
+// ReadScenario reads scenario from file
+func ReadScenario(path string) (*Scenario, error) {
+	scenario := &Scenario{}
+	if _, err := toml.DecodeFile(path, scenario); err != nil {
+		return nil, err
+	}
+	return scenario, scenario.Validate()
+}
+
+// DefaultScenario returns scenario doing what legacy config options describe,
+// every worker sends message to random user each MessageToUserChance ms
+// and recreates its room each RoomOp ms on average
+func DefaultScenario(c *Config) *Scenario {
+	return &Scenario{
+		Population: []*Population{{
+			Name:  "default",
+			Count: c.Workers,
+			Actions: map[string]uint{
+				MessageToUserAction: c.RoomOp,
+				CreateRoomAction:    c.MessageToUserChance,
+				DeleteRoomAction:    c.MessageToUserChance,
+			},
+			Think:       Distribution{Type: FixedDistribution, Value: c.MessageToUserChance},
+			MessageSize: Distribution{Type: FixedDistribution, Value: 64},
+		}},
+	}
+}
+
+// Validate checks if scenario is complete and consistent
+func (s *Scenario) Validate() error {
+	if len(s.Population) == 0 {
+		return fmt.Errorf("scenario has no populations")
+	}
+	switch s.Mode {
+	case "", ClosedLoop, OpenLoop:
+	default:
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+
+	names := make(map[string]bool)
+	for _, population := range s.Population {
+		if names[population.Name] {
+			return fmt.Errorf("population %q defined twice", population.Name)
+		}
+		names[population.Name] = true
+
+		if err := validateActions(population.Actions); err != nil {
+			return fmt.Errorf("population %q: %s", population.Name, err)
+		}
+		if totalWeight(population.Actions) == 0 {
+			return fmt.Errorf("population %q: no actions", population.Name)
+		}
+		if s.Mode == OpenLoop && population.Rate == 0 {
+			return fmt.Errorf("population %q: no rate in open loop", population.Name)
+		}
+		if err := population.Think.validate(); err != nil {
+			return fmt.Errorf("population %q think: %s", population.Name, err)
+		}
+		if err := population.MessageSize.validate(); err != nil {
+			return fmt.Errorf("population %q message size: %s", population.Name, err)
+		}
+	}
+
+	for _, phase := range s.Phase {
+		if phase.Duration == 0 {
+			return fmt.Errorf("phase %q: no duration", phase.Name)
+		}
+		for _, name := range phase.Populations {
+			if !names[name] {
+				return fmt.Errorf("phase %q: unknown population %q", phase.Name, name)
+			}
+		}
+		if err := validateActions(phase.Actions); err != nil {
+			return fmt.Errorf("phase %q: %s", phase.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateActions(actions map[string]uint) error {
+	for action := range actions {
+		known := false
+		for _, name := range knownActions {
+			known = known || name == action
+		}
+		if !known {
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+	return nil
+}
+
+func totalWeight(actions map[string]uint) (total uint) {
+	for _, weight := range actions {
+		total += weight
+	}
+	return total
+}
+
+// Duration returns time of run, it is the shorter of ramp and all phases
+// zero if scenario has neither of them
+func (s *Scenario) Duration() (total time.Duration) {
+	for _, phase := range s.Phase {
+		total += time.Duration(phase.Duration) * time.Second
+	}
+	if s.Ramp.Enabled() && (total == 0 || s.Ramp.Duration() < total) {
+		return s.Ramp.Duration()
+	}
+	return total
+}
+
+// PhaseAt returns phase active after elapsed time since start,
+// ok is false when all phases are over
+func (s *Scenario) PhaseAt(elapsed time.Duration) (phase *Phase, ok bool) {
+	if len(s.Phase) == 0 {
+		return &Phase{Name: "default"}, true
+	}
+	for _, phase := range s.Phase {
+		elapsed -= time.Duration(phase.Duration) * time.Second
+		if elapsed < 0 {
+			return phase, true
+		}
+	}
+	return nil, false
+}
+
+// Active checks if population works during phase
+func (p *Phase) Active(population string) bool {
+	if len(p.Populations) == 0 {
+		return true
+	}
+	for _, name := range p.Populations {
+		if name == population {
+			return true
+		}
+	}
+	return false
+}
+
+// ChooseAction returns random action of population weighted for phase
+func (p *Phase) ChooseAction(population *Population, rnd *rand.Rand) string {
+	actions := population.Actions
+	if len(p.Actions) > 0 {
+		actions = p.Actions
+	}
+
+	total := totalWeight(actions)
+	if total == 0 {
+		return ""
+	}
+	pick := uint(rnd.Int63n(int64(total)))
+	// iterate in fixed order so the same random value picks the same action
+	for _, action := range knownActions {
+		if pick < actions[action] {
+			return action
+		}
+		pick -= actions[action]
+	}
+	return ""
+}
+
+// ThinkTime returns random time worker waits before next action
+func (p *Phase) ThinkTime(population *Population, rnd *rand.Rand) time.Duration {
+	return p.scale(population.Think.Sample(rnd) * float64(time.Millisecond))
+}
+
+// Interval returns random time between starts of worker actions in open loop,
+// arrivals are Poisson process so that population makes Rate actions per second
+func (p *Phase) Interval(population *Population, rnd *rand.Rand) time.Duration {
+	if population.Rate == 0 {
+		return 0
+	}
+	mean := float64(population.Count) / float64(population.Rate) * float64(time.Second)
+	return p.scale(rnd.ExpFloat64() * mean)
+}
+
+func (p *Phase) scale(d float64) time.Duration {
+	scale := p.ThinkScale
+	if scale == 0 {
+		scale = 100
+	}
+	return time.Duration(d * float64(scale) / 100)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestReadScenario(t *testing.T) {
+	scenario, err := ReadScenario("../../deployment/local/scenario.conf")
+	if err != nil {
+		t.Fatalf("ReadScenario failed, err=%s", err)
+	}
+
+	if len(scenario.Population) != 2 || len(scenario.Phase) != 3 {
+		t.Fatalf("Expected 2 populations and 3 phases, got %d and %d", len(scenario.Population), len(scenario.Phase))
+	}
+	if d := scenario.Duration(); d != 180*time.Second {
+		t.Errorf("Duration, got %s, expected %s", d, 180*time.Second)
+	}
+
+	phase, ok := scenario.PhaseAt(10 * time.Second)
+	if !ok || phase.Name != "warmup" || phase.Active("chatters") {
+		t.Errorf("At 10s expected warmup without chatters, got %+v", phase)
+	}
+	if phase, ok = scenario.PhaseAt(170 * time.Second); !ok || phase.Name != "burst" {
+		t.Errorf("At 170s expected burst, got %+v", phase)
+	}
+	if _, ok = scenario.PhaseAt(181 * time.Second); ok {
+		t.Errorf("Phases should be over after 180s")
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	scenario := &Scenario{
+		Population: []*Population{{Name: "p", Actions: map[string]uint{"Dance": 1}}},
+	}
+	if err := scenario.Validate(); err == nil {
+		t.Errorf("Unknown action should fail validation")
+	}
+
+	scenario.Population[0].Actions = map[string]uint{SendMessageAction: 1}
+	scenario.Phase = []*Phase{{Name: "x", Duration: 1, Populations: []string{"q"}}}
+	if err := scenario.Validate(); err == nil {
+		t.Errorf("Unknown population in phase should fail validation")
+	}
+}
+
+func TestChooseAction(t *testing.T) {
+	var (
+		rnd        = rand.New(rand.NewSource(1))
+		phase      = &Phase{}
+		population = &Population{Actions: map[string]uint{SendMessageAction: 3, ListRoomsAction: 1}}
+		counts     = make(map[string]int)
+	)
+
+	for i := 0; i < 4000; i++ {
+		counts[phase.ChooseAction(population, rnd)]++
+	}
+	if len(counts) != 2 || counts[SendMessageAction] < 2800 || counts[SendMessageAction] > 3200 {
+		t.Errorf("Actions not chosen by weight, got %v", counts)
+	}
+
+	phase.Actions = map[string]uint{ExitRoomAction: 1}
+	if action := phase.ChooseAction(population, rnd); action != ExitRoomAction {
+		t.Errorf("Phase actions should override population, got %s", action)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	var (
+		rnd        = rand.New(rand.NewSource(1))
+		phase      = &Phase{}
+		population = &Population{Count: 10, Rate: 100}
+		total      time.Duration
+	)
+
+	for i := 0; i < 10000; i++ {
+		total += phase.Interval(population, rnd)
+	}
+	if mean := total / 10000; mean < 95*time.Millisecond || mean > 105*time.Millisecond {
+		t.Errorf("Mean interval, got %s, expected %s", mean, 100*time.Millisecond)
+	}
+
+	phase.ThinkScale = 50
+	total = 0
+	for i := 0; i < 10000; i++ {
+		total += phase.Interval(population, rnd)
+	}
+	if mean := total / 10000; mean < 47*time.Millisecond || mean > 53*time.Millisecond {
+		t.Errorf("Mean scaled interval, got %s, expected %s", mean, 50*time.Millisecond)
+	}
+}
+
+func TestOpenLoopValidate(t *testing.T) {
+	scenario := &Scenario{
+		Mode:       OpenLoop,
+		Population: []*Population{{Name: "p", Actions: map[string]uint{SendMessageAction: 1}}},
+	}
+	if err := scenario.Validate(); err == nil {
+		t.Errorf("Open loop population without rate should fail validation")
+	}
+
+	scenario.Population[0].Rate = 10
+	if err := scenario.Validate(); err != nil {
+		t.Errorf("Open loop scenario should be valid, err=%s", err)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/tls"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+	log "github.com/sirupsen/logrus"
+)
+
+// phaseCheck is how often idle worker checks if phase changed
+const phaseCheck = 100 * time.Millisecond
+
+// Worker simulates single chat user following scenario
+type Worker struct {
+	config     *Config
+	scenario   *Scenario
+	population *Population
+	user       *chat.User
+	client     *api.Client
+	stats      *Stats
+	// tracker follows sequences of rooms for receiver, rooms left
+	// by worker are forgotten so messages missed meanwhile are not lost
+	tracker *api.SequenceTracker
+
+	// lock guards fields below, in open loop actions run concurrently
+	lock sync.Mutex
+	rnd  *rand.Rand
+	// created are rooms created by worker
+	created []string
+	// joined are rooms joined by worker
+	joined []string
+}
+
+// NewWorker returns worker acting as user, tlsConfig is nil for plain http
+func NewWorker(c *Config, tlsConfig *tls.Config, scenario *Scenario, population *Population, user *chat.User) *Worker {
+	return &Worker{
+		config:     c,
+		scenario:   scenario,
+		population: population,
+		user:       user,
+		client:     api.NewClientWithTLS(user, c.Address, tlsConfig),
+		tracker:    api.NewSequenceTracker(),
+		rnd:        rand.New(rand.NewSource(randSrc.Int63())),
+	}
+}
+
+// Run performs scenario actions from startAt until stopAt or until all
+// phases are over, both are counted from start of run
+func (w *Worker) Run(stats *Stats, start time.Time, startAt, stopAt time.Duration) {
+	w.stats = stats
+	time.Sleep(time.Until(start.Add(startAt)))
+
+	if err := stats.Call("Login", w.client.Login); err != nil {
+		log.Errorf("Failed to login %s, err=%s", w.user.Name, err)
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go receiver(w.config, w.client, w.tracker, stats, done)
+
+	var (
+		openLoop = w.scenario.Mode == OpenLoop
+		next     = time.Now()
+		inFlight sync.WaitGroup
+	)
+	defer inFlight.Wait()
+	for {
+		if openLoop {
+			time.Sleep(time.Until(next))
+		}
+		elapsed := time.Since(start)
+		if stopAt > 0 && elapsed >= stopAt {
+			return
+		}
+		phase, ok := w.scenario.PhaseAt(elapsed)
+		if !ok {
+			return
+		}
+		if !phase.Active(w.population.Name) {
+			time.Sleep(phaseCheck)
+			next = time.Now()
+			continue
+		}
+
+		w.lock.Lock()
+		action := phase.ChooseAction(w.population, w.rnd)
+		think := phase.ThinkTime(w.population, w.rnd)
+		interval := phase.Interval(w.population, w.rnd)
+		w.lock.Unlock()
+
+		if !openLoop {
+			w.do(action, time.Now())
+			time.Sleep(think)
+			continue
+		}
+
+		// action is measured from time it was scheduled at, so slow
+		// responses show up in latency instead of lowering the load
+		inFlight.Add(1)
+		go func(at time.Time) {
+			defer inFlight.Done()
+			w.do(action, at)
+		}(next)
+		next = next.Add(interval)
+	}
+}
+
+// do performs action intended to start at given time
+func (w *Worker) do(action string, at time.Time) {
+	var err error
+	switch action {
+	case CreateRoomAction:
+		err = w.createRoom()
+	case DeleteRoomAction:
+		err = w.deleteRoom()
+	case JoinRoomAction:
+		err = w.joinRoom()
+	case ExitRoomAction:
+		err = w.exitRoom()
+	case SendMessageAction:
+		err = w.sendMessage(at)
+	case MessageToUserAction:
+		err = w.messageToUser(at)
+	case ListUsersAction:
+		_, err = w.listUsers()
+	case ListRoomsAction:
+		_, err = w.listRooms()
+	default:
+		return
+	}
+	w.stats.Action(action, at, err)
+	if err != nil {
+		log.Debugf("[%s] %s failed, err=%s", w.user.Name, action, err)
+	}
+}
+
+func (w *Worker) listUsers() (users []api.User, err error) {
+	err = w.stats.Call("GetUsers", func() (err error) {
+		users, err = w.client.GetUsers()
+		return err
+	})
+	return users, err
+}
+
+func (w *Worker) listRooms() (rooms []api.Room, err error) {
+	err = w.stats.Call("GetRooms", func() (err error) {
+		rooms, err = w.client.GetRooms()
+		return err
+	})
+	return rooms, err
+}
+
+func (w *Worker) createRoom() error {
+	name := RandString()
+	if err := w.stats.Call("RoomCreate", func() error { return w.client.RoomCreate(name) }); err != nil {
+		return err
+	}
+	w.lock.Lock()
+	w.created = append(w.created, name)
+	w.lock.Unlock()
+	return nil
+}
+
+func (w *Worker) deleteRoom() error {
+	w.lock.Lock()
+	if len(w.created) == 0 {
+		w.lock.Unlock()
+		return nil
+	}
+	i := w.rnd.Intn(len(w.created))
+	name := w.created[i]
+	w.created = append(w.created[:i], w.created[i+1:]...)
+	w.lock.Unlock()
+	w.tracker.Forget(name)
+	return w.stats.Call("RoomDelete", func() error { return w.client.RoomDelete(name) })
+}
+
+func (w *Worker) joinRoom() error {
+	rooms, err := w.listRooms()
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for _, room := range rooms {
+		if !contains(room.Users, w.user.Name) {
+			candidates = append(candidates, room.Name)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	w.lock.Lock()
+	name := candidates[w.rnd.Intn(len(candidates))]
+	w.lock.Unlock()
+	// messages of room posted while worker was away are not lost
+	w.tracker.Forget(name)
+	if err := w.stats.Call("RoomJoin", func() error { return w.client.RoomJoin(name) }); err != nil {
+		return err
+	}
+	w.lock.Lock()
+	w.joined = append(w.joined, name)
+	w.lock.Unlock()
+	return nil
+}
+
+func (w *Worker) exitRoom() error {
+	w.lock.Lock()
+	if len(w.joined) == 0 {
+		w.lock.Unlock()
+		return nil
+	}
+	i := w.rnd.Intn(len(w.joined))
+	name := w.joined[i]
+	w.joined = append(w.joined[:i], w.joined[i+1:]...)
+	w.lock.Unlock()
+	w.tracker.Forget(name)
+	return w.stats.Call("RoomExit", func() error { return w.client.RoomExit(name) })
+}
+
+// sendMessage sends message to personal, created or joined room
+func (w *Worker) sendMessage(at time.Time) error {
+	w.lock.Lock()
+	rooms := append([]string{w.user.Name}, w.created...)
+	rooms = append(rooms, w.joined...)
+	room := rooms[w.rnd.Intn(len(rooms))]
+	w.lock.Unlock()
+	return w.send(&api.Message{Room: room}, at)
+}
+
+// messageToUser sends direct message to random user
+func (w *Worker) messageToUser(at time.Time) error {
+	users, err := w.listUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	w.lock.Lock()
+	randomUser := users[w.rnd.Intn(len(users))]
+	w.lock.Unlock()
+	if randomUser.Name == "admin" || randomUser.Name == w.user.Name {
+		return nil
+	}
+	return w.send(&api.Message{To: randomUser.Name}, at)
+}
+
+// send sends message to room or directly to user, in open loop
+// message latency is counted from time action was scheduled at
+func (w *Worker) send(message *api.Message, at time.Time) error {
+	sent := time.Now()
+	if w.scenario.Mode == OpenLoop {
+		sent = at
+	}
+	message.Content = w.content(sent)
+	call, send := "SendMessage", w.client.SendMessage
+	if message.To != "" {
+		call, send = "SendDirect", w.client.SendDirect
+	}
+	if err := w.stats.Call(call, func() error { return send(message) }); err != nil {
+		return err
+	}
+	w.stats.Sent()
+	return nil
+}
+
+// content returns message content carrying send time
+// padded to size drawn from population distribution
+func (w *Worker) content(sent time.Time) string {
+	content := timestampContent(sent, w.user.Name)
+	w.lock.Lock()
+	size := int(w.population.MessageSize.Sample(w.rnd))
+	w.lock.Unlock()
+	if pad := size - len(content); pad > 0 {
+		content += strings.Repeat("x", pad)
+	}
+	return content
+}
+
+// receiver receives messages of worker user and records their latency
+// and sequence gaps found by tracker until done is closed
+func receiver(c *Config, client *api.Client, tracker *api.SequenceTracker, stats *Stats, done chan struct{}) {
+	record := func(msg *api.Message) {
+		gap, inOrder := tracker.Track(msg)
+		stats.Received(messageLatency(msg, time.Now()), gap, inOrder)
+	}
+
+	if c.Delivery == WebsocketDelivery {
+		var session *api.WebsocketSession
+		err := stats.Call("OpenWebsocket", func() (err error) {
+			session, err = client.OpenWebsocket(true)
+			return err
+		})
+		if err != nil {
+			log.Errorf("Failed to open websocket, err=%s", err)
+			return
+		}
+
+		defer session.Close()
+		for {
+			select {
+			case <-done:
+				return
+			case msg, ok := <-session.Messages():
+				if !ok {
+					return
+				}
+				record(msg)
+			case err := <-session.Errors():
+				_ = stats.Call("Websocket", func() error { return err })
+				log.Debugf("Websocket failed, err=%s", err)
+			}
+		}
+	}
+
+	if c.Delivery == SSEDelivery {
+		var stream *api.EventStream
+		err := stats.Call("OpenEvents", func() (err error) {
+			stream, err = client.OpenEvents(0, true)
+			return err
+		})
+		if err != nil {
+			log.Errorf("Failed to open event stream, err=%s", err)
+			return
+		}
+
+		defer stream.Close()
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-stream.Events():
+				if !ok {
+					return
+				}
+				// membership events are not measured
+				if event.Type == api.EventMessage {
+					record(event.Message)
+				}
+			case err := <-stream.Errors():
+				_ = stats.Call("Events", func() error { return err })
+				log.Debugf("Event stream failed, err=%s", err)
+			}
+		}
+	}
+
+	if c.Delivery == LongPollDelivery {
+		wait := time.Duration(c.PollWait) * time.Millisecond
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			var msgs []api.Message
+			err := stats.Call("ReceiveMessageWait", func() (err error) {
+				msgs, err = client.ReceiveMessageWait(wait, c.PollMax)
+				return err
+			})
+			if err != nil {
+				log.Debugf("Failed to receive messages, err=%s", err)
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Duration(c.PollInterval) * time.Millisecond):
+				}
+				continue
+			}
+			for i := range msgs {
+				record(&msgs[i])
+			}
+		}
+	}
+
+	pollT := time.NewTicker(time.Duration(c.PollInterval) * time.Millisecond)
+	defer pollT.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-pollT.C:
+		}
+
+		var msgs []api.Message
+		err := stats.Call("ReceiveMessage", func() (err error) {
+			msgs, err = client.ReceiveMessage()
+			return err
+		})
+		if err != nil {
+			log.Debugf("Failed to receive messages, err=%s", err)
+			continue
+		}
+		for i := range msgs {
+			record(&msgs[i])
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
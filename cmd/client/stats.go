@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+// latencyPrefix marks messages carrying send timestamp
+const latencyPrefix = "perfchat:"
+
+// Stats collects results of all workers
+type Stats struct {
+	sync.Mutex
+	start      time.Time
+	latency    *Histogram
+	calls      map[string]*callStats
+	actions    map[string]*callStats
+	sent       uint64
+	received   uint64
+	lost       uint64
+	outOfOrder uint64
+}
+
+// callStats are results of single API call
+type callStats struct {
+	latency *Histogram
+	errors  uint64
+}
+
+// NewStats returns empty stats with run starting now
+func NewStats() *Stats {
+	return &Stats{
+		start:   time.Now(),
+		latency: NewHistogram(),
+		calls:   make(map[string]*callStats),
+		actions: make(map[string]*callStats),
+	}
+}
+
+// Call runs API call, measures its time and counts errors
+func (s *Stats) Call(name string, call func() error) error {
+	start := time.Now()
+	err := call()
+	elapsed := time.Since(start)
+
+	s.Lock()
+	defer s.Unlock()
+	record(s.calls, name, elapsed, err)
+	return err
+}
+
+// Action records time since action was intended to start until it finished,
+// it includes time action waited for previous ones
+func (s *Stats) Action(name string, intended time.Time, err error) {
+	elapsed := time.Since(intended)
+
+	s.Lock()
+	defer s.Unlock()
+	record(s.actions, name, elapsed, err)
+}
+
+func record(stats map[string]*callStats, name string, elapsed time.Duration, err error) {
+	cs, ok := stats[name]
+	if !ok {
+		cs = &callStats{latency: NewHistogram()}
+		stats[name] = cs
+	}
+	cs.latency.Record(elapsed)
+	if err != nil {
+		cs.errors++
+	}
+}
+
+// Sent counts message sent by worker
+func (s *Stats) Sent() {
+	s.Lock()
+	defer s.Unlock()
+	s.sent++
+}
+
+// Received records latency of message and lost messages before it
+func (s *Stats) Received(latency time.Duration, lost uint64, inOrder bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.received++
+	s.lost += lost
+	if !inOrder {
+		s.outOfOrder++
+	}
+	if latency >= 0 {
+		s.latency.Record(latency)
+	}
+}
+
+// timestampContent returns message content carrying send time
+func timestampContent(sent time.Time, text string) string {
+	return fmt.Sprintf("%s%d|%s", latencyPrefix, sent.UnixNano(), text)
+}
+
+// messageLatency returns time since message was sent,
+// negative if message carries no timestamp
+func messageLatency(msg *api.Message, now time.Time) time.Duration {
+	if !strings.HasPrefix(msg.Content, latencyPrefix) {
+		return -1
+	}
+	content := strings.TrimPrefix(msg.Content, latencyPrefix)
+	if i := strings.Index(content, "|"); i >= 0 {
+		content = content[:i]
+	}
+	nanos, err := strconv.ParseInt(content, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return now.Sub(time.Unix(0, nanos))
+}
+
+// LatencyReport summarizes histogram, values are in microseconds
+type LatencyReport struct {
+	Count uint64 `json:"count"`
+	Min   int64  `json:"min_us"`
+	Mean  int64  `json:"mean_us"`
+	P50   int64  `json:"p50_us"`
+	P90   int64  `json:"p90_us"`
+	P99   int64  `json:"p99_us"`
+	P999  int64  `json:"p999_us"`
+	Max   int64  `json:"max_us"`
+}
+
+func newLatencyReport(h *Histogram) LatencyReport {
+	us := func(d time.Duration) int64 { return int64(d / time.Microsecond) }
+	return LatencyReport{
+		Count: h.Count(),
+		Min:   us(h.Min()),
+		Mean:  us(h.Mean()),
+		P50:   us(h.Quantile(0.5)),
+		P90:   us(h.Quantile(0.9)),
+		P99:   us(h.Quantile(0.99)),
+		P999:  us(h.Quantile(0.999)),
+		Max:   us(h.Max()),
+	}
+}
+
+// CallReport summarizes single API call or worker action
+type CallReport struct {
+	LatencyReport
+	Errors uint64 `json:"errors"`
+}
+
+// Report is machine readable result of run
+type Report struct {
+	Start          time.Time             `json:"start"`
+	Duration       float64               `json:"duration_s"`
+	Sent           uint64                `json:"messages_sent"`
+	Received       uint64                `json:"messages_received"`
+	Lost           uint64                `json:"messages_lost"`
+	OutOfOrder     uint64                `json:"messages_out_of_order"`
+	SentRate       float64               `json:"sent_per_s"`
+	ReceivedRate   float64               `json:"received_per_s"`
+	Errors         uint64                `json:"errors"`
+	MessageLatency LatencyReport         `json:"message_latency"`
+	Calls          map[string]CallReport `json:"calls"`
+	Actions        map[string]CallReport `json:"actions"`
+	Violations     []string              `json:"slo_violations"`
+}
+
+// Report returns results collected so far
+func (s *Stats) Report() *Report {
+	s.Lock()
+	defer s.Unlock()
+
+	duration := time.Since(s.start).Seconds()
+	report := &Report{
+		Start:          s.start,
+		Duration:       duration,
+		Sent:           s.sent,
+		Received:       s.received,
+		Lost:           s.lost,
+		OutOfOrder:     s.outOfOrder,
+		MessageLatency: newLatencyReport(s.latency),
+		Calls:          make(map[string]CallReport),
+		Actions:        make(map[string]CallReport),
+	}
+	if duration > 0 {
+		report.SentRate = float64(s.sent) / duration
+		report.ReceivedRate = float64(s.received) / duration
+	}
+	for name, cs := range s.calls {
+		report.Errors += cs.errors
+		report.Calls[name] = CallReport{
+			LatencyReport: newLatencyReport(cs.latency),
+			Errors:        cs.errors,
+		}
+	}
+	// action errors are already counted by calls
+	for name, cs := range s.actions {
+		report.Actions[name] = CallReport{
+			LatencyReport: newLatencyReport(cs.latency),
+			Errors:        cs.errors,
+		}
+	}
+	return report
+}
+
+// String returns short human readable summary
+func (r *Report) String() string {
+	return fmt.Sprintf("%.0fs sent=%d (%.1f/s) received=%d (%.1f/s) lost=%d out_of_order=%d errors=%d latency p50=%dus p99=%dus max=%dus",
+		r.Duration, r.Sent, r.SentRate, r.Received, r.ReceivedRate, r.Lost, r.OutOfOrder, r.Errors,
+		r.MessageLatency.P50, r.MessageLatency.P99, r.MessageLatency.Max)
+}
+
+// WriteJSON writes report to file as JSON
+func (r *Report) WriteJSON(path string) error {
+	payload, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, payload, 0644)
+}
+
+// WriteCSV writes latency of messages and API calls to file as CSV
+func (r *Report) WriteCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	row := func(name string, l LatencyReport, errors uint64) []string {
+		values := []int64{int64(l.Count), int64(errors), l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max}
+		record := []string{name}
+		for _, v := range values {
+			record = append(record, strconv.FormatInt(v, 10))
+		}
+		return record
+	}
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"name", "count", "errors", "min_us", "mean_us", "p50_us", "p90_us", "p99_us", "p999_us", "max_us"})
+	// lost messages are delivery errors
+	w.Write(row("message_latency", r.MessageLatency, r.Lost))
+
+	writeRows := func(prefix string, reports map[string]CallReport) {
+		var names []string
+		for name := range reports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			w.Write(row(prefix+name, reports[name].LatencyReport, reports[name].Errors))
+		}
+	}
+	writeRows("", r.Calls)
+	writeRows("action:", r.Actions)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
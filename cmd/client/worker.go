@@ -2,10 +2,29 @@ package main
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
-var randSrc = rand.New(rand.NewSource(time.Now().UnixNano()))
+var randSrc = rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano())})
+
+// lockedSource is rand source safe for use by many workers
+type lockedSource struct {
+	sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.src.Seed(seed)
+}
 
 const letterBytes = "1234567890abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/BurntSushi/toml"
+	api "github.com/phob0s-pl/perfchat/apiv1"
 )
 
 // Config represents server configuration
@@ -23,11 +24,88 @@ type Config struct {
 
 	// UsersLimit is maximum number of users in chat
 	UsersLimit uint
+
+	// Store selects storage backend, "memory" (default) or "file"
+	Store string
+
+	// StorePath is directory of file store
+	StorePath string
+
+	// StoreCompact is number of log entries after which file store is compacted
+	StoreCompact uint
+
+	// HistoryLimit is maximum number of messages kept in room history
+	HistoryLimit uint
+
+	// HistoryAge is time in seconds for how long messages are kept in room history
+	HistoryAge uint
+
+	// SessionSecret signs session tokens, random one is used when empty,
+	// so sessions don't survive restart
+	SessionSecret string
+
+	// SessionTTL is time in seconds for how long session token is valid
+	SessionTTL uint
+
+	// PasswordIterations is cost of hashing new tokens and room
+	// passwords, it is paid on every request authenticated by token
+	PasswordIterations uint
+
+	// TLSCert and TLSKey are paths of server certificate and key,
+	// when set server serves https and wss
+	TLSCert string
+	TLSKey  string
+
+	// TLSClientCA is path of CA bundle for client certificates,
+	// when set clients must authenticate with them (mutual TLS)
+	TLSClientCA string
+
+	// DrainTimeout is time in seconds given to shutdown for finishing
+	// requests, closing websockets and flushing store
+	DrainTimeout uint
+
+	// UserRate is number of requests per second allowed for every user
+	// and UserBurst is number of requests allowed at once, zero rate
+	// disables limit, the same goes for GlobalRate of all requests
+	UserRate    float64
+	UserBurst   uint
+	GlobalRate  float64
+	GlobalBurst uint
+
+	// PollQueue and WebsocketQueue are numbers of messages queued for
+	// every subscriber of transport, PollOverflow and WebsocketOverflow
+	// are applied when queue is full: "drop-oldest", "drop-newest"
+	// (default) or "disconnect"
+	PollQueue         uint
+	PollOverflow      string
+	WebsocketQueue    uint
+	WebsocketOverflow string
+
+	// EventLog is number of events kept for every user of SSE stream,
+	// stream resumed with older Last-Event-ID misses events
+	EventLog uint
+
+	// RouteRates limit requests of every user on routes, keyed by route name
+	RouteRates map[string]api.RateLimit
+
+	// ClusterNode is address other cluster nodes reach this server at
+	// and ClusterPeers are addresses of all nodes, cluster is enabled
+	// when peers are set, nodes must share peers, ClusterSecret
+	// and SessionSecret, load balancer should keep users on one node
+	ClusterNode   string
+	ClusterPeers  []string
+	ClusterSecret string
+
+	// ClusterCA is path of CA bundle verifying certificates of peers,
+	// nodes talk https when TLSCert is set, empty uses system roots
+	ClusterCA string
 }
 
 // ReadConfig reads config from file
 func ReadConfig(path string) (*Config, error) {
-	cfg := &Config{}
+	cfg := &Config{
+		DrainTimeout: 10,
+	}
 	_, err := toml.DecodeFile(path, cfg)
 	return cfg, err
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -15,6 +19,8 @@ import (
 const (
 	// ConfigPath is default configuration path
 	ConfigPath = "server.conf"
+	// writeTimeout limits writing of response, also long poll
+	writeTimeout = 10 * time.Second
 )
 
 func init() {
@@ -23,14 +29,20 @@ func init() {
 }
 
 func main() {
-	var (
-		serverAPI = api.NewAPI()
-		router    = NewRouter()
-	)
+	router := NewRouter()
 
 	configPath := flag.String("conf", ConfigPath, "path to config file")
+	certsDir := flag.String("gencerts", "", "generate self-signed certificates for local tests into directory and exit")
+	certsHosts := flag.String("hosts", "localhost,127.0.0.1", "comma separated hosts of generated server certificate")
 	flag.Parse()
 
+	if *certsDir != "" {
+		if err := api.GenerateCertificates(*certsDir, strings.Split(*certsHosts, ",")); err != nil {
+			log.Fatalf("Failed to generate certificates, err=%s", err)
+		}
+		return
+	}
+
 	config, err := ReadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to read config, err=%s", err)
@@ -41,20 +53,112 @@ func main() {
 	}
 	log.Debugf("Read configuration from %q: %+v", *configPath, config)
 
+	if config.PasswordIterations != 0 {
+		chat.PasswordIterations = int(config.PasswordIterations)
+	}
+
+	store, err := NewStore(config)
+	if err != nil {
+		log.Fatalf("Failed to open store, err=%s", err)
+	}
+
+	engine, err := chat.NewChatWithStore(store)
+	if err != nil {
+		log.Fatalf("Failed to restore chat, err=%s", err)
+	}
+	serverAPI := api.NewAPIWithEngine(engine)
+
 	serverAPI.Engine.SetRoomLimit(config.RoomLimit)
 	serverAPI.Engine.SetUsersLimit(config.UsersLimit)
+	serverAPI.SetHistoryLimits(config.HistoryLimit, time.Duration(config.HistoryAge)*time.Second)
+	serverAPI.SetSessions(config.SessionSecret, time.Duration(config.SessionTTL)*time.Second)
+	if err := serverAPI.SetQueue(api.PollTransport, api.QueueConfig{Size: config.PollQueue, Overflow: config.PollOverflow}); err != nil {
+		log.Fatalf("Failed to configure poll queues, err=%s", err)
+	}
+	if err := serverAPI.SetQueue(api.WebsocketTransport, api.QueueConfig{Size: config.WebsocketQueue, Overflow: config.WebsocketOverflow}); err != nil {
+		log.Fatalf("Failed to configure websocket queues, err=%s", err)
+	}
+	serverAPI.SetEventLog(config.EventLog)
+	// poll must answer before write timeout cuts response
+	serverAPI.SetMaxPollWait(writeTimeout - time.Second)
+	serverAPI.SetRateLimits(api.RateLimits{
+		Global: api.RateLimit{Rate: config.GlobalRate, Burst: config.GlobalBurst},
+		User:   api.RateLimit{Rate: config.UserRate, Burst: config.UserBurst},
+		Routes: config.RouteRates,
+	})
 
 	// Register all API calls
-	AddAPI(router, serverAPI.AddUserRoute())
-	AddAPI(router, serverAPI.PingRoute())
-	AddAPI(router, serverAPI.GetUsersRoute())
-	AddAPI(router, serverAPI.GetRoomsRoute())
-	AddAPI(router, serverAPI.CreateRoomRoute())
-	AddAPI(router, serverAPI.JoinRoomRoute())
-	AddAPI(router, serverAPI.ExitRoomRoute())
-	AddAPI(router, serverAPI.SendMessageRoute())
-	AddAPI(router, serverAPI.ReceiveMessageRoute())
-	AddAPI(router, serverAPI.StatsRoute())
+	routes := []*api.Route{
+		serverAPI.AddUserRoute(),
+		serverAPI.DeleteUserRoute(),
+		serverAPI.UpdateUserRoute(),
+		serverAPI.SuspendUserRoute(),
+		serverAPI.ResumeUserRoute(),
+		serverAPI.LoginRoute(),
+		serverAPI.LogoutRoute(),
+		serverAPI.PingRoute(),
+		serverAPI.GetUsersRoute(),
+		serverAPI.GetRoomsRoute(),
+		serverAPI.CreateRoomRoute(),
+		serverAPI.DeleteRoomRoute(),
+		serverAPI.JoinRoomRoute(),
+		serverAPI.ExitRoomRoute(),
+		serverAPI.SendMessageRoute(),
+		serverAPI.ReceiveMessageRoute(),
+		serverAPI.RoomMessagesRoute(),
+		serverAPI.RoomKickRoute(),
+		serverAPI.RoomBanRoute(),
+		serverAPI.RoomUnbanRoute(),
+		serverAPI.RoomMuteRoute(),
+		serverAPI.RoomPromoteRoute(),
+		serverAPI.RoomDemoteRoute(),
+		serverAPI.RoomTransferRoute(),
+		serverAPI.RoomInviteRoute(),
+		serverAPI.RoomAcceptRoute(),
+		serverAPI.GetInvitesRoute(),
+		serverAPI.ReceiveDirectRoute(),
+		serverAPI.DirectMessagesRoute(),
+		serverAPI.GetUnreadRoute(),
+		serverAPI.StatsRoute(),
+		serverAPI.WebsocketRoute(),
+		serverAPI.EventsRoute(),
+		serverAPI.MetricsRoute(),
+	}
+	for _, route := range routes {
+		AddAPI(router, serverAPI.Instrument(serverAPI.RateLimit(route)))
+	}
+	// requests between cluster nodes are not limited
+	for _, route := range []*api.Route{
+		serverAPI.ClusterEventsRoute(),
+		serverAPI.ClusterPostRoute(),
+		serverAPI.ClusterRoomRoute(),
+		serverAPI.ClusterUserRoute(),
+		serverAPI.ClusterStateRoute(),
+	} {
+		AddAPI(router, serverAPI.Instrument(route))
+	}
+
+	var cluster *api.Cluster
+	if len(config.ClusterPeers) > 0 {
+		var clusterTLS *tls.Config
+		if config.TLSCert != "" {
+			if clusterTLS, err = api.NewClientTLSConfig(config.ClusterCA, config.TLSCert, config.TLSKey); err != nil {
+				log.Fatalf("Failed to load cluster TLS config, err=%s", err)
+			}
+		}
+		cluster, err = serverAPI.SetCluster(api.ClusterConfig{
+			Node:   config.ClusterNode,
+			Peers:  config.ClusterPeers,
+			Secret: config.ClusterSecret,
+			TLS:    clusterTLS,
+		})
+		if err != nil {
+			log.Fatalf("Failed to join cluster, err=%s", err)
+		}
+		if err := cluster.Sync(); err != nil {
+			log.Warnf("Starting cluster without state of peers, err=%s", err)
+		}
+	}
 
 	admin := &chat.User{
 		AuthID: config.AuthID,
@@ -62,22 +166,51 @@ func main() {
 		Role:   chat.AdminRole,
 		Token:  config.Token,
 	}
-	if err := serverAPI.Engine.AddUser(admin); err != nil {
+	if err := serverAPI.Engine.AddUser(admin); err != nil && err != chat.ErrExists {
 		log.Fatalf("Failed to add admin, err=%s", err)
 	}
 
 	srv := &http.Server{
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 		Handler:      router,
 		Addr:         config.Address,
 	}
 
-	log.Infof("Serving at %s", config.Address)
+	if config.TLSCert != "" {
+		if srv.TLSConfig, err = api.NewServerTLSConfig(config.TLSClientCA); err != nil {
+			log.Fatalf("Failed to load TLS config, err=%s", err)
+		}
+	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	served := make(chan error, 1)
+	go func() {
+		if config.TLSCert != "" {
+			log.Infof("Serving TLS at %s", config.Address)
+			served <- srv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+			return
+		}
+		log.Infof("Serving at %s", config.Address)
+		served <- srv.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		log.Warnf("Received %s, shutting down", sig)
+	case err := <-served:
 		log.Errorf("Failed to serve: %s", err)
 	}
+
+	drain := time.Duration(config.DrainTimeout) * time.Second
+	if err := Shutdown(srv, serverAPI, drain); err != nil {
+		log.Errorf("Shutdown not clean, err=%s", err)
+	}
+	if cluster != nil {
+		cluster.Close()
+	}
+	printSummary(serverAPI)
 }
 
 func AddAPI(router *mux.Router, route *api.Route) {
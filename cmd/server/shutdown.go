@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+// Shutdown stops accepting connections, ends event streams and long
+// polls, waits for requests in flight, closes websockets after flushing their messages
+// and closes chat store,
+// all of it must finish within drain timeout, then server is closed forcibly
+func Shutdown(srv *http.Server, serverAPI *api.API, drain time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	// event streams last until they are ended, so they would hold shutdown
+	srv.RegisterOnShutdown(serverAPI.CloseEventStreams)
+	// waiting polls would use up drain timeout
+	srv.RegisterOnShutdown(serverAPI.ClosePolls)
+
+	// store is closed even when earlier steps fail, so nothing is lost
+	done := make(chan error, 1)
+	go func() {
+		var errs []string
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("http: %s", err))
+		}
+		if err := serverAPI.CloseWebsockets(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("websockets: %s", err))
+		}
+		if err := serverAPI.Engine.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("store: %s", err))
+		}
+		if len(errs) > 0 {
+			done <- errors.New(strings.Join(errs, ", "))
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			srv.Close()
+		}
+		return err
+	case <-ctx.Done():
+		srv.Close()
+		return fmt.Errorf("drain timeout %s exceeded", drain)
+	}
+}
+
+// printSummary prints final server stats
+func printSummary(serverAPI *api.API) {
+	payload, err := json.MarshalIndent(serverAPI.StatsSnapshot(), "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Printf("Final stats:\n%s\n", payload)
+	if pending := serverAPI.PendingMessages(); pending > 0 {
+		fmt.Printf("Messages not polled before shutdown: %d\n", pending)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+const (
+	// MemoryStore keeps chat state only until server exits
+	MemoryStore = "memory"
+	// FileStore keeps chat state in StorePath directory
+	FileStore = "file"
+)
+
+// NewStore returns chat store selected in config
+func NewStore(config *Config) (chat.Store, error) {
+	switch config.Store {
+	case "", MemoryStore:
+		return chat.NewMemoryStore(), nil
+	case FileStore:
+		if config.StorePath == "" {
+			return nil, fmt.Errorf("StorePath is required for %q store", FileStore)
+		}
+		store, err := chat.NewFileStore(config.StorePath)
+		if err != nil {
+			return nil, err
+		}
+		store.SetCompactAfter(config.StoreCompact)
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown store %q", config.Store)
+}
@@ -1,6 +1,11 @@
+variable "server_count" {
+  description = "Number of perfchat server nodes in cluster"
+  default     = "1"
+}
+
 resource "google_compute_instance" "aserver" {
-  count        = "1"
-  name         = "aserver"
+  count        = "${var.server_count}"
+  name         = "aserver-${count.index}"
   machine_type = "g1-small"
   zone         = "europe-west1-b"
 
@@ -14,7 +19,7 @@ boot_disk {
 
   metadata {
     ssh-keys = "jakubj00:${file("~/.ssh/gcpx.pub")}"
-    hostname = "aserver"
+    hostname = "aserver-${count.index}"
   }
 
   can_ip_forward = true
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func roomVisible(t *testing.T, client *api.Client, name string) bool {
+	rooms, err := client.GetRooms()
+	if err != nil {
+		t.Errorf("GetRooms failed, err=%s", err)
+	}
+	for _, room := range rooms {
+		if room.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRoomAccess(t *testing.T) {
+	var (
+		address     = "localhost:9171"
+		server      = NewServer(address)
+		owner       = newManagedUser("owner")
+		guest       = newManagedUser("guest")
+		adminClient = api.NewClient(admin, address)
+		ownerC      = api.NewClient(owner, address)
+		guestC      = api.NewClient(guest, address)
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	for _, user := range []*chat.User{owner, guest} {
+		if err := adminClient.AddUser(user); err != nil {
+			t.Errorf("AddUser(%s) failed, err=%s", user.Name, err)
+		}
+	}
+
+	// personal inbox
+	if roomVisible(t, guestC, owner.Name) {
+		t.Errorf("Personal room of %s should be hidden from %s", owner.Name, guest.Name)
+	}
+	if !roomVisible(t, ownerC, owner.Name) {
+		t.Errorf("Personal room should be visible to its owner")
+	}
+	if err := guestC.RoomJoin(owner.Name); err == nil {
+		t.Errorf("Joining personal room of other user should fail")
+	}
+	if err := guestC.SendMessage(&api.Message{Room: owner.Name, Content: "hi"}); err != nil {
+		t.Errorf("Posting to personal room should succeed, err=%s", err)
+	}
+	if _, err := guestC.RoomMessages(owner.Name, 0, 0, 0); err == nil {
+		t.Errorf("Reading personal room of other user should fail")
+	}
+	if err := ownerC.RoomCreateWithAccess("fake", chat.PersonalAccess, ""); err == nil {
+		t.Errorf("Creating personal room should fail")
+	}
+
+	// invite-only room
+	if err := ownerC.RoomCreateWithAccess("secret", chat.InviteAccess, ""); err != nil {
+		t.Errorf("RoomCreateWithAccess(invite) failed, err=%s", err)
+	}
+	if roomVisible(t, guestC, "secret") {
+		t.Errorf("Invite-only room should be hidden before invite")
+	}
+	if err := guestC.RoomJoin("secret"); err == nil {
+		t.Errorf("Joining invite-only room without invite should fail")
+	}
+	if err := guestC.SendMessage(&api.Message{Room: "secret", Content: "hi"}); err == nil {
+		t.Errorf("Posting to invite-only room without joining should fail")
+	}
+	if err := guestC.RoomAccept("secret"); err == nil {
+		t.Errorf("Accepting missing invite should fail")
+	}
+	if err := ownerC.RoomInvite("secret", guest.Name); err != nil {
+		t.Errorf("RoomInvite failed, err=%s", err)
+	}
+	if !roomVisible(t, guestC, "secret") {
+		t.Errorf("Invite-only room should be visible to invited user")
+	}
+	invites, err := guestC.GetInvites()
+	if err != nil || len(invites) != 1 || invites[0] != "secret" {
+		t.Errorf("GetInvites, got %v, err=%v, expected [secret]", invites, err)
+	}
+	if err := guestC.RoomAccept("secret"); err != nil {
+		t.Errorf("RoomAccept failed, err=%s", err)
+	}
+	if invites, _ := guestC.GetInvites(); len(invites) != 0 {
+		t.Errorf("Invite should be used up, got %v", invites)
+	}
+	if err := guestC.SendMessage(&api.Message{Room: "secret", Content: "hi"}); err != nil {
+		t.Errorf("Member posting to invite-only room failed, err=%s", err)
+	}
+	if !roomVisible(t, adminClient, "secret") {
+		t.Errorf("Invite-only room should be visible to staff")
+	}
+
+	// password protected room
+	if err := ownerC.RoomCreateWithAccess("vault", chat.PasswordAccess, ""); err == nil {
+		t.Errorf("Creating password room without password should fail")
+	}
+	if err := ownerC.RoomCreateWithAccess("vault", chat.PasswordAccess, "sesame"); err != nil {
+		t.Errorf("RoomCreateWithAccess(password) failed, err=%s", err)
+	}
+	if !roomVisible(t, guestC, "vault") {
+		t.Errorf("Password room should be visible")
+	}
+	if err := guestC.RoomJoinWithPassword("vault", "wrong"); err == nil {
+		t.Errorf("Joining with wrong password should fail")
+	}
+	if err := guestC.RoomJoinWithPassword("vault", "sesame"); err != nil {
+		t.Errorf("Joining with password failed, err=%s", err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -87,8 +87,8 @@ func TestAddSingleUser(t *testing.T) {
 		t.Fatalf("User Role expected %q, got %q", dummyuser.Role, user.Role)
 	}
 
-	if dummyuser.Token != user.Token {
-		t.Fatalf("User name expected %q, got %q", dummyuser.Token, user.Token)
+	if user.Token != "" || !user.Password.Matches(dummyuser.Token) {
+		t.Fatalf("User token should be kept hashed, got %q", user.Token)
 	}
 
 }
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+func TestQueueOverflow(t *testing.T) {
+	var (
+		address = "localhost:9251"
+		server  = NewServer(address)
+		theta   = newManagedUser("theta")
+		client  = api.NewClient(theta, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(theta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := client.RoomCreate("overflow"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	// user is subscribed by first poll
+	if _, err := client.ReceiveMessage(); err != nil {
+		t.Fatalf("ReceiveMessage failed, err=%s", err)
+	}
+
+	tests := []struct {
+		policy   string
+		expected []string
+	}{
+		{api.DropNewest, []string{"1", "2"}},
+		{api.DropOldest, []string{"2", "3"}},
+		{api.Disconnect, nil},
+	}
+	for _, test := range tests {
+		if err := server.API.SetQueue(api.PollTransport, api.QueueConfig{Size: 2, Overflow: test.policy}); err != nil {
+			t.Fatalf("SetQueue(%s) failed, err=%s", test.policy, err)
+		}
+		for _, content := range []string{"1", "2", "3"} {
+			if err := client.SendMessage(&api.Message{Room: "overflow", Content: content}); err != nil {
+				t.Fatalf("SendMessage failed, err=%s", err)
+			}
+		}
+		messages, err := client.ReceiveMessage()
+		if err != nil {
+			t.Fatalf("ReceiveMessage failed, err=%s", err)
+		}
+		if len(messages) != len(test.expected) {
+			t.Errorf("%s: got %d messages, expected %d", test.policy, len(messages), len(test.expected))
+			continue
+		}
+		for i, msg := range messages {
+			if msg.Content != test.expected[i] {
+				t.Errorf("%s: got message %q, expected %q", test.policy, msg.Content, test.expected[i])
+			}
+		}
+	}
+
+	// subscription of slow consumer is opened again by next poll
+	if err := client.SendMessage(&api.Message{Room: "overflow", Content: "4"}); err != nil {
+		t.Fatalf("SendMessage failed, err=%s", err)
+	}
+	if messages, err := client.ReceiveMessage(); err != nil || len(messages) != 1 {
+		t.Errorf("Poll after disconnect got %d messages, err=%v", len(messages), err)
+	}
+
+	stats := server.API.StatsSnapshot()
+	if stats.MessagesDropped != 2 || stats.SlowDisconnects != 1 {
+		t.Errorf("Got %d dropped and %d disconnected, expected 2 and 1", stats.MessagesDropped, stats.SlowDisconnects)
+	}
+	if err := server.API.SetQueue(api.PollTransport, api.QueueConfig{Overflow: "unknown"}); err == nil {
+		t.Errorf("Unknown overflow policy should fail")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestPushOnlyQueue(t *testing.T) {
+	var (
+		address = "localhost:9252"
+		server  = NewServer(address)
+		pushy   = newManagedUser("pushy")
+		client  = api.NewClient(pushy, address)
+		adminC  = api.NewClient(admin, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := server.API.SetQueue(api.PollTransport, api.QueueConfig{Size: 2}); err != nil {
+		t.Fatalf("SetQueue failed, err=%s", err)
+	}
+	if err := adminC.AddUser(pushy); err != nil {
+		t.Fatalf("AddUser failed, err=%s", err)
+	}
+	if err := client.RoomCreate("pushonly"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	session, err := client.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket failed, err=%s", err)
+	}
+	defer session.Close()
+
+	// user who never polls has no poll queue to overflow
+	for i := 0; i < 5; i++ {
+		if err := client.SendMessage(&api.Message{Room: "pushonly", Content: "pushed"}); err != nil {
+			t.Fatalf("SendMessage failed, err=%s", err)
+		}
+		select {
+		case <-session.Messages():
+		case <-time.After(time.Second):
+			t.Fatalf("Websocket got %d of 5 messages", i)
+		}
+	}
+	if stats := server.API.StatsSnapshot(); stats.MessagesDropped != 0 || stats.SlowDisconnects != 0 {
+		t.Errorf("Got %d dropped and %d disconnected, expected none", stats.MessagesDropped, stats.SlowDisconnects)
+	}
+	if pending := server.API.PendingMessages(); pending != 0 {
+		t.Errorf("Got %d messages pending for poll, expected none", pending)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
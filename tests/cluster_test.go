@@ -0,0 +1,720 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// eventually checks condition until it holds or second passes
+func eventually(condition func() bool) bool {
+	return eventuallyWithin(time.Second, condition)
+}
+
+// eventuallyWithin checks condition until it holds or timeout passes
+func eventuallyWithin(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return condition()
+}
+
+// listening checks that servers at addresses answer
+func listening(addresses []string) bool {
+	return eventually(func() bool {
+		for _, address := range addresses {
+			if api.NewClient(admin, address).Ping() != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestCluster(t *testing.T) {
+	var (
+		addresses = []string{"localhost:9261", "localhost:9262", "localhost:9263"}
+		servers   []*Server
+		done      = make(chan bool, len(addresses))
+		lambda    = newManagedUser("lambda")
+		mu        = newManagedUser("mu")
+	)
+	for _, address := range addresses {
+		server := NewServer(address)
+		cluster, err := server.API.SetCluster(api.ClusterConfig{
+			Node:   address,
+			Peers:  addresses,
+			Secret: "cluster secret",
+		})
+		if err != nil {
+			t.Fatalf("SetCluster failed, err=%s", err)
+		}
+		defer cluster.Close()
+		servers = append(servers, server)
+
+		go func() {
+			if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+				t.Errorf("Server failed, err=%s", err)
+			}
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every node adds admin at start, like server does
+	for _, server := range servers {
+		server.API.Engine.AddUser(admin)
+	}
+	if !listening(addresses) {
+		t.Fatalf("Nodes not listening")
+	}
+
+	adminClient := api.NewClient(admin, addresses[0])
+	if err := adminClient.AddUser(lambda); err != nil {
+		t.Fatalf("AddUser failed, err=%s", err)
+	}
+	if err := adminClient.AddUser(mu); err != nil {
+		t.Fatalf("AddUser failed, err=%s", err)
+	}
+
+	lambdaClient := api.NewClient(lambda, addresses[1])
+	muPoll := api.NewClient(mu, addresses[2])
+	if !eventually(func() bool { return servers[1].API.Engine.UserExists(lambda.Name) }) {
+		t.Fatalf("Users not replicated to other nodes")
+	}
+
+	if err := lambdaClient.RoomCreate("cluster"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	if !eventually(func() bool { return muPoll.RoomJoin("cluster") == nil }) {
+		t.Fatalf("Room not replicated to other node")
+	}
+	if !eventually(func() bool {
+		room, err := servers[1].API.Engine.GetRoomByName("cluster")
+		return err == nil && room.HasUser(mu.Name)
+	}) {
+		t.Fatalf("Membership not replicated to other node")
+	}
+
+	muWebsocket, err := api.NewClient(mu, addresses[0]).OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket failed, err=%s", err)
+	}
+	defer muWebsocket.Close()
+
+	// first poll subscribes
+	if _, err := muPoll.ReceiveMessage(); err != nil {
+		t.Fatalf("ReceiveMessage failed, err=%s", err)
+	}
+
+	sent := &api.Message{Room: "cluster", Content: "across nodes"}
+	if err := lambdaClient.SendMessage(sent); err != nil {
+		t.Fatalf("SendMessage failed, err=%s", err)
+	}
+
+	select {
+	case msg := <-muWebsocket.Messages():
+		if msg.Content != sent.Content || msg.User != lambda.Name {
+			t.Errorf("Websocket on other node got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Websocket on other node got no message")
+	}
+
+	var polled []api.Message
+	eventually(func() bool {
+		messages, err := muPoll.ReceiveMessage()
+		polled = append(polled, messages...)
+		return err == nil && len(polled) > 0
+	})
+	if len(polled) != 1 || polled[0].Content != sent.Content {
+		t.Errorf("Poll on other node got %+v", polled)
+	}
+
+	// every node keeps the same history with IDs given by room owner
+	for i, address := range addresses {
+		page, err := api.NewClient(mu, address).RoomMessages("cluster", 0, 0, 10)
+		if err != nil || len(page) != 1 || len(polled) != 1 || page[0].ID != polled[0].ID {
+			t.Errorf("History of node %d is %+v, err=%v", i, page, err)
+		}
+	}
+
+	if err := lambdaClient.SendDirect(&api.Message{To: mu.Name, Content: "direct"}); err != nil {
+		t.Fatalf("SendDirect failed, err=%s", err)
+	}
+	if !eventually(func() bool {
+		unread, err := muPoll.GetUnread()
+		return err == nil && unread[lambda.Name] == 1
+	}) {
+		t.Errorf("Direct message not counted on other node")
+	}
+
+	if err := lambdaClient.RoomDelete("cluster"); err != nil {
+		t.Fatalf("RoomDelete failed, err=%s", err)
+	}
+	if !eventually(func() bool { return !servers[2].API.Engine.RoomExists("cluster") }) {
+		t.Errorf("Room deletion not replicated to other node")
+	}
+
+	if err := adminClient.DeleteUser(mu.Name); err != nil {
+		t.Fatalf("DeleteUser failed, err=%s", err)
+	}
+	if !eventually(func() bool { return !servers[2].API.Engine.UserExists(mu.Name) }) {
+		t.Errorf("User deletion not replicated to other node")
+	}
+
+	for _, server := range servers {
+		if err := server.Srv.Close(); err != nil {
+			t.Errorf("Closing server failed, err=%s", err)
+		}
+		<-done
+	}
+}
+
+// roomMembers returns sorted names of room members on node
+func roomMembers(server *Server, name string) []string {
+	room, err := server.API.Engine.GetRoomByName(name)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, user := range room.Users {
+		names = append(names, user.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestClusterConcurrentMembership(t *testing.T) {
+	var (
+		addresses = []string{"localhost:9264", "localhost:9265"}
+		servers   []*Server
+		done      = make(chan bool, len(addresses))
+		users     []*chat.User
+	)
+	for _, address := range addresses {
+		server := NewServer(address)
+		cluster, err := server.API.SetCluster(api.ClusterConfig{
+			Node:   address,
+			Peers:  addresses,
+			Secret: "cluster secret",
+		})
+		if err != nil {
+			t.Fatalf("SetCluster failed, err=%s", err)
+		}
+		defer cluster.Close()
+		servers = append(servers, server)
+
+		go func() {
+			if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+				t.Errorf("Server failed, err=%s", err)
+			}
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every node adds admin at start, like server does
+	for _, server := range servers {
+		server.API.Engine.AddUser(admin)
+	}
+	if !listening(addresses) {
+		t.Fatalf("Nodes not listening")
+	}
+	adminClient := api.NewClient(admin, addresses[0])
+	for i := 0; i < 40; i++ {
+		user := newManagedUser(fmt.Sprintf("crowd%d", i))
+		if err := adminClient.AddUser(user); err != nil {
+			t.Fatalf("AddUser failed, err=%s", err)
+		}
+		users = append(users, user)
+	}
+	if !eventually(func() bool { return servers[1].API.Engine.UserExists(users[len(users)-1].Name) }) {
+		t.Fatalf("Users not replicated to other node")
+	}
+
+	if err := api.NewClient(users[0], addresses[1]).RoomCreate("crowd"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+
+	// members join on alternating nodes at once, then every second
+	// one leaves through the other node while others keep joining
+	var wg sync.WaitGroup
+	for i, user := range users[1:] {
+		wg.Add(1)
+		go func(i int, user *chat.User) {
+			defer wg.Done()
+			if err := api.NewClient(user, addresses[i%2]).RoomJoin("crowd"); err != nil {
+				t.Errorf("RoomJoin of %s failed, err=%s", user.Name, err)
+				return
+			}
+			if i%2 == 0 {
+				if err := api.NewClient(user, addresses[(i+1)%2]).RoomExit("crowd"); err != nil {
+					t.Errorf("RoomExit of %s failed, err=%s", user.Name, err)
+				}
+			}
+		}(i, user)
+	}
+	wg.Wait()
+
+	expected := []string{users[0].Name}
+	for i, user := range users[1:] {
+		if i%2 != 0 {
+			expected = append(expected, user.Name)
+		}
+	}
+	sort.Strings(expected)
+	for i, server := range servers {
+		if !eventually(func() bool { return fmt.Sprint(roomMembers(server, "crowd")) == fmt.Sprint(expected) }) {
+			t.Errorf("Node %d has members %v, expected %v", i, roomMembers(server, "crowd"), expected)
+		}
+	}
+
+	for _, server := range servers {
+		if err := server.Srv.Close(); err != nil {
+			t.Errorf("Closing server failed, err=%s", err)
+		}
+		<-done
+	}
+}
+
+func TestClusterJoinAfterAdd(t *testing.T) {
+	var (
+		addresses = []string{"localhost:9266", "localhost:9267"}
+		servers   []*Server
+		done      = make(chan bool, len(addresses))
+		rooms     = []string{"hall0", "hall1", "hall2", "hall3"}
+	)
+	for _, address := range addresses {
+		server := NewServer(address)
+		cluster, err := server.API.SetCluster(api.ClusterConfig{
+			Node:   address,
+			Peers:  addresses,
+			Secret: "cluster secret",
+		})
+		if err != nil {
+			t.Fatalf("SetCluster failed, err=%s", err)
+		}
+		defer cluster.Close()
+		servers = append(servers, server)
+
+		// events reach nodes late, like on busy network
+		handler := server.Srv.Handler
+		server.Srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == api.GetPath(api.ClusterEventsCall) {
+				time.Sleep(time.Millisecond * 100)
+			}
+			handler.ServeHTTP(w, r)
+		})
+
+		go func() {
+			if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+				t.Errorf("Server failed, err=%s", err)
+			}
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every node adds admin at start, like server does
+	for _, server := range servers {
+		server.API.Engine.AddUser(admin)
+	}
+	if !listening(addresses) {
+		t.Fatalf("Nodes not listening")
+	}
+	adminClient := api.NewClient(admin, addresses[0])
+	for _, room := range rooms {
+		if err := adminClient.RoomCreate(room); err != nil {
+			t.Fatalf("RoomCreate(%s) failed, err=%s", room, err)
+		}
+	}
+
+	// rooms owned by other node get changes of users
+	// before these users reach it
+	for i := 0; i < 5; i++ {
+		user := newManagedUser(fmt.Sprintf("newcomer%d", i))
+		if err := adminClient.AddUser(user); err != nil {
+			t.Fatalf("AddUser failed, err=%s", err)
+		}
+		if err := adminClient.RoomInvite(rooms[1], user.Name); err != nil {
+			t.Errorf("RoomInvite(%s) of %s right after adding failed, err=%s", rooms[1], user.Name, err)
+		}
+		client := api.NewClient(user, addresses[0])
+		for _, room := range rooms {
+			if err := client.RoomJoin(room); err != nil {
+				t.Errorf("RoomJoin(%s) of %s right after adding failed, err=%s", room, user.Name, err)
+			}
+		}
+	}
+
+	for _, server := range servers {
+		if err := server.Srv.Close(); err != nil {
+			t.Errorf("Closing server failed, err=%s", err)
+		}
+		<-done
+	}
+}
+
+// nodeState describes users and rooms of node, so nodes can be compared
+func nodeState(server *Server) string {
+	var users, rooms []string
+	for _, user := range server.API.Engine.ListUsers() {
+		users = append(users, fmt.Sprintf("%s/%s/%t", user.Name, user.DisplayName, user.Suspended))
+	}
+	for _, room := range server.API.Engine.ListRooms() {
+		rooms = append(rooms, fmt.Sprint(room.Name, roomMembers(server, room.Name)))
+	}
+	sort.Strings(users)
+	sort.Strings(rooms)
+	return fmt.Sprint(users, rooms)
+}
+
+func TestClusterResync(t *testing.T) {
+	var (
+		addresses = []string{"localhost:9268", "localhost:9269"}
+		servers   []*Server
+		done      = make(chan bool, len(addresses))
+		blocked   atomic.Bool
+		users     []*chat.User
+		rooms     = []string{"resync0", "resync1", "resync2", "resync3"}
+	)
+	for i, address := range addresses {
+		server := NewServer(address)
+		cluster, err := server.API.SetCluster(api.ClusterConfig{
+			Node:   address,
+			Peers:  addresses,
+			Secret: "cluster secret",
+		})
+		if err != nil {
+			t.Fatalf("SetCluster failed, err=%s", err)
+		}
+		defer cluster.Close()
+		servers = append(servers, server)
+
+		// second node can be cut off from events
+		if i == 1 {
+			handler := server.Srv.Handler
+			server.Srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if blocked.Load() && r.URL.Path == api.GetPath(api.ClusterEventsCall) {
+					http.Error(w, "blocked", http.StatusServiceUnavailable)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			})
+		}
+
+		go func() {
+			if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+				t.Errorf("Server failed, err=%s", err)
+			}
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every node adds admin at start, like server does
+	for _, server := range servers {
+		server.API.Engine.AddUser(admin)
+	}
+	if !listening(addresses) {
+		t.Fatalf("Nodes not listening")
+	}
+	adminClient := api.NewClient(admin, addresses[0])
+	for _, room := range rooms {
+		if err := adminClient.RoomCreate(room); err != nil {
+			t.Fatalf("RoomCreate(%s) failed, err=%s", room, err)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		user := newManagedUser(fmt.Sprintf("resynced%d", i))
+		if err := adminClient.AddUser(user); err != nil {
+			t.Fatalf("AddUser failed, err=%s", err)
+		}
+		for _, room := range rooms {
+			if err := api.NewClient(user, addresses[0]).RoomJoin(room); err != nil {
+				t.Fatalf("RoomJoin(%s) failed, err=%s", room, err)
+			}
+		}
+		users = append(users, user)
+	}
+	if !eventually(func() bool { return nodeState(servers[0]) == nodeState(servers[1]) }) {
+		t.Fatalf("Nodes differ before events are lost")
+	}
+
+	// changes made while second node gets no events are lost for it
+	blocked.Store(true)
+	for _, user := range users[:2] {
+		if err := adminClient.DeleteUser(user.Name); err != nil {
+			t.Errorf("DeleteUser failed, err=%s", err)
+		}
+	}
+	if err := adminClient.SuspendUser(users[2].Name); err != nil {
+		t.Errorf("SuspendUser failed, err=%s", err)
+	}
+	if _, err := adminClient.UpdateUser(users[3].Name, &api.UserUpdate{DisplayName: "Renamed"}); err != nil {
+		t.Errorf("UpdateUser failed, err=%s", err)
+	}
+	if err := api.NewClient(users[4], addresses[0]).RoomExit(rooms[1]); err != nil {
+		t.Errorf("RoomExit failed, err=%s", err)
+	}
+	if err := adminClient.RoomDelete(rooms[0]); err != nil {
+		t.Errorf("RoomDelete failed, err=%s", err)
+	}
+	if err := adminClient.RoomCreate("resync4"); err != nil {
+		t.Errorf("RoomCreate failed, err=%s", err)
+	}
+	if !eventuallyWithin(time.Second*5, func() bool { return servers[0].API.StatsSnapshot().ClusterDropped > 0 }) {
+		t.Fatalf("Events for blocked node were not dropped")
+	}
+
+	// node which missed events gets state of its peer
+	blocked.Store(false)
+	if !eventuallyWithin(time.Second*3, func() bool { return nodeState(servers[0]) == nodeState(servers[1]) }) {
+		t.Errorf("Nodes differ after resync:\n%s\n%s", nodeState(servers[0]), nodeState(servers[1]))
+	}
+
+	for _, server := range servers {
+		if err := server.Srv.Close(); err != nil {
+			t.Errorf("Closing server failed, err=%s", err)
+		}
+		<-done
+	}
+}
+
+func TestClusterWebsocketPosts(t *testing.T) {
+	var (
+		addresses = []string{"localhost:9291", "localhost:9292"}
+		servers   []*Server
+		done      = make(chan bool, len(addresses))
+		slowpoke  = newManagedUser("slowpoke")
+		speedy    = newManagedUser("speedy")
+		watcher   = newManagedUser("watcher")
+		// room is owned by second node
+		room = "remote"
+	)
+	for i, address := range addresses {
+		server := NewServer(address)
+		cluster, err := server.API.SetCluster(api.ClusterConfig{
+			Node:   address,
+			Peers:  addresses,
+			Secret: "cluster secret",
+		})
+		if err != nil {
+			t.Fatalf("SetCluster failed, err=%s", err)
+		}
+		defer cluster.Close()
+		servers = append(servers, server)
+
+		// second node is slow to take some forwarded posts
+		if i == 1 {
+			handler := server.Srv.Handler
+			server.Srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == api.GetPath(api.ClusterPostCall) {
+					content, _ := ioutil.ReadAll(r.Body)
+					if strings.Contains(string(content), "slow") {
+						time.Sleep(time.Millisecond * 500)
+					}
+					r.Body = ioutil.NopCloser(bytes.NewReader(content))
+				}
+				handler.ServeHTTP(w, r)
+			})
+		}
+
+		go func() {
+			if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+				t.Errorf("Server failed, err=%s", err)
+			}
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every node adds admin at start, like server does
+	for _, server := range servers {
+		server.API.Engine.AddUser(admin)
+	}
+	if !listening(addresses) {
+		t.Fatalf("Nodes not listening")
+	}
+	adminClient := api.NewClient(admin, addresses[0])
+	if err := adminClient.RoomCreate(room); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	sessions := make(map[string]*api.WebsocketSession)
+	for _, user := range []*chat.User{slowpoke, speedy, watcher} {
+		if err := adminClient.AddUser(user); err != nil {
+			t.Fatalf("AddUser failed, err=%s", err)
+		}
+		client := api.NewClient(user, addresses[0])
+		if err := client.RoomJoin(room); err != nil {
+			t.Fatalf("RoomJoin failed, err=%s", err)
+		}
+		session, err := client.OpenWebsocket(false)
+		if err != nil {
+			t.Fatalf("OpenWebsocket failed, err=%s", err)
+		}
+		defer session.Close()
+		sessions[user.Name] = session
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// post waiting for owner of room holds back only its own connection
+	start := time.Now()
+	if err := sessions[slowpoke.Name].Send(&api.Message{Room: room, Content: "slow"}); err != nil {
+		t.Fatalf("Send failed, err=%s", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if err := sessions[speedy.Name].Send(&api.Message{Room: room, Content: "fast"}); err != nil {
+		t.Fatalf("Send failed, err=%s", err)
+	}
+	select {
+	case msg := <-sessions[watcher.Name].Messages():
+		if msg.Content != "fast" {
+			t.Errorf("Watcher got %q first, expected post not held back by slow one", msg.Content)
+		}
+		if took := time.Since(start); took > time.Millisecond*400 {
+			t.Errorf("Fast post arrived after %s", took)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Watcher got no message")
+	}
+
+	for _, server := range servers {
+		if err := server.Srv.Close(); err != nil {
+			t.Errorf("Closing server failed, err=%s", err)
+		}
+		<-done
+	}
+}
+
+func TestClusterEventsResume(t *testing.T) {
+	var (
+		addresses = []string{"localhost:9293", "localhost:9294"}
+		servers   []*Server
+		done      = make(chan bool, len(addresses))
+		wanderer  = newManagedUser("wanderer")
+		poster    = newManagedUser("poster")
+		room      = "roaming"
+	)
+	for _, address := range addresses {
+		server := NewServer(address)
+		cluster, err := server.API.SetCluster(api.ClusterConfig{
+			Node:   address,
+			Peers:  addresses,
+			Secret: "cluster secret",
+		})
+		if err != nil {
+			t.Fatalf("SetCluster failed, err=%s", err)
+		}
+		defer cluster.Close()
+		servers = append(servers, server)
+
+		go func() {
+			if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+				t.Errorf("Server failed, err=%s", err)
+			}
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// every node adds admin at start, like server does
+	for _, server := range servers {
+		server.API.Engine.AddUser(admin)
+	}
+	if !listening(addresses) {
+		t.Fatalf("Nodes not listening")
+	}
+	adminClient := api.NewClient(admin, addresses[0])
+	if err := adminClient.RoomCreate(room); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	for _, user := range []*chat.User{wanderer, poster} {
+		if err := adminClient.AddUser(user); err != nil {
+			t.Fatalf("AddUser failed, err=%s", err)
+		}
+		if err := api.NewClient(user, addresses[0]).RoomJoin(room); err != nil {
+			t.Fatalf("RoomJoin failed, err=%s", err)
+		}
+	}
+	posterClient := api.NewClient(poster, addresses[0])
+
+	// both nodes give events to wanderer, second one gives more
+	var streams []*api.EventStream
+	for _, address := range addresses {
+		stream, err := api.NewClient(wanderer, address).OpenEvents(0, false)
+		if err != nil {
+			t.Fatalf("OpenEvents failed, err=%s", err)
+		}
+		defer stream.Close()
+		streams = append(streams, stream)
+	}
+	for _, content := range []string{"first", "second", "third"} {
+		if err := posterClient.SendMessage(&api.Message{Room: room, Content: content}); err != nil {
+			t.Fatalf("SendMessage failed, err=%s", err)
+		}
+	}
+	first := nextEvent(streams[0])
+	if first == nil || first.Message == nil || first.Message.Content != "first" {
+		t.Fatalf("Got event %+v, expected first message", first)
+	}
+	for _, stream := range streams[1:] {
+		for range []string{"first", "second", "third"} {
+			if event := nextEvent(stream); event == nil {
+				t.Fatalf("Second node gave no message")
+			}
+		}
+	}
+	lastID := first.ID
+
+	// resuming on other node resets stream instead of replaying
+	// or skipping events after ID it never gave
+	stream, err := api.NewClient(wanderer, addresses[1]).OpenEvents(lastID, false)
+	if err != nil {
+		t.Fatalf("OpenEvents failed, err=%s", err)
+	}
+	defer stream.Close()
+	event := nextEvent(stream)
+	if event == nil || event.Type != api.EventReset || event.ID == lastID {
+		t.Fatalf("Got event %+v after foreign ID %d, expected reset", event, lastID)
+	}
+	if err := posterClient.SendMessage(&api.Message{Room: room, Content: "after"}); err != nil {
+		t.Fatalf("SendMessage failed, err=%s", err)
+	}
+	if event := nextEvent(stream); event == nil || event.Message == nil || event.Message.Content != "after" {
+		t.Errorf("Got event %+v after reset, expected new message", event)
+	}
+
+	// the same node still resumes its own IDs
+	streams[0].Close()
+	stream, err = api.NewClient(wanderer, addresses[0]).OpenEvents(lastID, false)
+	if err != nil {
+		t.Fatalf("OpenEvents failed, err=%s", err)
+	}
+	defer stream.Close()
+	if event := nextEvent(stream); event == nil || event.Message == nil || event.Message.Content != "second" {
+		t.Errorf("Got event %+v, expected to resume with second message", event)
+	}
+
+	for _, server := range servers {
+		if err := server.Srv.Close(); err != nil {
+			t.Errorf("Closing server failed, err=%s", err)
+		}
+		<-done
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func TestDirectMessages(t *testing.T) {
+	var (
+		address = "localhost:9181"
+		server  = NewServer(address)
+		sender  = newManagedUser("sender")
+		target  = newManagedUser("target")
+		senderC = api.NewClient(sender, address)
+		targetC = api.NewClient(target, address)
+		done    = make(chan bool)
+	)
+	for _, user := range []*chat.User{sender, target} {
+		server.API.Engine.AddUser(user)
+	}
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	session, err := targetC.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket failed, err=%s", err)
+	}
+	defer session.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	// users share no room
+	for i, content := range []string{"one", "two"} {
+		msg := &api.Message{To: target.Name, Content: content}
+		if err := senderC.SendDirect(msg); err != nil {
+			t.Fatalf("SendDirect failed, err=%s", err)
+		}
+		if msg.Seq != uint64(i+1) {
+			t.Errorf("Direct message seq, got %d, expected %d", msg.Seq, i+1)
+		}
+	}
+	if err := senderC.SendDirect(&api.Message{To: "nobody", Content: "hi"}); err == nil {
+		t.Errorf("Sending to nonexist user should fail")
+	}
+	if err := senderC.SendDirect(&api.Message{To: sender.Name, Content: "hi"}); err == nil {
+		t.Errorf("Sending to himself should fail")
+	}
+
+	select {
+	case msg := <-session.Messages():
+		if msg.User != sender.Name || msg.To != target.Name || msg.Content != "one" {
+			t.Errorf("Websocket got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Direct message was not delivered to websocket")
+	}
+
+	unread, err := targetC.GetUnread()
+	if err != nil || unread[sender.Name] != 2 {
+		t.Errorf("GetUnread, got %v, err=%v, expected 2 from %s", unread, err, sender.Name)
+	}
+	if unread, _ := senderC.GetUnread(); len(unread) != 0 {
+		t.Errorf("Sender should have nothing unread, got %v", unread)
+	}
+
+	msgs, err := targetC.DirectMessages(sender.Name, 0, 0, 0)
+	if err != nil || len(msgs) != 2 || msgs[1].Content != "two" {
+		t.Errorf("DirectMessages, got %+v, err=%v", msgs, err)
+	}
+	if msgs, _ := senderC.DirectMessages(target.Name, 0, 0, 1); len(msgs) != 1 || msgs[0].Content != "two" {
+		t.Errorf("Sender should see the same conversation, got %+v", msgs)
+	}
+	if unread, _ := targetC.GetUnread(); len(unread) != 0 {
+		t.Errorf("Reading conversation should clear unread, got %v", unread)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestDirectKeyCollision(t *testing.T) {
+	var (
+		address   = "localhost:9182"
+		server    = NewServer(address)
+		sender    = newManagedUser("dksender")
+		target    = newManagedUser("dktarget")
+		intruder  = newManagedUser("dkintruder")
+		senderC   = api.NewClient(sender, address)
+		intruderC = api.NewClient(intruder, address)
+		done      = make(chan bool)
+	)
+	for _, user := range []*chat.User{sender, target, intruder} {
+		server.API.Engine.AddUser(user)
+	}
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := senderC.SendDirect(&api.Message{To: target.Name, Content: "secret"}); err != nil {
+		t.Fatalf("SendDirect failed, err=%s", err)
+	}
+
+	// room named like direct conversation would share its history
+	name := "\x00" + sender.Name + "\x00" + target.Name
+	if err := intruderC.RoomCreate(name); !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("RoomCreate(%q), got %v, expected %s", name, err, api.ErrBadRequest)
+	}
+	if page, err := intruderC.RoomMessages(name, 0, 0, 10); err == nil || len(page) != 0 {
+		t.Errorf("RoomMessages(%q) leaked %+v", name, page)
+	}
+	if err := server.API.Engine.AddUser(newManagedUser("dk\x00user")); err != chat.ErrInvalidName {
+		t.Errorf("AddUser with control character, got %v, expected %s", err, chat.ErrInvalidName)
+	}
+
+	if msgs, err := senderC.DirectMessages(target.Name, 0, 0, 0); err != nil || len(msgs) != 1 || msgs[0].Content != "secret" {
+		t.Errorf("DirectMessages, got %+v, err=%v", msgs, err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestDirectBothWays(t *testing.T) {
+	var (
+		address = "localhost:9183"
+		server  = NewServer(address)
+		left    = newManagedUser("bwleft")
+		right   = newManagedUser("bwright")
+		leftC   = api.NewClient(left, address)
+		rightC  = api.NewClient(right, address)
+		done    = make(chan bool)
+	)
+	for _, user := range []*chat.User{left, right} {
+		server.API.Engine.AddUser(user)
+	}
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	sessions := make(map[string]*api.WebsocketSession)
+	for _, c := range []struct {
+		name   string
+		client *api.Client
+	}{{left.Name, leftC}, {right.Name, rightC}} {
+		session, err := c.client.OpenWebsocket(false)
+		if err != nil {
+			t.Fatalf("OpenWebsocket failed, err=%s", err)
+		}
+		defer session.Close()
+		sessions[c.name] = session
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// conversation alternates, each side must see continuous sequence
+	turns := []struct {
+		from *api.Client
+		to   string
+	}{{leftC, right.Name}, {rightC, left.Name}, {leftC, right.Name}, {leftC, right.Name}, {rightC, left.Name}}
+	for i, turn := range turns {
+		if err := turn.from.SendDirect(&api.Message{To: turn.to, Content: "turn"}); err != nil {
+			t.Fatalf("SendDirect %d failed, err=%s", i, err)
+		}
+	}
+
+	expected := map[string]int{right.Name: 3, left.Name: 2}
+	for name, session := range sessions {
+		tracker := api.NewSequenceTracker()
+		for i := 0; i < expected[name]; i++ {
+			select {
+			case msg := <-session.Messages():
+				if gap, inOrder := tracker.Track(msg); gap != 0 || !inOrder {
+					t.Errorf("%s got %+v with gap %d, inOrder %t", name, msg, gap, inOrder)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s got %d of %d messages", name, i, expected[name])
+			}
+		}
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
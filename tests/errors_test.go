@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func TestErrorResponses(t *testing.T) {
+	var (
+		address     = "localhost:9221"
+		server      = NewServer(address)
+		delta       = newManagedUser("delta")
+		adminClient = api.NewClient(admin, address)
+		client      = api.NewClient(delta, address)
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+	server.API.Engine.AddUser(delta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := client.RoomCreate("errors"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	err := client.RoomCreate("errors")
+	if !errors.Is(err, api.ErrExists) || !errors.Is(err, chat.ErrExists) {
+		t.Errorf("Creating room twice, got %v, expected %s", err, api.ErrExists.Code)
+	}
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Errorf("Creating room twice, got %v, expected status %d", err, http.StatusConflict)
+	}
+
+	if err := client.RoomJoin("nonexist"); !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Joining nonexist room, got %v, expected %s", err, api.ErrNotFound.Code)
+	}
+	if err := client.AddUser(newManagedUser("epsilon")); !errors.Is(err, api.ErrNotPermit) {
+		t.Errorf("AddUser without permission, got %v, expected %s", err, api.ErrNotPermit.Code)
+	}
+	if err := client.RoomKick("errors", ""); !errors.Is(err, api.ErrMissingArg) {
+		t.Errorf("Kick without user, got %v, expected %s", err, api.ErrMissingArg.Code)
+	}
+
+	server.API.Engine.SetUsersLimit(uint(server.API.Engine.UsersCount()))
+	if err := adminClient.AddUser(newManagedUser("epsilon")); !errors.Is(err, api.ErrNoResources) {
+		t.Errorf("AddUser over limit, got %v, expected %s", err, api.ErrNoResources.Code)
+	}
+
+	payload, _ := json.Marshal(&api.Room{Name: "errors"})
+	request, _ := http.NewRequest(http.MethodPost, "http://"+address+api.GetPath(api.RoomsCreateCall), bytes.NewBuffer(payload))
+	request.SetBasicAuth(delta.AuthID, delta.Token)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Request failed, err=%s", err)
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	envelope := &api.Error{}
+	if err := json.Unmarshal(content, envelope); err != nil {
+		t.Errorf("Error response is not JSON, err=%s", err)
+	}
+	if resp.StatusCode != http.StatusConflict || envelope.Code != api.ErrExists.Code {
+		t.Errorf("Got %d %q, expected %d %q", resp.StatusCode, envelope.Code, http.StatusConflict, api.ErrExists.Code)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+// failingStore fails to persist rooms
+type failingStore struct {
+	*chat.MemoryStore
+}
+
+func (s *failingStore) PutRoom(room *chat.RoomRecord) error {
+	return errors.New("disk full")
+}
+
+func TestInternalErrors(t *testing.T) {
+	var (
+		address = "localhost:9222"
+		zeta    = newManagedUser("zeta")
+		client  = api.NewClient(zeta, address)
+		done    = make(chan bool)
+	)
+	engine, err := chat.NewChatWithStore(&failingStore{chat.NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("NewChatWithStore failed, err=%s", err)
+	}
+	server := NewServerWithAPI(address, api.NewAPIWithEngine(engine))
+	server.API.Engine.AddUser(zeta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	// failure of server is not blamed on request
+	err = client.RoomCreate("internal")
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusInternalServerError || !errors.Is(err, api.ErrInternal) {
+		t.Errorf("RoomCreate with failing store, got %v, expected %s", err, api.ErrInternal.Code)
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, "http://"+address+api.GetPath(api.RoomsCreateCall), bytes.NewBufferString("{"))
+	request.SetBasicAuth(zeta.AuthID, zeta.Token)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Request failed, err=%s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Malformed request, got %d, expected %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
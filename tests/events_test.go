@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+// nextEvent returns next event of stream or nil after timeout
+func nextEvent(stream *api.EventStream) *api.Event {
+	select {
+	case event := <-stream.Events():
+		return event
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestEvents(t *testing.T) {
+	var (
+		address = "localhost:9271"
+		server  = NewServer(address)
+		nu      = newManagedUser("nu")
+		xi      = newManagedUser("xi")
+		nuC     = api.NewClient(nu, address)
+		xiC     = api.NewClient(xi, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(nu)
+	server.API.Engine.AddUser(xi)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	stream, err := nuC.OpenEvents(0, false)
+	if err != nil {
+		t.Fatalf("OpenEvents failed, err=%s", err)
+	}
+
+	if err := nuC.RoomCreate("events"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	if err := xiC.RoomJoin("events"); err != nil {
+		t.Fatalf("RoomJoin failed, err=%s", err)
+	}
+	if err := xiC.SendMessage(&api.Message{Room: "events", Content: "first"}); err != nil {
+		t.Fatalf("SendMessage failed, err=%s", err)
+	}
+	if err := xiC.RoomExit("events"); err != nil {
+		t.Fatalf("RoomExit failed, err=%s", err)
+	}
+
+	expected := []api.Event{
+		{ID: 1, Type: api.EventJoin, Room: "events", User: nu.Name},
+		{ID: 2, Type: api.EventJoin, Room: "events", User: xi.Name},
+		{ID: 3, Type: api.EventMessage, Room: "events", User: xi.Name},
+		{ID: 4, Type: api.EventExit, Room: "events", User: xi.Name},
+	}
+	for _, want := range expected {
+		event := nextEvent(stream)
+		if event == nil {
+			t.Fatalf("No event, expected %+v", want)
+		}
+		if event.ID != want.ID || event.Type != want.Type || event.Room != want.Room || event.User != want.User {
+			t.Errorf("Got event %+v, expected %+v", event, want)
+		}
+		if event.Type == api.EventMessage && (event.Message == nil || event.Message.Content != "first") {
+			t.Errorf("Message event carries %+v", event.Message)
+		}
+	}
+
+	// events while disconnected are delivered after resume
+	lastID := stream.LastEventID()
+	stream.Close()
+	if err := nuC.SendMessage(&api.Message{Room: "events", Content: "missed"}); err != nil {
+		t.Fatalf("SendMessage failed, err=%s", err)
+	}
+	if err := nuC.RoomDelete("events"); err != nil {
+		t.Fatalf("RoomDelete failed, err=%s", err)
+	}
+
+	stream, err = nuC.OpenEvents(lastID, false)
+	if err != nil {
+		t.Fatalf("OpenEvents failed, err=%s", err)
+	}
+	defer stream.Close()
+	if event := nextEvent(stream); event == nil || event.ID != lastID+1 || event.Message == nil || event.Message.Content != "missed" {
+		t.Errorf("Resumed stream got %+v, expected missed message", event)
+	}
+	if event := nextEvent(stream); event == nil || event.Type != api.EventRoomDeleted || event.Room != "events" {
+		t.Errorf("Resumed stream got %+v, expected room deletion", event)
+	}
+
+	server.API.CloseEventStreams()
+	if _, ok := <-stream.Events(); ok {
+		t.Errorf("Stream should end when event streams are closed")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+func TestRoomMessagesNotMember(t *testing.T) {
+	var (
+		address = "localhost:9121"
+		server  = NewServer(address)
+		clientA = api.NewClient(useralpha, address)
+		clientB = api.NewClient(userbeta, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(useralpha)
+	server.API.Engine.AddUser(userbeta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := clientA.RoomCreate(roomAlpha.Name); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	if _, err := clientB.RoomMessages(roomAlpha.Name, 0, 0, 0); err == nil {
+		t.Errorf("Reading history of room user is not in should fail")
+	}
+
+	if _, err := clientA.RoomMessages(roomBeta.Name, 0, 0, 0); err == nil {
+		t.Errorf("Reading history of nonexist room should fail")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestRoomMessagesPages(t *testing.T) {
+	var (
+		address = "localhost:9122"
+		server  = NewServer(address)
+		client  = api.NewClient(useralpha, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(useralpha)
+	server.API.SetHistoryLimits(8, time.Hour)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := client.RoomCreate(roomAlpha.Name); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		msg := &api.Message{Content: fmt.Sprintf("msg%d", i), Room: roomAlpha.Name}
+		if err := client.SendMessage(msg); err != nil {
+			t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
+		}
+	}
+
+	// only 8 newest are retained
+	all, err := client.RoomMessages(roomAlpha.Name, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("RoomMessages(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if len(all) != 8 {
+		t.Fatalf("Expected %d messages, got %d", 8, len(all))
+	}
+	for i, msg := range all {
+		if expected := fmt.Sprintf("msg%d", i+2); msg.Content != expected {
+			t.Errorf("msg.content, got %s, expected %s", msg.Content, expected)
+		}
+		if msg.User != useralpha.Name || msg.Time.IsZero() {
+			t.Errorf("Message not stamped by server, got %+v", msg)
+		}
+		if i > 0 && msg.ID <= all[i-1].ID {
+			t.Errorf("Message IDs not growing, got %d after %d", msg.ID, all[i-1].ID)
+		}
+	}
+
+	latest, err := client.RoomMessages(roomAlpha.Name, 0, 0, 3)
+	if err != nil {
+		t.Errorf("RoomMessages(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if len(latest) != 3 || latest[0].ID != all[5].ID {
+		t.Errorf("Expected 3 newest messages, got %+v", latest)
+	}
+
+	older, err := client.RoomMessages(roomAlpha.Name, latest[0].ID, 0, 3)
+	if err != nil {
+		t.Errorf("RoomMessages(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if len(older) != 3 || older[0].ID != all[2].ID {
+		t.Errorf("Expected 3 messages before %d, got %+v", latest[0].ID, older)
+	}
+
+	newer, err := client.RoomMessages(roomAlpha.Name, 0, all[0].ID, 2)
+	if err != nil {
+		t.Errorf("RoomMessages(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if len(newer) != 2 || newer[0].ID != all[1].ID {
+		t.Errorf("Expected 2 messages after %d, got %+v", all[0].ID, newer)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+func TestLongPoll(t *testing.T) {
+	var (
+		address = "localhost:9281"
+		server  = NewServer(address)
+		omicron = newManagedUser("omicron")
+		pi      = newManagedUser("pi")
+		omC     = api.NewClient(omicron, address)
+		piC     = api.NewClient(pi, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(omicron)
+	server.API.Engine.AddUser(pi)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := omC.RoomCreate("longpoll"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	if err := piC.RoomJoin("longpoll"); err != nil {
+		t.Fatalf("RoomJoin failed, err=%s", err)
+	}
+	// first poll subscribes
+	if _, err := piC.ReceiveMessage(); err != nil {
+		t.Fatalf("ReceiveMessage failed, err=%s", err)
+	}
+
+	// poll without messages returns empty after wait
+	start := time.Now()
+	msgs, err := piC.ReceiveMessageWait(time.Millisecond*100, 0)
+	if err != nil {
+		t.Fatalf("ReceiveMessageWait failed, err=%s", err)
+	}
+	if len(msgs) != 0 || time.Since(start) < time.Millisecond*100 {
+		t.Errorf("Poll returned %+v after %s, expected nothing after wait", msgs, time.Since(start))
+	}
+
+	// poll returns as soon as message arrives
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		if err := omC.SendMessage(&api.Message{Room: "longpoll", Content: "waited"}); err != nil {
+			t.Errorf("SendMessage failed, err=%s", err)
+		}
+	}()
+	start = time.Now()
+	msgs, err = piC.ReceiveMessageWait(time.Second*3, 0)
+	if err != nil {
+		t.Fatalf("ReceiveMessageWait failed, err=%s", err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "waited" {
+		t.Errorf("Poll returned %+v, expected waited message", msgs)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Poll returned after %s, expected right after message", time.Since(start))
+	}
+
+	// max limits returned messages, the rest stays queued
+	for _, content := range []string{"one", "two", "three"} {
+		if err := omC.SendMessage(&api.Message{Room: "longpoll", Content: content}); err != nil {
+			t.Fatalf("SendMessage failed, err=%s", err)
+		}
+	}
+	msgs, err = piC.ReceiveMessageWait(time.Second, 2)
+	if err != nil {
+		t.Fatalf("ReceiveMessageWait failed, err=%s", err)
+	}
+	if len(msgs) != 2 || msgs[0].Content != "one" || msgs[1].Content != "two" {
+		t.Errorf("Poll returned %+v, expected two first messages", msgs)
+	}
+	msgs, err = piC.ReceiveMessage()
+	if err != nil {
+		t.Fatalf("ReceiveMessage failed, err=%s", err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "three" {
+		t.Errorf("Poll returned %+v, expected remaining message", msgs)
+	}
+
+	// shutdown answers waiting poll instead of waiting for it
+	server.Srv.RegisterOnShutdown(server.API.ClosePolls)
+	polled := make(chan error, 1)
+	go func() {
+		msgs, err := piC.ReceiveMessageWait(time.Second*3, 0)
+		if err == nil && len(msgs) != 0 {
+			t.Errorf("Poll on shutdown returned %+v", msgs)
+		}
+		polled <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	start = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := server.Srv.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown failed, err=%s", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Shutdown took %s, expected waiting poll to be answered", time.Since(start))
+	}
+	if err := <-polled; err != nil {
+		t.Errorf("Poll on shutdown failed, err=%s", err)
+	}
+	<-done
+}
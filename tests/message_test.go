@@ -48,6 +48,13 @@ func TestMessage(t *testing.T) {
 		t.Errorf("RoomJoin(%s) failed, err=%s", roomAlpha.Name, err)
 	}
 
+	// first poll subscribes
+	for _, client := range []*api.Client{clientA, clientD} {
+		if _, err := client.ReceiveMessage(); err != nil {
+			t.Errorf("ReceiveMessage failed, err=%s", err)
+		}
+	}
+
 	if err := clientB.SendMessage(&api.Message{Content: "hello", Room: roomAlpha.Name}); err != nil {
 		t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
 	}
@@ -92,3 +99,91 @@ func TestMessage(t *testing.T) {
 	}
 
 }
+
+func TestMessageSequence(t *testing.T) {
+	var (
+		address = "localhost:9065"
+		server  = NewServer(address)
+		clientA = api.NewClient(useralpha, address)
+		clientB = api.NewClient(userbeta, address)
+		tracker = api.NewSequenceTracker()
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	clientAdmin := api.NewClient(admin, address)
+	if err := clientAdmin.AddUser(useralpha); err != nil {
+		t.Errorf("Adduser(%s) failed, err=%s", useralpha.Name, err)
+	}
+	if err := clientAdmin.AddUser(userbeta); err != nil {
+		t.Errorf("Adduser(%s) failed, err=%s", userbeta.Name, err)
+	}
+
+	if err := clientA.RoomCreate(roomAlpha.Name); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if err := clientB.RoomJoin(roomAlpha.Name); err != nil {
+		t.Errorf("RoomJoin(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	// first poll subscribes
+	if _, err := clientA.ReceiveMessage(); err != nil {
+		t.Errorf("ReceiveMessage failed, err=%s", err)
+	}
+
+	first := &api.Message{Content: "first", Room: roomAlpha.Name, ClientID: "b-1"}
+	if err := clientB.SendMessage(first); err != nil {
+		t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if first.ID == 0 || first.Seq != 1 || first.Time.IsZero() {
+		t.Errorf("Message not stamped by server, got %+v", first)
+	}
+
+	// retried message is not delivered twice
+	retry := &api.Message{Content: "first", Room: roomAlpha.Name, ClientID: "b-1"}
+	if err := clientB.SendMessage(retry); err != nil {
+		t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if retry.ID != first.ID || retry.Seq != first.Seq {
+		t.Errorf("Retried message got new ID, got %+v, expected %+v", retry, first)
+	}
+
+	for _, content := range []string{"second", "third"} {
+		if err := clientB.SendMessage(&api.Message{Content: content, Room: roomAlpha.Name}); err != nil {
+			t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
+		}
+	}
+
+	msgs, err := clientA.ReceiveMessage()
+	if err != nil {
+		t.Errorf("ReceiveMessage(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+
+	if len(msgs) != 3 {
+		t.Fatalf("Expected %d messages, got %d", 3, len(msgs))
+	}
+
+	// pretend second message was lost
+	if _, inOrder := tracker.Track(&msgs[0]); !inOrder {
+		t.Errorf("First message should be in order")
+	}
+	if gap, inOrder := tracker.Track(&msgs[2]); gap != 1 || !inOrder {
+		t.Errorf("Expected gap of 1 in order, got %d %v", gap, inOrder)
+	}
+	if _, inOrder := tracker.Track(&msgs[1]); inOrder {
+		t.Errorf("Late message should be reported out of order")
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+func TestMetrics(t *testing.T) {
+	var (
+		address = "localhost:9131"
+		server  = NewServer(address)
+		client  = api.NewClient(useralpha, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(useralpha)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := client.RoomCreate(roomAlpha.Name); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if err := client.SendMessage(&api.Message{Content: "hello", Room: roomAlpha.Name}); err != nil {
+		t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+	if err := client.RoomCreate(roomAlpha.Name); err == nil {
+		t.Errorf("Creating room twice should fail")
+	}
+
+	resp, err := http.Get("http://" + address + api.MetricsPath)
+	if err != nil {
+		t.Fatalf("Get(%s) failed, err=%s", api.MetricsPath, err)
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	metrics := string(content)
+
+	expected := []string{
+		`perfchat_messages_received_total 1`,
+		fmt.Sprintf("perfchat_rooms %d", server.API.Engine.RoomsCount()),
+		`perfchat_added_rooms_total 1`,
+		`perfchat_users 1`,
+		`perfchat_websocket_clients 0`,
+		`perfchat_room_fanout{room="` + roomAlpha.Name + `"} 1`,
+		`perfchat_message_fanout_count 1`,
+		`perfchat_http_requests_total{route="CreateRoom",code="200"} 1`,
+		`perfchat_http_requests_total{route="CreateRoom",code="409"} 1`,
+		`perfchat_http_requests_in_flight{route="Metrics"} 1`,
+		`perfchat_http_request_duration_seconds_bucket{route="ReceiveMessage",le="+Inf"} 1`,
+		`perfchat_messages_dropped_total{transport="poll"} 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("Metrics missing %q", line)
+		}
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func TestRoomModeration(t *testing.T) {
+	var (
+		address   = "localhost:9161"
+		server    = NewServer(address)
+		owner     = newManagedUser("owner")
+		moderator = newManagedUser("moderator")
+		member    = newManagedUser("member")
+		ownerC    = api.NewClient(owner, address)
+		moderC    = api.NewClient(moderator, address)
+		memberC   = api.NewClient(member, address)
+		room      = "moderated"
+		done      = make(chan bool)
+	)
+	for _, user := range []*chat.User{owner, moderator, member} {
+		server.API.Engine.AddUser(user)
+	}
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := ownerC.RoomCreate(room); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", room, err)
+	}
+	for _, client := range []*api.Client{moderC, memberC} {
+		if err := client.RoomJoin(room); err != nil {
+			t.Errorf("RoomJoin(%s) failed, err=%s", room, err)
+		}
+	}
+
+	if err := moderC.RoomKick(room, member.Name); err == nil {
+		t.Errorf("Member kicking other member should fail")
+	}
+	if err := ownerC.RoomPromote(room, moderator.Name); err != nil {
+		t.Errorf("RoomPromote failed, err=%s", err)
+	}
+	rooms, err := ownerC.GetRooms()
+	if err != nil {
+		t.Errorf("GetRooms failed, err=%s", err)
+	}
+	for _, r := range rooms {
+		if r.Name == room && (len(r.Moderators) != 1 || r.Moderators[0] != moderator.Name) {
+			t.Errorf("Room moderators, got %v, expected [%s]", r.Moderators, moderator.Name)
+		}
+	}
+
+	// kicked member can come back
+	if err := moderC.RoomKick(room, member.Name); err != nil {
+		t.Errorf("RoomKick failed, err=%s", err)
+	}
+	if r, _ := server.API.Engine.GetRoomByName(room); r.HasUser(member.Name) {
+		t.Errorf("Kicked user is still in room")
+	}
+	if err := memberC.RoomJoin(room); err != nil {
+		t.Errorf("Kicked user should join again, err=%s", err)
+	}
+
+	// banned member can't
+	if err := moderC.RoomBan(room, member.Name); err != nil {
+		t.Errorf("RoomBan failed, err=%s", err)
+	}
+	if err := memberC.RoomJoin(room); err == nil {
+		t.Errorf("Banned user should not join room")
+	}
+	if err := moderC.RoomUnban(room, member.Name); err != nil {
+		t.Errorf("RoomUnban failed, err=%s", err)
+	}
+	if err := memberC.RoomJoin(room); err != nil {
+		t.Errorf("Unbanned user should join again, err=%s", err)
+	}
+
+	if err := moderC.RoomMute(room, member.Name, time.Minute); err != nil {
+		t.Errorf("RoomMute failed, err=%s", err)
+	}
+	if err := memberC.SendMessage(&api.Message{Room: room, Content: "shh"}); err == nil {
+		t.Errorf("Muted user should not post")
+	}
+	if err := moderC.RoomMute(room, member.Name, 0); err != nil {
+		t.Errorf("RoomMute(0) failed, err=%s", err)
+	}
+	if err := memberC.SendMessage(&api.Message{Room: room, Content: "hi"}); err != nil {
+		t.Errorf("Unmuted user should post, err=%s", err)
+	}
+
+	if err := moderC.RoomKick(room, owner.Name); err == nil {
+		t.Errorf("Moderator kicking owner should fail")
+	}
+	if err := moderC.RoomPromote(room, member.Name); err == nil {
+		t.Errorf("Moderator promoting member should fail")
+	}
+	if err := moderC.RoomTransfer(room, member.Name); err == nil {
+		t.Errorf("Moderator transfering room should fail")
+	}
+
+	if err := ownerC.RoomTransfer(room, moderator.Name); err != nil {
+		t.Errorf("RoomTransfer failed, err=%s", err)
+	}
+	r, _ := server.API.Engine.GetRoomByName(room)
+	if r.RoleOf(moderator.Name) != chat.RoomOwner || r.RoleOf(owner.Name) != chat.RoomModerator {
+		t.Errorf("Ownership not transferred, roles %s and %s", r.RoleOf(moderator.Name), r.RoleOf(owner.Name))
+	}
+	if err := ownerC.RoomKick(room, moderator.Name); err == nil {
+		t.Errorf("Previous owner kicking new one should fail")
+	}
+	if err := ownerC.RoomTransfer(owner.Name, member.Name); err == nil {
+		t.Errorf("Transferring personal room should fail")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestRoomModerationRestored(t *testing.T) {
+	var (
+		address = "localhost:9162"
+		owner   = newManagedUser("owner")
+		member  = newManagedUser("member")
+		ownerC  = api.NewClient(owner, address)
+		room    = "moderated"
+		done    = make(chan bool)
+	)
+
+	dir, err := ioutil.TempDir("", "perfchat")
+	if err != nil {
+		t.Fatalf("TempDir failed, err=%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFileServer(t, address, dir)
+	server.API.Engine.AddUser(owner)
+	server.API.Engine.AddUser(member)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := ownerC.RoomCreate(room); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", room, err)
+	}
+	if err := ownerC.RoomBan(room, member.Name); err != nil {
+		t.Errorf("RoomBan failed, err=%s", err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+	if err := server.API.Engine.Close(); err != nil {
+		t.Errorf("Closing engine failed, err=%s", err)
+	}
+
+	server = newFileServer(t, address, dir)
+	defer server.API.Engine.Close()
+	if err := server.API.Engine.JoinRoom(member.Name, room); err != chat.ErrBanned {
+		t.Errorf("Ban was not restored, JoinRoom got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	password, err := chat.NewPassword("secret")
+	if err != nil {
+		t.Fatalf("NewPassword failed, err=%s", err)
+	}
+	if password.Iterations != chat.PasswordIterations {
+		t.Errorf("Password iterations, got %d, expected %d", password.Iterations, chat.PasswordIterations)
+	}
+	if !password.Matches("secret") {
+		t.Errorf("Password should match its secret")
+	}
+	if password.Matches("secreT") || password.Matches("") {
+		t.Errorf("Password should not match other secret")
+	}
+
+	// cost is stored with hash, so changing it keeps old passwords valid
+	defer func(iterations int) { chat.PasswordIterations = iterations }(chat.PasswordIterations)
+	chat.PasswordIterations *= 2
+	if !password.Matches("secret") {
+		t.Errorf("Password should match after cost changed")
+	}
+
+	// passwords stored before PBKDF2 still match
+	sum := sha256.Sum256([]byte("salt" + "secret"))
+	legacy := &chat.Password{Salt: "salt", Hash: hex.EncodeToString(sum[:])}
+	if !legacy.Matches("secret") || legacy.Matches("other") {
+		t.Errorf("Legacy password should match only its secret")
+	}
+}
@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// permissionCall is API call made by tested role,
+// allowed lists roles which may make it
+type permissionCall struct {
+	name    string
+	method  string
+	call    string
+	body    string
+	allowed []string
+}
+
+var (
+	everyone   = []string{chat.AdminRole, chat.ModeratorRole, chat.UserRole}
+	moderators = []string{chat.AdminRole, chat.ModeratorRole}
+	admins     = []string{chat.AdminRole}
+)
+
+// permissionCalls are made in order by every role, calls changing
+// victim are last so that earlier ones still have something to act on
+var permissionCalls = []permissionCall{
+	{"GetUsers", http.MethodGet, api.UsersCall, "", everyone},
+	{"GetRooms", http.MethodGet, api.RoomsCall, "", everyone},
+	{"AddUser", http.MethodPost, api.UsersCall, `{"name":"newbie","role":"admin","authid":"n","token":"n"}`, admins},
+	{"CreateRoom", http.MethodPost, api.RoomsCreateCall, `{"name":"actorroom"}`, everyone},
+	{"JoinRoom", http.MethodPost, api.RoomsJoinCall, `{"name":"victimroom"}`, everyone},
+	{"ReceiveMessage", http.MethodPost, api.MessageCall, `{"room":"victimroom","content":"hi"}`, everyone},
+	{"SendMessage", http.MethodGet, api.MessageCall, "", everyone},
+	{"RoomMessages", http.MethodGet, api.RoomCall(api.RoomsMessagesCall, "victimroom"), "", everyone},
+	{"ExitRoom", http.MethodPost, api.RoomsExitCall, `{"name":"victimroom"}`, everyone},
+	{"Websocket", http.MethodGet, api.WsPath, "", everyone},
+	{"DeleteOwnRoom", http.MethodPost, api.RoomsDeleteCall, `{"name":"actorroom"}`, everyone},
+	{"SuspendUser", http.MethodPost, api.RoomCall(api.UserSuspendCall, "victim"), "", moderators},
+	{"ResumeUser", http.MethodPost, api.RoomCall(api.UserResumeCall, "victim"), "", moderators},
+	{"UpdateUser", http.MethodPatch, api.RoomCall(api.UserCall, "victim"), `{"display_name":"V"}`, admins},
+	{"DeleteRoom", http.MethodPost, api.RoomsDeleteCall, `{"name":"victimroom"}`, moderators},
+	{"DeleteUser", http.MethodDelete, api.RoomCall(api.UserCall, "victim"), "", admins},
+}
+
+// newPermissionServer returns server with actor of role and victim owning room
+func newPermissionServer(address string, actor *chat.User) *Server {
+	engine := chat.NewChat()
+	victim := &chat.User{Name: "victim", AuthID: "victimID", Token: "victimToken", Role: chat.UserRole}
+	engine.AddUser(victim)
+	engine.AddRoom(&chat.Room{Name: victim.Name, Creator: victim.Name, Users: []*chat.User{victim}})
+	engine.AddRoom(&chat.Room{Name: "victimroom", Creator: victim.Name, Users: []*chat.User{victim}})
+	if actor != nil {
+		engine.AddUser(actor)
+	}
+	return NewServerWithAPI(address, api.NewAPIWithEngine(engine))
+}
+
+func callStatus(t *testing.T, address string, actor *chat.User, call permissionCall) int {
+	request, err := http.NewRequest(call.method, "http://"+address+api.GetPath(call.call), bytes.NewBufferString(call.body))
+	if err != nil {
+		t.Fatalf("NewRequest(%s) failed, err=%s", call.name, err)
+	}
+	if actor != nil {
+		request.SetBasicAuth(actor.AuthID, actor.Token)
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s failed, err=%s", call.name, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func testRolePermissions(t *testing.T, address string, actor *chat.User) {
+	var (
+		server = newPermissionServer(address, actor)
+		done   = make(chan bool)
+	)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	for _, call := range permissionCalls {
+		allowed := false
+		for _, role := range call.allowed {
+			allowed = allowed || (actor != nil && !actor.Suspended && actor.Role == role)
+		}
+
+		status := callStatus(t, address, actor, call)
+		switch {
+		case actor == nil && status != http.StatusUnauthorized:
+			t.Errorf("%s without auth, got %d, expected %d", call.name, status, http.StatusUnauthorized)
+		case actor != nil && allowed && (status == http.StatusForbidden || status == http.StatusUnauthorized):
+			t.Errorf("%s by %s should be allowed, got %d", call.name, actor.Role, status)
+		case actor != nil && !allowed && status != http.StatusForbidden:
+			t.Errorf("%s by %s should be forbidden, got %d", call.name, actor.Role, status)
+		}
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestAdminPermissions(t *testing.T) {
+	testRolePermissions(t, "localhost:9151", &chat.User{Name: "actor", AuthID: "actorID", Token: "actorToken", Role: chat.AdminRole})
+}
+
+func TestModeratorPermissions(t *testing.T) {
+	testRolePermissions(t, "localhost:9152", &chat.User{Name: "actor", AuthID: "actorID", Token: "actorToken", Role: chat.ModeratorRole})
+}
+
+func TestUserPermissions(t *testing.T) {
+	testRolePermissions(t, "localhost:9153", &chat.User{Name: "actor", AuthID: "actorID", Token: "actorToken", Role: chat.UserRole})
+}
+
+func TestSuspendedPermissions(t *testing.T) {
+	testRolePermissions(t, "localhost:9154", &chat.User{Name: "actor", AuthID: "actorID", Token: "actorToken", Role: chat.AdminRole, Suspended: true})
+}
+
+func TestNoAuthPermissions(t *testing.T) {
+	testRolePermissions(t, "localhost:9155", nil)
+}
+
+func TestModeratorCantManageAdmin(t *testing.T) {
+	var (
+		address   = "localhost:9156"
+		moderator = &chat.User{Name: "actor", AuthID: "actorID", Token: "actorToken", Role: chat.ModeratorRole}
+		server    = newPermissionServer(address, moderator)
+		client    = api.NewClient(moderator, address)
+		done      = make(chan bool)
+	)
+	server.API.Engine.AddUser(&chat.User{Name: "boss", AuthID: "bossID", Token: "bossToken", Role: chat.AdminRole})
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := client.SuspendUser("boss"); err == nil {
+		t.Errorf("Moderator suspending admin should fail")
+	}
+	if err := client.SuspendUser(moderator.Name); err == nil {
+		t.Errorf("Moderator suspending himself should fail")
+	}
+	if err := client.RoomDelete("main"); err == nil {
+		t.Errorf("Deleting main room should fail")
+	}
+	if err := client.RoomDelete("victim"); err == nil {
+		t.Errorf("Deleting personal room of other user should fail")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func TestRateLimit(t *testing.T) {
+	var (
+		address = "localhost:9231"
+		server  = NewServer(address)
+		zeta    = newManagedUser("zeta")
+		eta     = newManagedUser("eta")
+		zetaC   = api.NewClient(zeta, address)
+		etaC    = api.NewClient(eta, address)
+		slow    = api.RateLimit{Rate: 0.001, Burst: 2}
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(zeta)
+	server.API.Engine.AddUser(eta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	server.API.SetRateLimits(api.RateLimits{Routes: map[string]api.RateLimit{"GetRooms": slow}})
+	for i := 0; i < 2; i++ {
+		if _, err := zetaC.GetRooms(); err != nil {
+			t.Errorf("GetRooms within limit failed, err=%s", err)
+		}
+	}
+	if _, err := zetaC.GetRooms(); !errors.Is(err, api.ErrRateLimited) {
+		t.Errorf("GetRooms over route limit, got %v, expected %s", err, api.ErrRateLimited.Code)
+	}
+	if _, err := zetaC.GetUsers(); err != nil {
+		t.Errorf("Route limit should not affect other routes, err=%s", err)
+	}
+	if _, err := etaC.GetRooms(); err != nil {
+		t.Errorf("Route limit should not affect other users, err=%s", err)
+	}
+
+	server.API.SetRateLimits(api.RateLimits{User: slow})
+	zetaC.GetRooms()
+	zetaC.GetUsers()
+	if err := zetaC.Ping(); !errors.Is(err, api.ErrRateLimited) {
+		t.Errorf("Ping over user limit, got %v, expected %s", err, api.ErrRateLimited.Code)
+	}
+	if err := etaC.Ping(); err != nil {
+		t.Errorf("User limit should not affect other users, err=%s", err)
+	}
+
+	server.API.SetRateLimits(api.RateLimits{Global: slow})
+	zetaC.Ping()
+	etaC.Ping()
+	resp, err := http.Get("http://" + address + api.GetPath(api.PingCall))
+	if err != nil {
+		t.Fatalf("Ping failed, err=%s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Over global limit got %d with Retry-After %q, expected %d",
+			resp.StatusCode, resp.Header.Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	if limited := server.API.StatsSnapshot().RateLimited; limited != 3 {
+		t.Errorf("Rate limited requests in stats, got %d, expected 3", limited)
+	}
+
+	server.API.SetRateLimits(api.RateLimits{})
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	// expensive hashing makes checked passwords noticeable
+	defer func(iterations int) { chat.PasswordIterations = iterations }(chat.PasswordIterations)
+	chat.PasswordIterations = 2000000
+
+	var (
+		address = "localhost:9232"
+		server  = NewServer(address)
+		theta   = newManagedUser("theta")
+		thetaC  = api.NewClient(theta, address)
+		slow    = api.RateLimit{Rate: 0.001, Burst: 1}
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(theta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	// request over global limit is refused before password is checked
+	server.API.SetRateLimits(api.RateLimits{Global: slow})
+	if _, err := thetaC.GetRooms(); err != nil {
+		t.Errorf("GetRooms within limit failed, err=%s", err)
+	}
+	start := time.Now()
+	if _, err := thetaC.GetRooms(); !errors.Is(err, api.ErrRateLimited) {
+		t.Errorf("GetRooms over global limit, got %v, expected %s", err, api.ErrRateLimited.Code)
+	}
+	if took := time.Since(start); took > time.Millisecond*200 {
+		t.Errorf("GetRooms over global limit took %s, expected no password check", took)
+	}
+
+	// user over his limit is refused before password is checked,
+	// claiming his name doesn't use up his limit
+	server.API.SetRateLimits(api.RateLimits{User: slow})
+	imposter := newManagedUser("imposter")
+	imposter.AuthID = theta.AuthID
+	if _, err := api.NewClient(imposter, address).GetRooms(); err == nil || errors.Is(err, api.ErrRateLimited) {
+		t.Errorf("GetRooms with wrong token, got %v, expected authentication error", err)
+	}
+	if _, err := thetaC.GetRooms(); err != nil {
+		t.Errorf("GetRooms within limit failed, err=%s", err)
+	}
+	start = time.Now()
+	if _, err := thetaC.GetRooms(); !errors.Is(err, api.ErrRateLimited) {
+		t.Errorf("GetRooms over user limit, got %v, expected %s", err, api.ErrRateLimited.Code)
+	}
+	if took := time.Since(start); took > time.Millisecond*200 {
+		t.Errorf("GetRooms over user limit took %s, expected no password check", took)
+	}
+
+	server.API.SetRateLimits(api.RateLimits{})
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -7,8 +7,15 @@ import (
 
 	"github.com/gorilla/mux"
 	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
 )
 
+// tests authenticate most requests with token,
+// cheap hashing keeps them fast
+func init() {
+	chat.PasswordIterations = 1000
+}
+
 // Server is a test server
 type Server struct {
 	Srv *http.Server
@@ -17,6 +24,11 @@ type Server struct {
 
 // NewServer returns new test server with all api calls
 func NewServer(address string) *Server {
+	return NewServerWithAPI(address, api.NewAPI())
+}
+
+// NewServerWithAPI returns new test server with all calls of serverAPI
+func NewServerWithAPI(address string, serverAPI *api.API) *Server {
 	router := mux.NewRouter()
 
 	httpSrv := &http.Server{
@@ -26,16 +38,54 @@ func NewServer(address string) *Server {
 		Addr:         address,
 	}
 
-	serverAPI := api.NewAPI()
-	AddAPI(router, serverAPI.AddUserRoute())
-	AddAPI(router, serverAPI.PingRoute())
-	AddAPI(router, serverAPI.GetUsersRoute())
-	AddAPI(router, serverAPI.GetRoomsRoute())
-	AddAPI(router, serverAPI.CreateRoomRoute())
-	AddAPI(router, serverAPI.JoinRoomRoute())
-	AddAPI(router, serverAPI.ExitRoomRoute())
-	AddAPI(router, serverAPI.SendMessageRoute())
-	AddAPI(router, serverAPI.ReceiveMessageRoute())
+	routes := []*api.Route{
+		serverAPI.AddUserRoute(),
+		serverAPI.DeleteUserRoute(),
+		serverAPI.UpdateUserRoute(),
+		serverAPI.SuspendUserRoute(),
+		serverAPI.ResumeUserRoute(),
+		serverAPI.LoginRoute(),
+		serverAPI.LogoutRoute(),
+		serverAPI.PingRoute(),
+		serverAPI.GetUsersRoute(),
+		serverAPI.GetRoomsRoute(),
+		serverAPI.CreateRoomRoute(),
+		serverAPI.DeleteRoomRoute(),
+		serverAPI.JoinRoomRoute(),
+		serverAPI.ExitRoomRoute(),
+		serverAPI.SendMessageRoute(),
+		serverAPI.ReceiveMessageRoute(),
+		serverAPI.RoomMessagesRoute(),
+		serverAPI.RoomKickRoute(),
+		serverAPI.RoomBanRoute(),
+		serverAPI.RoomUnbanRoute(),
+		serverAPI.RoomMuteRoute(),
+		serverAPI.RoomPromoteRoute(),
+		serverAPI.RoomDemoteRoute(),
+		serverAPI.RoomTransferRoute(),
+		serverAPI.RoomInviteRoute(),
+		serverAPI.RoomAcceptRoute(),
+		serverAPI.GetInvitesRoute(),
+		serverAPI.ReceiveDirectRoute(),
+		serverAPI.DirectMessagesRoute(),
+		serverAPI.GetUnreadRoute(),
+		serverAPI.WebsocketRoute(),
+		serverAPI.EventsRoute(),
+		serverAPI.MetricsRoute(),
+	}
+	for _, route := range routes {
+		AddAPI(router, serverAPI.Instrument(serverAPI.RateLimit(route)))
+	}
+	// requests between cluster nodes are not limited
+	for _, route := range []*api.Route{
+		serverAPI.ClusterEventsRoute(),
+		serverAPI.ClusterPostRoute(),
+		serverAPI.ClusterRoomRoute(),
+		serverAPI.ClusterUserRoute(),
+		serverAPI.ClusterStateRoute(),
+	} {
+		AddAPI(router, serverAPI.Instrument(route))
+	}
 
 	return &Server{
 		Srv: httpSrv,
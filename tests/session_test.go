@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+// login returns session token of user with AuthID and token
+func login(t *testing.T, address, authID, token string) (string, int) {
+	payload, _ := json.Marshal(&api.User{AuthID: authID, Token: token})
+	resp, err := http.Post("http://"+address+api.GetPath(api.LoginCall), "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatalf("Login failed, err=%s", err)
+	}
+	defer resp.Body.Close()
+	session := &api.Session{}
+	json.NewDecoder(resp.Body).Decode(session)
+	return session.Token, resp.StatusCode
+}
+
+// bearerStatus returns status of GetUsers made with session token
+func bearerStatus(t *testing.T, address, token string) int {
+	request, _ := http.NewRequest(http.MethodGet, "http://"+address+api.GetPath(api.UsersCall), nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GetUsers failed, err=%s", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSessions(t *testing.T) {
+	var (
+		address     = "localhost:9191"
+		server      = NewServer(address)
+		gamma       = newManagedUser("gamma")
+		adminClient = api.NewClient(admin, address)
+		gammaClient = api.NewClient(gamma, address)
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+	server.API.Engine.AddUser(gamma)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if _, status := login(t, address, gamma.AuthID, "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("Login with wrong token, got %d, expected %d", status, http.StatusUnauthorized)
+	}
+	if _, status := login(t, address, "nobody", gamma.Token); status != http.StatusUnauthorized {
+		t.Errorf("Login of nonexist user, got %d, expected %d", status, http.StatusUnauthorized)
+	}
+
+	token, status := login(t, address, gamma.AuthID, gamma.Token)
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("Login failed, got %d", status)
+	}
+	if status := bearerStatus(t, address, token); status != http.StatusOK {
+		t.Errorf("Session token should be accepted, got %d", status)
+	}
+	if status := bearerStatus(t, address, token+"x"); status != http.StatusUnauthorized {
+		t.Errorf("Forged session token, got %d, expected %d", status, http.StatusUnauthorized)
+	}
+
+	// client with session uses it for REST and websocket
+	if err := gammaClient.Login(); err != nil {
+		t.Fatalf("Login failed, err=%s", err)
+	}
+	if _, err := gammaClient.GetRooms(); err != nil {
+		t.Errorf("GetRooms with session failed, err=%s", err)
+	}
+	session, err := gammaClient.OpenWebsocket(false)
+	if err != nil {
+		t.Errorf("OpenWebsocket with session failed, err=%s", err)
+	} else {
+		session.Close()
+	}
+	if err := gammaClient.Logout(); err != nil {
+		t.Errorf("Logout failed, err=%s", err)
+	}
+
+	// rotating token revokes sessions
+	if _, err := adminClient.UpdateUser(gamma.Name, &api.UserUpdate{RotateToken: true}); err != nil {
+		t.Errorf("UpdateUser failed, err=%s", err)
+	}
+	if status := bearerStatus(t, address, token); status != http.StatusUnauthorized {
+		t.Errorf("Revoked session token, got %d, expected %d", status, http.StatusUnauthorized)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestLogout(t *testing.T) {
+	var (
+		address = "localhost:9192"
+		server  = NewServer(address)
+		gamma   = newManagedUser("gamma")
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(gamma)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	token, _ := login(t, address, gamma.AuthID, gamma.Token)
+	other, _ := login(t, address, gamma.AuthID, gamma.Token)
+
+	request, _ := http.NewRequest(http.MethodPost, "http://"+address+api.GetPath(api.LogoutCall), nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Logout failed, err=%v", err)
+	}
+	resp.Body.Close()
+
+	if status := bearerStatus(t, address, token); status != http.StatusUnauthorized {
+		t.Errorf("Token after logout, got %d, expected %d", status, http.StatusUnauthorized)
+	}
+	if status := bearerStatus(t, address, other); status != http.StatusOK {
+		t.Errorf("Other session should stay valid, got %d", status)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+func TestCloseWebsockets(t *testing.T) {
+	var (
+		address = "localhost:9211"
+		server  = NewServer(address)
+		gamma   = newManagedUser("gamma")
+		client  = api.NewClient(gamma, address)
+		sent    = 50
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(gamma)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := client.RoomCreate("closing"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+	session, err := client.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket failed, err=%s", err)
+	}
+	defer session.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	for i := 0; i < sent; i++ {
+		if err := client.SendMessage(&api.Message{Room: "closing", Content: "bye"}); err != nil {
+			t.Fatalf("SendMessage failed, err=%s", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Srv.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown failed, err=%s", err)
+	}
+	<-done
+	if err := server.API.CloseWebsockets(ctx); err != nil {
+		t.Errorf("CloseWebsockets failed, err=%s", err)
+	}
+
+	received := 0
+	for range session.Messages() {
+		received++
+	}
+	if received != sent {
+		t.Errorf("Pending messages not flushed, got %d, expected %d", received, sent)
+	}
+	select {
+	case err := <-session.Errors():
+		if !strings.Contains(err.Error(), "1001") {
+			t.Errorf("Expected going away close frame, got %s", err)
+		}
+	default:
+		t.Errorf("Websocket was not closed")
+	}
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+func newFileServer(t *testing.T, address, dir string) *Server {
+	store, err := chat.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore(%s) failed, err=%s", dir, err)
+	}
+	// compact often to cover both snapshot and log
+	store.SetCompactAfter(3)
+
+	engine, err := chat.NewChatWithStore(store)
+	if err != nil {
+		t.Fatalf("NewChatWithStore failed, err=%s", err)
+	}
+	return NewServerWithAPI(address, api.NewAPIWithEngine(engine))
+}
+
+func TestFileStoreRestart(t *testing.T) {
+	var (
+		address = "localhost:9111"
+		clientA = api.NewClient(admin, address)
+		clientB = api.NewClient(userbeta, address)
+		done    = make(chan bool)
+	)
+
+	dir, err := ioutil.TempDir("", "perfchat")
+	if err != nil {
+		t.Fatalf("TempDir failed, err=%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newFileServer(t, address, dir)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := clientA.AddUser(userbeta); err != nil {
+		t.Errorf("Adduser(%s) failed, err=%s", userbeta.Name, err)
+	}
+	if err := clientA.RoomCreate(roomAdmin.Name + "2"); err != nil {
+		t.Errorf("RoomCreate failed, err=%s", err)
+	}
+	if err := clientB.RoomCreate(roomBeta.Name); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", roomBeta.Name, err)
+	}
+	if err := clientB.RoomJoin(roomAdmin.Name + "2"); err != nil {
+		t.Errorf("RoomJoin failed, err=%s", err)
+	}
+	if err := clientB.RoomExit(roomAdmin.Name + "2"); err != nil {
+		t.Errorf("RoomExit failed, err=%s", err)
+	}
+	if err := clientB.RoomJoin(roomAdmin.Name + "2"); err != nil {
+		t.Errorf("RoomJoin failed, err=%s", err)
+	}
+	if err := clientA.AddUser(dummyuser); err != nil {
+		t.Errorf("Adduser(%s) failed, err=%s", dummyuser.Name, err)
+	}
+	if err := clientA.DeleteUser(dummyuser.Name); err != nil {
+		t.Errorf("DeleteUser(%s) failed, err=%s", dummyuser.Name, err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+	if err := server.API.Engine.Close(); err != nil {
+		t.Errorf("Closing engine failed, err=%s", err)
+	}
+
+	server = newFileServer(t, address, dir)
+	defer server.API.Engine.Close()
+
+	user, err := server.API.Engine.GetUserByName(userbeta.Name)
+	if err != nil {
+		t.Fatalf("User %s was not restored", userbeta.Name)
+	}
+	if user.Role != userbeta.Role || user.AuthID != userbeta.AuthID {
+		t.Errorf("User %s restored as %+v", userbeta.Name, user)
+	}
+	if server.API.Engine.UserExists(dummyuser.Name) || server.API.Engine.RoomExists(dummyuser.Name) {
+		t.Errorf("Deleted user %s was restored", dummyuser.Name)
+	}
+
+	for name, expected := range map[string][]string{
+		roomAdmin.Name + "2": {admin.Name, userbeta.Name},
+		roomBeta.Name:        {userbeta.Name},
+		userbeta.Name:        {userbeta.Name},
+	} {
+		room, err := server.API.Engine.GetRoomByName(name)
+		if err != nil {
+			t.Errorf("Room %s was not restored", name)
+			continue
+		}
+		if room.Creator != expected[0] {
+			t.Errorf("Room %s creator, got %s, expected %s", name, room.Creator, expected[0])
+		}
+		if len(room.Users) != len(expected) {
+			t.Errorf("Room %s users, got %d, expected %d", name, len(room.Users), len(expected))
+			continue
+		}
+		for i, roomUser := range room.Users {
+			if roomUser.Name != expected[i] {
+				t.Errorf("Room %s user, got %s, expected %s", name, roomUser.Name, expected[i])
+			}
+		}
+	}
+}
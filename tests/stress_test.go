@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// TestConcurrentStress runs users changing the same rooms at once,
+// run it with -race to check locking of engine and API
+func TestConcurrentStress(t *testing.T) {
+	var (
+		address     = "localhost:9241"
+		server      = NewServer(address)
+		adminClient = api.NewClient(admin, address)
+		workers     = 8
+		rounds      = 20
+		wg          sync.WaitGroup
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	users := make([]*chat.User, workers)
+	for i := range users {
+		users[i] = newManagedUser(fmt.Sprintf("stress%d", i))
+		if err := adminClient.AddUser(users[i]); err != nil {
+			t.Fatalf("AddUser failed, err=%s", err)
+		}
+	}
+
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user *chat.User) {
+			defer wg.Done()
+			client := api.NewClient(user, address)
+			session, err := client.OpenWebsocket(false)
+			if err != nil {
+				t.Errorf("OpenWebsocket failed, err=%s", err)
+				return
+			}
+			defer session.Close()
+			go func() {
+				for range session.Messages() {
+				}
+			}()
+
+			own := fmt.Sprintf("stressroom%d", i)
+			other := fmt.Sprintf("stressroom%d", (i+1)%workers)
+			peer := users[(i+1)%workers].Name
+			for round := 0; round < rounds; round++ {
+				client.RoomCreate(own)
+				client.RoomJoin(other)
+				client.SendMessage(&api.Message{Room: own, Content: "stress"})
+				client.SendMessage(&api.Message{Room: other, Content: "stress"})
+				session.Send(&api.Message{Room: "main", Content: "stress"})
+				client.SendDirect(&api.Message{To: peer, Content: "stress"})
+				client.RoomKick(own, peer)
+				client.RoomMute(own, peer, time.Millisecond)
+				client.GetRooms()
+				client.ReceiveMessage()
+				client.RoomMessages(own, 0, 0, 10)
+				client.RoomExit(other)
+				if round%5 == 4 {
+					client.RoomDelete(own)
+				}
+			}
+		}(i, user)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; round < rounds; round++ {
+			for _, user := range users {
+				adminClient.UpdateUser(user.Name, &api.UserUpdate{DisplayName: fmt.Sprintf("round%d", round)})
+			}
+			adminClient.SuspendUser(users[0].Name)
+			adminClient.ResumeUser(users[0].Name)
+
+			extra := newManagedUser("stressextra")
+			adminClient.AddUser(extra)
+			server.API.Engine.JoinRoom(extra.Name, "main")
+			adminClient.DeleteUser(extra.Name)
+
+			for _, room := range server.API.Engine.ListRooms() {
+				for _, member := range room.Users {
+					_ = member.DisplayName
+				}
+				_ = room.Moderators[admin.Name]
+			}
+			for _, user := range server.API.Engine.ListUsers() {
+				_ = user.Suspended
+			}
+			server.API.StatsSnapshot()
+		}
+	}()
+	wg.Wait()
+
+	if server.API.Engine.UserExists("stressextra") {
+		t.Errorf("Deleted user still exists")
+	}
+	for _, room := range server.API.Engine.ListRooms() {
+		if room.HasUser("stressextra") {
+			t.Errorf("Deleted user left in room %s", room.Name)
+		}
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
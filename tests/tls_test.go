@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+// startTLSServer starts server with certificates generated in dir,
+// clientCA enables mutual TLS
+func startTLSServer(t *testing.T, address, dir, clientCA string) (*Server, chan bool) {
+	server := NewServer(address)
+	server.API.Engine.AddUser(admin)
+	config, err := api.NewServerTLSConfig(clientCA)
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig failed, err=%s", err)
+	}
+	server.Srv.TLSConfig = config
+
+	done := make(chan bool)
+	go func() {
+		err := server.Srv.ListenAndServeTLS(filepath.Join(dir, api.ServerCertFile), filepath.Join(dir, api.ServerKeyFile))
+		if err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 50)
+	return server, done
+}
+
+func certsDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "perfchat")
+	if err != nil {
+		t.Fatalf("TempDir failed, err=%s", err)
+	}
+	if err := api.GenerateCertificates(dir, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatalf("GenerateCertificates failed, err=%s", err)
+	}
+	return dir
+}
+
+func TestTLS(t *testing.T) {
+	var (
+		address = "localhost:9201"
+		dir     = certsDir(t)
+	)
+	defer os.RemoveAll(dir)
+	server, done := startTLSServer(t, address, dir, "")
+
+	if _, err := api.NewClient(admin, address).GetUsers(); err == nil {
+		t.Errorf("Plain http request to TLS server should fail")
+	}
+	if _, err := api.NewClientWithTLS(admin, address, &tls.Config{}).GetUsers(); err == nil {
+		t.Errorf("Request without trusted CA should fail")
+	}
+
+	config, err := api.NewClientTLSConfig(filepath.Join(dir, api.CACertFile), "", "")
+	if err != nil {
+		t.Fatalf("NewClientTLSConfig failed, err=%s", err)
+	}
+	client := api.NewClientWithTLS(admin, address, config)
+	if _, err := client.GetUsers(); err != nil {
+		t.Errorf("GetUsers over TLS failed, err=%s", err)
+	}
+	session, err := client.OpenWebsocket(false)
+	if err != nil {
+		t.Errorf("OpenWebsocket over TLS failed, err=%s", err)
+	} else {
+		session.Close()
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestMutualTLS(t *testing.T) {
+	var (
+		address = "localhost:9202"
+		dir     = certsDir(t)
+		ca      = filepath.Join(dir, api.CACertFile)
+	)
+	defer os.RemoveAll(dir)
+	server, done := startTLSServer(t, address, dir, ca)
+
+	config, err := api.NewClientTLSConfig(ca, "", "")
+	if err != nil {
+		t.Fatalf("NewClientTLSConfig failed, err=%s", err)
+	}
+	if _, err := api.NewClientWithTLS(admin, address, config).GetUsers(); err == nil {
+		t.Errorf("Request without client certificate should fail")
+	}
+
+	config, err = api.NewClientTLSConfig(ca, filepath.Join(dir, api.ClientCertFile), filepath.Join(dir, api.ClientKeyFile))
+	if err != nil {
+		t.Fatalf("NewClientTLSConfig failed, err=%s", err)
+	}
+	if _, err := api.NewClientWithTLS(admin, address, config).GetUsers(); err != nil {
+		t.Errorf("GetUsers with client certificate failed, err=%s", err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
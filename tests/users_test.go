@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+	"github.com/phob0s-pl/perfchat/chat"
+)
+
+// newManagedUser returns user not shared with other tests,
+// managing users changes them in place
+func newManagedUser(name string) *chat.User {
+	return &chat.User{
+		AuthID: name + "ID",
+		Name:   name,
+		Role:   chat.UserRole,
+		Token:  name + "Token",
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	var (
+		address     = "localhost:9141"
+		server      = NewServer(address)
+		gamma       = newManagedUser("gamma")
+		delta       = newManagedUser("delta")
+		adminClient = api.NewClient(admin, address)
+		gammaClient = api.NewClient(gamma, address)
+		deltaClient = api.NewClient(delta, address)
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	for _, user := range []*chat.User{gamma, delta} {
+		if err := adminClient.AddUser(user); err != nil {
+			t.Errorf("AddUser(%s) failed, err=%s", user.Name, err)
+		}
+	}
+	if err := deltaClient.RoomCreate("deltaroom"); err != nil {
+		t.Errorf("RoomCreate failed, err=%s", err)
+	}
+	if err := gammaClient.RoomJoin("deltaroom"); err != nil {
+		t.Errorf("RoomJoin failed, err=%s", err)
+	}
+
+	if err := deltaClient.DeleteUser(gamma.Name); err == nil {
+		t.Errorf("Deleting user without admin role should fail")
+	}
+	if err := adminClient.DeleteUser(admin.Name); err == nil {
+		t.Errorf("Admin deleting himself should fail")
+	}
+	if err := adminClient.DeleteUser(gamma.Name); err != nil {
+		t.Errorf("DeleteUser(%s) failed, err=%s", gamma.Name, err)
+	}
+	if err := adminClient.DeleteUser(gamma.Name); err == nil {
+		t.Errorf("Deleting nonexist user should fail")
+	}
+
+	if server.API.Engine.UserExists(gamma.Name) {
+		t.Errorf("User %s was not deleted", gamma.Name)
+	}
+	if server.API.Engine.RoomExists(gamma.Name) {
+		t.Errorf("Personal room of %s was not deleted", gamma.Name)
+	}
+	if room, err := server.API.Engine.GetRoomByName("deltaroom"); err != nil || room.HasUser(gamma.Name) {
+		t.Errorf("User %s was not removed from room", gamma.Name)
+	}
+	if _, err := gammaClient.GetUsers(); err == nil {
+		t.Errorf("Deleted user should not be authorized")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestSuspendUser(t *testing.T) {
+	var (
+		address     = "localhost:9142"
+		server      = NewServer(address)
+		gamma       = newManagedUser("gamma")
+		adminClient = api.NewClient(admin, address)
+		gammaClient = api.NewClient(gamma, address)
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := adminClient.AddUser(gamma); err != nil {
+		t.Errorf("AddUser(%s) failed, err=%s", gamma.Name, err)
+	}
+	session, err := gammaClient.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket(%s) failed, err=%s", gamma.Name, err)
+	}
+	defer session.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := adminClient.SuspendUser(gamma.Name); err != nil {
+		t.Errorf("SuspendUser(%s) failed, err=%s", gamma.Name, err)
+	}
+	select {
+	case <-session.Errors():
+	case <-time.After(time.Second):
+		t.Errorf("Websocket of suspended user was not closed")
+	}
+	if _, err := gammaClient.GetUsers(); err == nil {
+		t.Errorf("Suspended user should not use chat")
+	}
+
+	users, err := adminClient.GetUsers()
+	if err != nil {
+		t.Errorf("GetUsers failed, err=%s", err)
+	}
+	for _, user := range users {
+		if user.Name == gamma.Name && !user.Suspended {
+			t.Errorf("User %s should be listed as suspended", gamma.Name)
+		}
+	}
+
+	if err := adminClient.ResumeUser(gamma.Name); err != nil {
+		t.Errorf("ResumeUser(%s) failed, err=%s", gamma.Name, err)
+	}
+	if _, err := gammaClient.GetUsers(); err != nil {
+		t.Errorf("Resumed user should use chat, err=%s", err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestUpdateUser(t *testing.T) {
+	var (
+		address     = "localhost:9143"
+		server      = NewServer(address)
+		gamma       = newManagedUser("gamma")
+		adminClient = api.NewClient(admin, address)
+		done        = make(chan bool)
+	)
+	server.API.Engine.AddUser(admin)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := adminClient.AddUser(gamma); err != nil {
+		t.Errorf("AddUser(%s) failed, err=%s", gamma.Name, err)
+	}
+	oldToken := gamma.Token
+
+	if _, err := adminClient.UpdateUser(gamma.Name, &api.UserUpdate{Role: "overlord"}); err == nil {
+		t.Errorf("Updating user with unknown role should fail")
+	}
+	if _, err := adminClient.UpdateUser("nobody", &api.UserUpdate{DisplayName: "x"}); err == nil {
+		t.Errorf("Updating nonexist user should fail")
+	}
+
+	updated, err := adminClient.UpdateUser(gamma.Name, &api.UserUpdate{
+		Role:        chat.AdminRole,
+		DisplayName: "Gamma Ray",
+		RotateToken: true,
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser(%s) failed, err=%s", gamma.Name, err)
+	}
+	if updated.Role != chat.AdminRole || updated.DisplayName != "Gamma Ray" {
+		t.Errorf("User was not updated, got %+v", updated)
+	}
+	if updated.Token == "" || updated.Token == oldToken {
+		t.Errorf("Token was not rotated, got %q", updated.Token)
+	}
+
+	oldClient := api.NewClient(newManagedUser("gamma"), address)
+	if _, err := oldClient.GetUsers(); err == nil {
+		t.Errorf("Old token should not be accepted")
+	}
+	rotated := newManagedUser("gamma")
+	rotated.Token = updated.Token
+	if _, err := api.NewClient(rotated, address).GetUsers(); err != nil {
+		t.Errorf("Rotated token should be accepted, err=%s", err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/phob0s-pl/perfchat/apiv1"
+)
+
+func TestWebsocketNoAuth(t *testing.T) {
+	var (
+		address = "localhost:9101"
+		server  = NewServer(address)
+		client  = api.NewClient(dummyuser, address)
+		done    = make(chan bool)
+	)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if _, err := client.OpenWebsocket(false); err == nil {
+		t.Errorf("Websocket with no auth should fail")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestWebsocketMessage(t *testing.T) {
+	var (
+		address = "localhost:9102"
+		server  = NewServer(address)
+		clientA = api.NewClient(useralpha, address)
+		clientB = api.NewClient(userbeta, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(useralpha)
+	server.API.Engine.AddUser(userbeta)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := clientA.RoomCreate(roomAlpha.Name); err != nil {
+		t.Errorf("RoomCreate(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	if err := clientB.RoomJoin(roomAlpha.Name); err != nil {
+		t.Errorf("RoomJoin(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	sessionA, err := clientA.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket(%s) failed, err=%s", useralpha.Name, err)
+	}
+	defer sessionA.Close()
+
+	sessionB, err := clientB.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket(%s) failed, err=%s", userbeta.Name, err)
+	}
+	defer sessionB.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	// message posted over REST is pushed to websocket
+	if err := clientB.SendMessage(&api.Message{Content: "rest", Room: roomAlpha.Name}); err != nil {
+		t.Errorf("SendMessage(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	// sender can't be spoofed over websocket
+	if err := sessionB.Send(&api.Message{User: useralpha.Name, Content: "ws", Room: roomAlpha.Name}); err != nil {
+		t.Errorf("Send(%s) failed, err=%s", roomAlpha.Name, err)
+	}
+
+	for _, content := range []string{"rest", "ws"} {
+		select {
+		case msg := <-sessionA.Messages():
+			if msg.Content != content {
+				t.Errorf("msg.content, got %s, expected %s", msg.Content, content)
+			}
+			if msg.User != userbeta.Name {
+				t.Errorf("msg.user, got %s, expected %s", msg.User, userbeta.Name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Message %q not received", content)
+		}
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestWebsocketDisconnect(t *testing.T) {
+	var (
+		address = "localhost:9103"
+		server  = NewServer(address)
+		client  = api.NewClient(useralpha, address)
+		done    = make(chan bool)
+	)
+	server.API.Engine.AddUser(useralpha)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	session, err := client.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket(%s) failed, err=%s", useralpha.Name, err)
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// second connection of the same user replaces first one
+	second, err := client.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket(%s) failed, err=%s", useralpha.Name, err)
+	}
+	defer second.Close()
+
+	select {
+	case err := <-session.Errors():
+		if err == nil {
+			t.Errorf("Expected disconnect error")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Disconnect was not reported")
+	}
+
+	if _, ok := <-session.Messages(); ok {
+		t.Errorf("Messages channel should be closed after disconnect")
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}
+
+func TestWebsocketNotMember(t *testing.T) {
+	var (
+		address  = "localhost:9104"
+		server   = NewServer(address)
+		owner    = newManagedUser("wsowner")
+		outsider = newManagedUser("wsoutsider")
+		ownerC   = api.NewClient(owner, address)
+		outC     = api.NewClient(outsider, address)
+		done     = make(chan bool)
+	)
+	server.API.Engine.AddUser(owner)
+	server.API.Engine.AddUser(outsider)
+
+	go func() {
+		if err := server.Srv.ListenAndServe(); err != http.ErrServerClosed {
+			t.Errorf("Server failed, err=%s", err)
+		}
+		done <- true
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	if err := ownerC.RoomCreate("members"); err != nil {
+		t.Fatalf("RoomCreate failed, err=%s", err)
+	}
+
+	// public room accepts posts only from members
+	if err := outC.SendMessage(&api.Message{Content: "rest", Room: "members"}); !errors.Is(err, api.ErrNotPermit) {
+		t.Errorf("SendMessage of non member, got %v, expected %s", err, api.ErrNotPermit)
+	}
+
+	session, err := outC.OpenWebsocket(false)
+	if err != nil {
+		t.Fatalf("OpenWebsocket failed, err=%s", err)
+	}
+	defer session.Close()
+
+	if err := session.Send(&api.Message{Content: "ws", Room: "members"}); err != nil {
+		t.Fatalf("Send failed, err=%s", err)
+	}
+	select {
+	case err := <-session.Errors():
+		if !errors.Is(err, api.ErrNotPermit) {
+			t.Errorf("Rejected post reported %v, expected %s", err, api.ErrNotPermit)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Rejected post was not reported")
+	}
+
+	if page, err := ownerC.RoomMessages("members", 0, 0, 10); err != nil || len(page) != 0 {
+		t.Errorf("History of room is %+v, err=%v, expected empty", page, err)
+	}
+
+	if err := server.Srv.Close(); err != nil {
+		t.Errorf("Closing server failed, err=%s", err)
+	}
+	<-done
+}